@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"microblog/pkg/cache"
 	"microblog/pkg/handlers"
@@ -14,7 +15,10 @@ func main() {
 	mux := http.NewServeMux()
 	postStore := &repository.MemoryPostStore{BlogPosts: []*models.BlogPost{}}
 	postCache := cache.New([]*models.BlogPost{}, &sync.Mutex{})
-	app := handlers.NewApplication("foo", "foo", postStore, postCache)
+	app := handlers.NewApplication(repository.NewMemoryUserStore(), postStore, postCache)
+	if err := app.EnsureAdmin(context.Background(), "foo", "foo"); err != nil {
+		log.Fatal(err)
+	}
 	handlers.RegisterRoutes(mux, app)
 
 	log.Fatal(http.ListenAndServe(":18080", mux))
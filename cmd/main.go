@@ -1,16 +1,43 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
 	"microblog/pkg/cache"
+	"microblog/pkg/config"
 	"microblog/pkg/handlers"
+	"microblog/pkg/indexnow"
+	"microblog/pkg/logging"
+	"microblog/pkg/media"
+	"microblog/pkg/metrics"
 	"microblog/pkg/models"
+	"microblog/pkg/oidc"
+	"microblog/pkg/ratelimit"
+	"microblog/pkg/render"
 	"microblog/pkg/repository"
+	"microblog/pkg/security"
+	"microblog/pkg/tracing"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+const (
+	// readHeaderTimeout is kept short of the read timeout so slow clients
+	// cannot hold connections open by trickling headers.
+	readHeaderTimeout = 10 * time.Second
+	maxHeaderBytes    = 64 << 10
 )
 
 func main() {
@@ -21,68 +48,235 @@ func main() {
 }
 
 func run() error {
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+	if cfg != nil && cfg.PrintConfig {
+		redacted, err := cfg.Redacted()
+		if err != nil {
+			return err
+		}
+		fmt.Print(redacted)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	if cfg.PrintConfig {
+		return nil
+	}
 
-	if os.Getenv("AUTH_USERNAME") == "" {
-		return errors.New("please set AUTH_USERNAME")
+	level, _ := logging.ParseLevel(cfg.Log.Level)
+	logger, err := logging.New(os.Stderr, level, cfg.Log.Format)
+	if err != nil {
+		return err
 	}
+	slog.SetDefault(logger)
 
-	if os.Getenv("AUTH_PASSWORD") == "" {
-		return errors.New("please set AUTH_PASSWORD")
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		Stdout:      os.Stdout,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to set up tracing due to error: %v", err)
 	}
 
-	host := os.Getenv("DB_HOST")
-	if host == "" {
-		return errors.New("please set DB_HOST")
+	psStore, err := repository.New(cfg.Database.ConnString(), cfg.SchemaPath)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database due to error: %v", err)
 	}
+	defer psStore.Close()
 
-	port := os.Getenv("DB_PORT")
-	if port == "" {
-		return errors.New("please set DB_PORT")
+	cache := cache.New([]*models.BlogPost{}, &sync.Mutex{})
+
+	m := metrics.New()
+	m.RegisterDB(psStore.DB, "blog")
+	m.RegisterCache(cache)
+
+	app := handlers.NewApplication(repository.NewPostgresUserStore(psStore.DB),
+		tracing.InstrumentPostStore(m.InstrumentPostStore(psStore)),
+		cache)
+	if err := app.EnsureAdmin(context.Background(), cfg.Auth.Username, cfg.Auth.Password); err != nil {
+		return fmt.Errorf("unable to create the first admin due to error: %v", err)
+	}
+	app.Sessions = repository.NewPostgresSessionStore(psStore.DB)
+	app.SessionTTL = cfg.Auth.SessionTTL
+	app.Tokens = repository.NewPostgresAPITokenStore(psStore.DB)
+	app.Audit = repository.NewPostgresAuditStore(psStore.DB)
+	// the proxies were checked along with the rest of the config
+	trustedProxies, _ := ratelimit.ParsePrefixes(cfg.RateLimit.TrustedProxies)
+	app.Limits = handlers.RateLimits{
+		Login:          ratelimit.New(ratelimit.Policy(cfg.RateLimit.Login)),
+		API:            ratelimit.New(ratelimit.Policy(cfg.RateLimit.API)),
+		Public:         ratelimit.New(ratelimit.Policy(cfg.RateLimit.Public)),
+		Credential:     ratelimit.New(ratelimit.Policy(cfg.RateLimit.Credential)),
+		Lockout:        ratelimit.NewLockout(cfg.RateLimit.Lockout.Threshold, cfg.RateLimit.Lockout.Base, cfg.RateLimit.Lockout.Max),
+		TrustedProxies: trustedProxies,
+	}
+	for _, role := range cfg.Auth.TwoFactorRoles {
+		app.TwoFactorRoles = append(app.TwoFactorRoles, models.Role(role))
 	}
+	// single sign-on is offered alongside passwords when a provider is given
+	if cfg.OIDC.Issuer != "" {
+		redirectURL := cfg.OIDC.RedirectURL
+		if redirectURL == "" {
+			redirectURL = strings.TrimSuffix(cfg.Site.URL, "/") + "/login/oidc/callback"
+		}
+		provider := oidc.New(cfg.OIDC.Issuer, cfg.OIDC.ClientID, cfg.OIDC.ClientSecret, redirectURL, cfg.OIDC.Scopes)
+		provider.Client.Transport = otelhttp.NewTransport(http.DefaultTransport)
+		app.OIDC = &handlers.OIDCLogin{
+			Provider:    provider,
+			Name:        cfg.OIDC.Name,
+			RoleClaim:   cfg.OIDC.RoleClaim,
+			GroupRoles:  cfg.OIDC.GroupRoles(),
+			DefaultRole: models.Role(cfg.OIDC.DefaultRole),
+		}
+	}
+	app.Headers = handlers.SecurityHeaders{
+		Public: securityPolicy(cfg.Security, cfg.Security.Public),
+		Admin:  securityPolicy(cfg.Security, cfg.Security.Admin),
+		API:    securityPolicy(cfg.Security, cfg.Security.API),
+	}
+	// posts, and their previews, embed iframes from these hosts, and the SSO
+	// form is sent on to the provider
+	for _, host := range cfg.Render.IframeHosts {
+		app.Headers.Public.Allow("frame-src", "https://"+host)
+		app.Headers.Admin.Allow("frame-src", "https://"+host)
+	}
+	if cfg.OIDC.Issuer != "" {
+		if issuer, err := url.Parse(cfg.OIDC.Issuer); err == nil {
+			app.Headers.Admin.Allow("form-action", issuer.Scheme+"://"+issuer.Host)
+		}
+	}
+	app.PersistRendered = cfg.Render.PersistRendered
+	app.SiteURL = cfg.Site.URL
+	app.DefaultImage = cfg.Site.DefaultImage
 
-	password := os.Getenv("DB_PASSWORD")
-	if password == "" {
-		return errors.New("please set DB_PASSWORD")
+	// media is kept in Postgres unless a directory is given
+	var mediaStore repository.MediaStore = repository.NewPostgresMediaStore(psStore.DB)
+	if cfg.Media.Dir != "" {
+		mediaStore, err = repository.NewFileMediaStore(cfg.Media.Dir)
+		if err != nil {
+			return fmt.Errorf("unable to create media directory due to error: %v", err)
+		}
 	}
+	app.Media = media.New(mediaStore)
 
-	user := os.Getenv("DB_USER")
-	if user == "" {
-		return errors.New("please set DB_USER")
+	policy := render.Policy{}
+	if len(cfg.Render.IframeHosts) > 0 {
+		policy = render.Policy{
+			IframeHosts: cfg.Render.IframeHosts,
+			Trusted:     app.MayEmbed,
+		}
 	}
+	app.Renderer = render.New(policy, render.WithImages(app.Media), render.WithTimings(m.ObserveRender))
 
-	dbName := os.Getenv("DB_NAME")
-	if dbName == "" {
-		return errors.New("please set DB_NAME")
+	if cfg.Site.RobotsFile != "" {
+		robots, err := os.ReadFile(cfg.Site.RobotsFile)
+		if err != nil {
+			return fmt.Errorf("unable to read robots file: %v", err)
+		}
+		app.RobotsTxt = string(robots)
 	}
 
-	psqlInfo := repository.GeneratePSQL(host, port, password, user, dbName)
+	// search engines are only notified of new posts when given a key
+	if cfg.IndexNow.Key != "" {
+		app.Notifier = indexnow.New(cfg.IndexNow.Endpoint, cfg.IndexNow.Key)
+		app.Notifier.Client.Transport = otelhttp.NewTransport(http.DefaultTransport)
+	}
 
-	psStore, err := repository.New(psqlInfo, "./sql/create_tables.sql")
-	if err != nil {
-		return fmt.Errorf("unable to connect to database due to error: %v", err)
+	// readiness waits on a warm cache, a failure here is retried as pages
+	// are requested
+	if err := app.WarmCache(context.Background()); err != nil {
+		slog.Error("unable to warm cache", "err", err)
 	}
 
-	cache := cache.New([]*models.BlogPost{}, &sync.Mutex{})
+	mux := http.NewServeMux()
+	handlers.RegisterRoutes(mux, app)
 
-	app := handlers.NewApplication(os.Getenv("AUTH_USERNAME"),
-		os.Getenv("AUTH_PASSWORD"),
-		psStore,
-		cache)
+	servers := []*http.Server{newServer(cfg, logger, app.Handler(logger, m, mux))}
+	listeners := []string{cfg.Addr}
 
-	netListener, err := net.Listen("tcp", ":8080")
-	addr := netListener.Addr().String()
-	if err != nil {
-		return fmt.Errorf("unable to listen due to error: %v", err)
+	// metrics are served on their own port when one is configured, so they
+	// need not be reachable from the internet
+	if cfg.Metrics.Addr != "" {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("GET /metrics", m.Handler())
+		servers = append(servers, newServer(cfg, logger, adminMux))
+		listeners = append(listeners, cfg.Metrics.Addr)
+	} else {
+		mux.Handle("GET /metrics", m.Handler())
 	}
-	netListener.Close()
 
-	mux := http.NewServeMux()
-	handlers.RegisterRoutes(mux, app)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	err = http.ListenAndServe(addr, mux)
-	if err != nil {
-		return fmt.Errorf("unable to listen and serve due to error: %v", err)
+	serveErr := make(chan error, len(servers))
+	for i, srv := range servers {
+		netListener, err := net.Listen("tcp", listeners[i])
+		if err != nil {
+			return fmt.Errorf("unable to listen due to error: %v", err)
+		}
+		go func() {
+			serveErr <- srv.Serve(netListener)
+		}()
+		slog.Info("listening", "addr", netListener.Addr().String())
 	}
 
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("unable to serve due to error: %v", err)
+	case <-ctx.Done():
+	}
+	// a second signal stops the process without waiting
+	stop()
+
+	slog.Info("shutting down")
+	app.SetNotReady()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Shutdown)
+	defer cancel()
+
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			return fmt.Errorf("unable to drain requests due to error: %v", err)
+		}
+	}
+	if err := app.StopJobs(shutdownCtx); err != nil {
+		return fmt.Errorf("unable to finish background jobs due to error: %v", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		return fmt.Errorf("unable to flush traces due to error: %v", err)
+	}
+
+	slog.Info("shut down")
 	return nil
 }
+
+func securityPolicy(s config.Security, h config.Headers) security.Policy {
+	return security.Policy{
+		CSP:               h.CSP,
+		FrameAncestors:    h.FrameAncestors,
+		ReferrerPolicy:    h.ReferrerPolicy,
+		PermissionsPolicy: h.PermissionsPolicy,
+		HSTS:              s.HSTS,
+		ReportOnly:        s.ReportOnly,
+		ReportURI:         handlers.CSPReportPath,
+	}
+}
+
+func newServer(cfg *config.Config, logger *slog.Logger, handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       cfg.Timeouts.Read,
+		WriteTimeout:      cfg.Timeouts.Write,
+		IdleTimeout:       cfg.Timeouts.Idle,
+		MaxHeaderBytes:    maxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+}
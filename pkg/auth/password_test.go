@@ -0,0 +1,37 @@
+package auth_test
+
+import (
+	"microblog/pkg/auth"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPassword(t *testing.T) {
+	hash, err := auth.HashPassword("correct horse")
+	require.NoError(t, err)
+	assert.NotContains(t, hash, "correct horse")
+
+	assert.True(t, auth.CheckPassword(hash, "correct horse"))
+	assert.False(t, auth.CheckPassword(hash, "battery staple"))
+	assert.False(t, auth.CheckPassword("", ""), "users without a password cannot sign in")
+}
+
+func TestValidatePassword(t *testing.T) {
+	assert.NoError(t, auth.ValidatePassword("12345678"))
+	assert.EqualError(t, auth.ValidatePassword("1234567"), "password must be at least 8 characters")
+	assert.EqualError(t, auth.ValidatePassword(strings.Repeat("a", 73)), "password must be at most 72 bytes")
+}
+
+func TestNewToken(t *testing.T) {
+	token, hash, err := auth.NewToken()
+	require.NoError(t, err)
+	assert.Len(t, token, 43)
+	assert.Equal(t, auth.HashToken(token), hash)
+
+	other, _, err := auth.NewToken()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+}
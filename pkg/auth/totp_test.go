@@ -0,0 +1,67 @@
+package auth_test
+
+import (
+	"microblog/pkg/auth"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// the last 6 digits of the 8 digit codes in RFC 6238 appendix B
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		got, err := auth.TOTPCode(rfcSecret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "at %d", unix)
+	}
+
+	_, err := auth.TOTPCode("not base32!", time.Now())
+	assert.Error(t, err)
+}
+
+func TestCheckTOTP(t *testing.T) {
+	secret, err := auth.NewTOTPSecret()
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	code, err := auth.TOTPCode(secret, now)
+	require.NoError(t, err)
+
+	step, ok := auth.CheckTOTP(secret, code, now.Add(25*time.Second), 0)
+	assert.True(t, ok, "codes of the previous period are accepted")
+	assert.Equal(t, auth.TOTPStep(now), step)
+
+	_, ok = auth.CheckTOTP(secret, code, now, step)
+	assert.False(t, ok, "codes are only accepted once")
+	_, ok = auth.CheckTOTP(secret, code, now.Add(2*time.Minute), 0)
+	assert.False(t, ok, "old codes are refused")
+	_, ok = auth.CheckTOTP(secret, "12345", now, 0)
+	assert.False(t, ok)
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := auth.TOTPURI("Ashouri", "ann", rfcSecret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Ashouri:ann?"), uri)
+	assert.Contains(t, uri, "secret="+rfcSecret)
+	assert.Contains(t, uri, "issuer=Ashouri")
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := auth.NewRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+	require.Len(t, hashes, 10)
+	assert.Regexp(t, `^[a-z2-7]{4}-[a-z2-7]{4}$`, codes[0])
+	assert.Equal(t, hashes[0], auth.HashRecoveryCode(" "+strings.ToUpper(codes[0])+" "))
+	assert.NotEqual(t, codes[0], codes[1])
+}
@@ -3,11 +3,15 @@ package cache
 import (
 	"microblog/pkg/models"
 	"sync"
+	"sync/atomic"
 )
 
 type Cache struct {
 	BlogPosts []*models.BlogPost
 	Mutex     *sync.Mutex
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
 }
 
 func New(blogPosts []*models.BlogPost, mutex *sync.Mutex) *Cache {
@@ -44,3 +48,18 @@ func (c *Cache) GetAll() []*models.BlogPost {
 	c.Mutex.Unlock()
 	return blogPosts
 }
+
+// Hit records a lookup answered from the cache.
+func (c *Cache) Hit() {
+	c.hits.Add(1)
+}
+
+// Miss records a lookup which had to go to the store.
+func (c *Cache) Miss() {
+	c.misses.Add(1)
+}
+
+// Stats returns the lookups recorded so far and the number of cached posts.
+func (c *Cache) Stats() (hits, misses uint64, size int) {
+	return c.hits.Load(), c.misses.Load(), len(c.GetAll())
+}
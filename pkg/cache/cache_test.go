@@ -126,3 +126,15 @@ func TestGetAll(t *testing.T) {
 		assert.Nil(t, result)
 	})
 }
+
+func TestStats(t *testing.T) {
+	c := cache.New([]*models.BlogPost{{ID: uuid.New()}}, &sync.Mutex{})
+	c.Hit()
+	c.Miss()
+	c.Miss()
+
+	hits, misses, size := c.Stats()
+	assert.Equal(t, uint64(1), hits)
+	assert.Equal(t, uint64(2), misses)
+	assert.Equal(t, 1, size)
+}
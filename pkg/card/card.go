@@ -0,0 +1,158 @@
+package card
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	Width  = 1200
+	Height = 630
+
+	margin   = 80
+	maxLines = 4
+
+	// maxCachedCards caps the card cache; it is cleared when full.
+	maxCachedCards = 256
+)
+
+// colours match the blog's stylesheet
+var (
+	paper  = color.RGBA{0xf5, 0xf0, 0xe6, 0xff}
+	grid   = color.RGBA{0xeb, 0xe6, 0xdc, 0xff}
+	panel  = color.RGBA{0xff, 0xfa, 0xf2, 0xff}
+	line   = color.RGBA{0xcf, 0xc5, 0xb6, 0xff}
+	ink    = color.RGBA{0x20, 0x28, 0x29, 0xff}
+	accent = color.RGBA{0x9a, 0x3f, 0x2b, 0xff}
+)
+
+// Generator draws the social card images shown when links to the blog are
+// shared and a post has no cover image of its own. Cards are PNGs with the
+// title set in the given font and the site name underneath. Rendered cards
+// are cached by title. It is safe for concurrent use.
+type Generator struct {
+	font *opentype.Font
+	site string
+
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+func New(fontData []byte, site string) (*Generator, error) {
+	f, err := opentype.Parse(fontData)
+	if err != nil {
+		return nil, err
+	}
+	return &Generator{font: f, site: site, cache: map[string][]byte{}}, nil
+}
+
+// Render returns the card for title as a PNG.
+func (g *Generator) Render(title string) ([]byte, error) {
+	g.mu.Lock()
+	out, ok := g.cache[title]
+	g.mu.Unlock()
+	if ok {
+		return out, nil
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(paper), image.Point{}, draw.Src)
+	for i := 0; i < Width; i += 28 {
+		draw.Draw(img, image.Rect(i, 0, i+1, Height), image.NewUniform(grid), image.Point{}, draw.Src)
+	}
+	for i := 0; i < Height; i += 28 {
+		draw.Draw(img, image.Rect(0, i, Width, i+1), image.NewUniform(grid), image.Point{}, draw.Src)
+	}
+
+	inner := image.Rect(margin/2, margin/2, Width-margin/2, Height-margin/2)
+	draw.Draw(img, inner, image.NewUniform(line), image.Point{}, draw.Src)
+	draw.Draw(img, inner.Inset(2), image.NewUniform(panel), image.Point{}, draw.Src)
+
+	// the title is set as large as possible while fitting in maxLines
+	var face font.Face
+	var lines []string
+	for size := 96.0; ; size -= 8 {
+		f, err := g.face(size)
+		if err != nil {
+			return nil, err
+		}
+		lines = wrap(f, title, Width-2*margin)
+		if len(lines) <= maxLines || size <= 40 {
+			face = f
+			break
+		}
+		f.Close()
+	}
+	defer face.Close()
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		lines[maxLines-1] += "…"
+	}
+
+	lineHeight := face.Metrics().Height.Ceil()
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(ink), Face: face}
+	for i, l := range lines {
+		d.Dot = fixed.P(margin, margin+face.Metrics().Ascent.Ceil()+i*lineHeight)
+		d.DrawString(l)
+	}
+
+	siteFace, err := g.face(48)
+	if err != nil {
+		return nil, err
+	}
+	defer siteFace.Close()
+	d = &font.Drawer{Dst: img, Src: image.NewUniform(accent), Face: siteFace}
+	d.Dot = fixed.P(margin, Height-margin)
+	d.DrawString(g.site)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	out = buf.Bytes()
+
+	g.mu.Lock()
+	if len(g.cache) >= maxCachedCards {
+		clear(g.cache)
+	}
+	g.cache[title] = out
+	g.mu.Unlock()
+
+	return out, nil
+}
+
+func (g *Generator) face(size float64) (font.Face, error) {
+	return opentype.NewFace(g.font, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// wrap breaks text into lines no wider than width, splitting on spaces.
+// Words wider than a whole line are left to overflow.
+func wrap(face font.Face, text string, width int) []string {
+	var lines []string
+	var current string
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if current != "" {
+			candidate = current + " " + word
+		}
+		if current != "" && font.MeasureString(face, candidate).Ceil() > width {
+			lines = append(lines, current)
+			current = word
+			continue
+		}
+		current = candidate
+	}
+	if current != "" {
+		lines = append(lines, current)
+	}
+	return lines
+}
@@ -0,0 +1,32 @@
+package card_test
+
+import (
+	"bytes"
+	"image/png"
+	"microblog/pkg/card"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	font, err := os.ReadFile("../handlers/assets/simplifica-sans.ttf")
+	require.NoError(t, err)
+
+	generator, err := card.New(font, "Ashouri")
+	require.NoError(t, err)
+
+	cardPNG, err := generator.Render("A rather long title which has to be wrapped over several lines to fit on the card")
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(cardPNG))
+	require.NoError(t, err)
+	assert.Equal(t, 1200, img.Bounds().Dx())
+	assert.Equal(t, 630, img.Bounds().Dy())
+
+	cached, err := generator.Render("A rather long title which has to be wrapped over several lines to fit on the card")
+	require.NoError(t, err)
+	assert.Equal(t, cardPNG, cached)
+}
@@ -0,0 +1,759 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"microblog/pkg/indexnow"
+	"microblog/pkg/logging"
+	"microblog/pkg/models"
+	"microblog/pkg/ratelimit"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything the server needs to start. It is loaded from, in
+// increasing order of precedence: the defaults, a YAML file, environment
+// variables and command line flags.
+type Config struct {
+	// Addr is the address the server listens on.
+	Addr string `yaml:"addr"`
+	// SchemaPath is the SQL run against the database at startup.
+	SchemaPath string `yaml:"schema_path"`
+
+	Log       Log       `yaml:"log"`
+	Timeouts  Timeouts  `yaml:"timeouts"`
+	Auth      Auth      `yaml:"auth"`
+	OIDC      OIDC      `yaml:"oidc"`
+	RateLimit RateLimit `yaml:"rate_limit"`
+	Security  Security  `yaml:"security"`
+	Database  Database  `yaml:"database"`
+	Site      Site      `yaml:"site"`
+	Media     Media     `yaml:"media"`
+	Render    Render    `yaml:"render"`
+	IndexNow  IndexNow  `yaml:"indexnow"`
+	Metrics   Metrics   `yaml:"metrics"`
+	Tracing   Tracing   `yaml:"tracing"`
+
+	// PrintConfig asks for the effective config to be printed instead of
+	// starting the server. It is only set by the -print-config flag.
+	PrintConfig bool `yaml:"-"`
+}
+
+type Log struct {
+	// Level is debug, info, warn or error. Post contents are only logged at
+	// debug.
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// Timeouts bound how long the server spends on a connection. Durations are
+// written like 30s or 2m.
+type Timeouts struct {
+	// Read covers reading a whole request including the body, so it bounds
+	// how slowly media can be uploaded.
+	Read  time.Duration `yaml:"read"`
+	Write time.Duration `yaml:"write"`
+	Idle  time.Duration `yaml:"idle"`
+	// Shutdown is how long in-flight requests and background jobs are given
+	// to finish when the server is stopped.
+	Shutdown time.Duration `yaml:"shutdown"`
+}
+
+type Auth struct {
+	// Username and Password are the admin created when there are no users
+	// yet. They are not used once any user exists.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// SessionTTL is how long a sign in to the admin area lasts.
+	SessionTTL time.Duration `yaml:"session_ttl"`
+	// TwoFactorRoles have to use two-factor authentication to sign in.
+	TwoFactorRoles []string `yaml:"two_factor_roles"`
+}
+
+// OIDC signs users in to the admin area with an OpenID Connect provider,
+// when Issuer is set.
+type OIDC struct {
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// RedirectURL defaults to /login/oidc/callback on the site URL.
+	RedirectURL string   `yaml:"redirect_url"`
+	Scopes      []string `yaml:"scopes"`
+	// RoleClaim is the claim of the ID token holding the user's groups.
+	RoleClaim string `yaml:"role_claim"`
+	// Roles map groups to roles, written group=role. Users in several get
+	// the most privileged role.
+	Roles []string `yaml:"roles"`
+	// DefaultRole is given to users in none of the groups. Without one they
+	// cannot sign in.
+	DefaultRole string `yaml:"default_role"`
+	// Name is shown on the login button.
+	Name string `yaml:"name"`
+}
+
+// GroupRoles returns the roles of the groups in Roles.
+func (o OIDC) GroupRoles() map[string]models.Role {
+	roles := map[string]models.Role{}
+	for _, entry := range o.Roles {
+		group, role, _ := strings.Cut(entry, "=")
+		roles[strings.TrimSpace(group)] = models.Role(strings.TrimSpace(role))
+	}
+	return roles
+}
+
+// RateLimit throttles clients by IP, with a policy for each group of
+// routes, and sign ins with each handle or API token.
+type RateLimit struct {
+	// TrustedProxies are the addresses or CIDR ranges of proxies whose
+	// X-Forwarded-For header is believed.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// Login covers signing in and the admin pages.
+	Login      Policy  `yaml:"login"`
+	API        Policy  `yaml:"api"`
+	Public     Policy  `yaml:"public"`
+	Credential Policy  `yaml:"credential"`
+	Lockout    Lockout `yaml:"lockout"`
+}
+
+// Policy allows Burst requests at once, refilled at Rate a minute. A rate
+// of 0 turns the limit off.
+type Policy struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// Lockout locks a handle or client IP out after Threshold failed sign ins
+// in a row, for Base at first and twice as long after every further
+// failure, up to Max.
+type Lockout struct {
+	Threshold int           `yaml:"threshold"`
+	Base      time.Duration `yaml:"base"`
+	Max       time.Duration `yaml:"max"`
+}
+
+// Security is the security headers sent with each group of routes.
+type Security struct {
+	// HSTS is the max-age of Strict-Transport-Security, 0 to not send it.
+	HSTS time.Duration `yaml:"hsts"`
+	// ReportOnly reports violations of the CSP instead of enforcing it.
+	ReportOnly bool    `yaml:"report_only"`
+	Public     Headers `yaml:"public"`
+	// Admin covers signing in and the admin pages.
+	Admin Headers `yaml:"admin"`
+	API   Headers `yaml:"api"`
+}
+
+// Headers are the policies of a group of routes. The CSP gets a nonce for
+// inline scripts and styles, frame-ancestors and a report URI added to it.
+type Headers struct {
+	CSP               string `yaml:"csp"`
+	FrameAncestors    string `yaml:"frame_ancestors"`
+	ReferrerPolicy    string `yaml:"referrer_policy"`
+	PermissionsPolicy string `yaml:"permissions_policy"`
+}
+
+// Database is either a full DSN or the individual connection fields, not
+// both.
+type Database struct {
+	DSN      string `yaml:"dsn"`
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"sslmode"`
+	// ID is the Scaleway serverless database ID, passed as a connection
+	// option.
+	ID string `yaml:"id"`
+}
+
+type Site struct {
+	URL          string `yaml:"url"`
+	DefaultImage string `yaml:"default_image"`
+	// RobotsFile replaces the generated robots.txt with the contents of the
+	// file.
+	RobotsFile string `yaml:"robots_file"`
+}
+
+type Media struct {
+	// Dir keeps media on the filesystem instead of in Postgres.
+	Dir string `yaml:"dir"`
+}
+
+type Render struct {
+	PersistRendered bool     `yaml:"persist_rendered"`
+	IframeHosts     []string `yaml:"iframe_hosts"`
+}
+
+type IndexNow struct {
+	Key      string `yaml:"key"`
+	Endpoint string `yaml:"endpoint"`
+}
+
+type Metrics struct {
+	// Addr serves /metrics on a separate port instead of alongside the site.
+	Addr string `yaml:"addr"`
+}
+
+type Tracing struct {
+	// Exporter is none, stdout or otlp.
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the host:port of an OTLP/HTTP collector.
+	Endpoint string `yaml:"endpoint"`
+	// Insecure talks to the collector over plain HTTP, as to a local one.
+	Insecure    bool    `yaml:"insecure"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+func Default() *Config {
+	return &Config{
+		Addr:       ":8080",
+		SchemaPath: "./sql/create_tables.sql",
+		Log: Log{
+			Level:  "info",
+			Format: "text",
+		},
+		Timeouts: Timeouts{
+			Read:     30 * time.Second,
+			Write:    30 * time.Second,
+			Idle:     120 * time.Second,
+			Shutdown: 30 * time.Second,
+		},
+		Auth: Auth{
+			SessionTTL: 24 * time.Hour,
+		},
+		OIDC: OIDC{
+			Scopes:    []string{"email", "profile"},
+			RoleClaim: "groups",
+			Name:      "single sign-on",
+		},
+		RateLimit: RateLimit{
+			Login:      Policy{Rate: 60, Burst: 30},
+			API:        Policy{Rate: 120, Burst: 60},
+			Public:     Policy{Rate: 300, Burst: 100},
+			Credential: Policy{Rate: 120, Burst: 60},
+			Lockout:    Lockout{Threshold: 5, Base: time.Minute, Max: time.Hour},
+		},
+		Security: Security{
+			HSTS: 365 * 24 * time.Hour,
+			Public: Headers{
+				CSP:               "default-src 'self'; script-src 'self'; style-src 'self'; img-src 'self' data: https:; object-src 'none'; base-uri 'self'; form-action 'self'",
+				FrameAncestors:    "'self'",
+				ReferrerPolicy:    "strict-origin-when-cross-origin",
+				PermissionsPolicy: "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+			},
+			Admin: Headers{
+				CSP:               "default-src 'self'; script-src 'self'; style-src 'self'; img-src 'self' data: https:; object-src 'none'; base-uri 'none'; form-action 'self'",
+				FrameAncestors:    "'none'",
+				ReferrerPolicy:    "same-origin",
+				PermissionsPolicy: "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+			},
+			API: Headers{
+				CSP:            "default-src 'none'",
+				FrameAncestors: "'none'",
+				ReferrerPolicy: "no-referrer",
+			},
+		},
+		Database: Database{
+			SSLMode: "require",
+		},
+		IndexNow: IndexNow{
+			Endpoint: indexnow.DefaultEndpoint,
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			Endpoint:    "localhost:4318",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// setting ties a config field to its names in each source. The flag name
+// is also the field's path in the YAML file.
+type setting struct {
+	name   string
+	env    string
+	usage  string
+	secret bool
+	value  any
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{name: "addr", env: "ADDR", usage: "address to listen on", value: &c.Addr},
+		{name: "schema_path", env: "SCHEMA_PATH", usage: "SQL file run against the database at startup", value: &c.SchemaPath},
+		{name: "log.level", env: "LOG_LEVEL", usage: "log level: debug, info, warn or error", value: &c.Log.Level},
+		{name: "log.format", env: "LOG_FORMAT", usage: "log format: text or json", value: &c.Log.Format},
+		{name: "timeouts.read", env: "READ_TIMEOUT", usage: "time allowed to read a request", value: &c.Timeouts.Read},
+		{name: "timeouts.write", env: "WRITE_TIMEOUT", usage: "time allowed to write a response", value: &c.Timeouts.Write},
+		{name: "timeouts.idle", env: "IDLE_TIMEOUT", usage: "time keep-alive connections are kept idle", value: &c.Timeouts.Idle},
+		{name: "timeouts.shutdown", env: "SHUTDOWN_TIMEOUT", usage: "time allowed to drain requests on shutdown", value: &c.Timeouts.Shutdown},
+		{name: "auth.username", env: "AUTH_USERNAME", usage: "handle of the first admin, created when there are no users", value: &c.Auth.Username},
+		{name: "auth.password", env: "AUTH_PASSWORD", usage: "password of the first admin", secret: true, value: &c.Auth.Password},
+		{name: "auth.session_ttl", env: "SESSION_TTL", usage: "how long a sign in to the admin area lasts", value: &c.Auth.SessionTTL},
+		{name: "auth.two_factor_roles", env: "TWO_FACTOR_ROLES", usage: "comma separated roles which have to use two-factor authentication", value: &c.Auth.TwoFactorRoles},
+		{name: "oidc.issuer", env: "OIDC_ISSUER", usage: "OpenID Connect issuer URL, single sign-on to the admin area is offered when set", value: &c.OIDC.Issuer},
+		{name: "oidc.client_id", env: "OIDC_CLIENT_ID", usage: "OpenID Connect client ID", value: &c.OIDC.ClientID},
+		{name: "oidc.client_secret", env: "OIDC_CLIENT_SECRET", usage: "OpenID Connect client secret, empty for public clients", secret: true, value: &c.OIDC.ClientSecret},
+		{name: "oidc.redirect_url", env: "OIDC_REDIRECT_URL", usage: "callback URL registered with the provider, defaults to /login/oidc/callback on site.url", value: &c.OIDC.RedirectURL},
+		{name: "oidc.scopes", env: "OIDC_SCOPES", usage: "comma separated scopes asked for on top of openid", value: &c.OIDC.Scopes},
+		{name: "oidc.role_claim", env: "OIDC_ROLE_CLAIM", usage: "ID token claim holding the groups of the user", value: &c.OIDC.RoleClaim},
+		{name: "oidc.roles", env: "OIDC_ROLES", usage: "comma separated group=role pairs giving groups their role", value: &c.OIDC.Roles},
+		{name: "oidc.default_role", env: "OIDC_DEFAULT_ROLE", usage: "role of users in none of the groups, who are refused when empty", value: &c.OIDC.DefaultRole},
+		{name: "oidc.name", env: "OIDC_NAME", usage: "name of the provider shown on the login button", value: &c.OIDC.Name},
+		{name: "rate_limit.trusted_proxies", env: "TRUSTED_PROXIES", usage: "comma separated addresses or CIDR ranges of proxies whose X-Forwarded-For is believed", value: &c.RateLimit.TrustedProxies},
+		{name: "rate_limit.login.rate", env: "RATE_LIMIT_LOGIN_RATE", usage: "requests a minute each client may make to sign in and to admin pages, 0 for no limit", value: &c.RateLimit.Login.Rate},
+		{name: "rate_limit.login.burst", env: "RATE_LIMIT_LOGIN_BURST", usage: "requests each client may make to sign in and to admin pages at once", value: &c.RateLimit.Login.Burst},
+		{name: "rate_limit.api.rate", env: "RATE_LIMIT_API_RATE", usage: "API requests a minute each client may make, 0 for no limit", value: &c.RateLimit.API.Rate},
+		{name: "rate_limit.api.burst", env: "RATE_LIMIT_API_BURST", usage: "API requests each client may make at once", value: &c.RateLimit.API.Burst},
+		{name: "rate_limit.public.rate", env: "RATE_LIMIT_PUBLIC_RATE", usage: "public page requests a minute each client may make, 0 for no limit", value: &c.RateLimit.Public.Rate},
+		{name: "rate_limit.public.burst", env: "RATE_LIMIT_PUBLIC_BURST", usage: "public page requests each client may make at once", value: &c.RateLimit.Public.Burst},
+		{name: "rate_limit.credential.rate", env: "RATE_LIMIT_CREDENTIAL_RATE", usage: "uses a minute of each handle or API token, 0 for no limit", value: &c.RateLimit.Credential.Rate},
+		{name: "rate_limit.credential.burst", env: "RATE_LIMIT_CREDENTIAL_BURST", usage: "uses of each handle or API token at once", value: &c.RateLimit.Credential.Burst},
+		{name: "rate_limit.lockout.threshold", env: "LOCKOUT_THRESHOLD", usage: "failed sign ins in a row which lock a handle or client out, 0 to never lock", value: &c.RateLimit.Lockout.Threshold},
+		{name: "rate_limit.lockout.base", env: "LOCKOUT_BASE", usage: "how long the first lockout lasts", value: &c.RateLimit.Lockout.Base},
+		{name: "rate_limit.lockout.max", env: "LOCKOUT_MAX", usage: "longest a lockout grows to", value: &c.RateLimit.Lockout.Max},
+		{name: "security.hsts", env: "SECURITY_HSTS", usage: "max-age of Strict-Transport-Security, 0 to not send it", value: &c.Security.HSTS},
+		{name: "security.report_only", env: "CSP_REPORT_ONLY", usage: "report violations of the Content-Security-Policy instead of enforcing it", value: &c.Security.ReportOnly},
+		{name: "security.public.csp", env: "PUBLIC_CSP", usage: "Content-Security-Policy of public pages", value: &c.Security.Public.CSP},
+		{name: "security.public.frame_ancestors", env: "PUBLIC_FRAME_ANCESTORS", usage: "sources allowed to frame public pages, such as 'none' or 'self'", value: &c.Security.Public.FrameAncestors},
+		{name: "security.public.referrer_policy", env: "PUBLIC_REFERRER_POLICY", usage: "Referrer-Policy of public pages", value: &c.Security.Public.ReferrerPolicy},
+		{name: "security.public.permissions_policy", env: "PUBLIC_PERMISSIONS_POLICY", usage: "Permissions-Policy of public pages", value: &c.Security.Public.PermissionsPolicy},
+		{name: "security.admin.csp", env: "ADMIN_CSP", usage: "Content-Security-Policy of sign in and admin pages", value: &c.Security.Admin.CSP},
+		{name: "security.admin.frame_ancestors", env: "ADMIN_FRAME_ANCESTORS", usage: "sources allowed to frame sign in and admin pages, such as 'none' or 'self'", value: &c.Security.Admin.FrameAncestors},
+		{name: "security.admin.referrer_policy", env: "ADMIN_REFERRER_POLICY", usage: "Referrer-Policy of sign in and admin pages", value: &c.Security.Admin.ReferrerPolicy},
+		{name: "security.admin.permissions_policy", env: "ADMIN_PERMISSIONS_POLICY", usage: "Permissions-Policy of sign in and admin pages", value: &c.Security.Admin.PermissionsPolicy},
+		{name: "security.api.csp", env: "API_CSP", usage: "Content-Security-Policy of the API", value: &c.Security.API.CSP},
+		{name: "security.api.frame_ancestors", env: "API_FRAME_ANCESTORS", usage: "sources allowed to frame the API, such as 'none' or 'self'", value: &c.Security.API.FrameAncestors},
+		{name: "security.api.referrer_policy", env: "API_REFERRER_POLICY", usage: "Referrer-Policy of the API", value: &c.Security.API.ReferrerPolicy},
+		{name: "security.api.permissions_policy", env: "API_PERMISSIONS_POLICY", usage: "Permissions-Policy of the API", value: &c.Security.API.PermissionsPolicy},
+		{name: "database.dsn", env: "DATABASE_URL", usage: "full Postgres DSN, instead of the individual database fields", secret: true, value: &c.Database.DSN},
+		{name: "database.host", env: "DB_HOST", usage: "database host", value: &c.Database.Host},
+		{name: "database.port", env: "DB_PORT", usage: "database port", value: &c.Database.Port},
+		{name: "database.user", env: "DB_USER", usage: "database user", value: &c.Database.User},
+		{name: "database.password", env: "DB_PASSWORD", usage: "database password", secret: true, value: &c.Database.Password},
+		{name: "database.name", env: "DB_NAME", usage: "database name", value: &c.Database.Name},
+		{name: "database.sslmode", env: "DB_SSLMODE", usage: "database sslmode", value: &c.Database.SSLMode},
+		{name: "database.id", env: "DB_ID", usage: "Scaleway serverless database ID", value: &c.Database.ID},
+		{name: "site.url", env: "SITE_URL", usage: "canonical base URL of the site", value: &c.Site.URL},
+		{name: "site.default_image", env: "DEFAULT_IMAGE", usage: "image shown in link previews of pages without one", value: &c.Site.DefaultImage},
+		{name: "site.robots_file", env: "ROBOTS_TXT", usage: "file served as robots.txt", value: &c.Site.RobotsFile},
+		{name: "media.dir", env: "MEDIA_DIR", usage: "directory to keep media in instead of Postgres", value: &c.Media.Dir},
+		{name: "render.persist_rendered", env: "PERSIST_RENDERED", usage: "store rendered HTML alongside posts", value: &c.Render.PersistRendered},
+		{name: "render.iframe_hosts", env: "IFRAME_HOSTS", usage: "comma separated hosts posts by admins and editors may embed iframes from", value: &c.Render.IframeHosts},
+		{name: "indexnow.key", env: "INDEXNOW_KEY", usage: "IndexNow key, search engines are notified of new posts when set", secret: true, value: &c.IndexNow.Key},
+		{name: "indexnow.endpoint", env: "INDEXNOW_ENDPOINT", usage: "IndexNow endpoint", value: &c.IndexNow.Endpoint},
+		{name: "metrics.addr", env: "METRICS_ADDR", usage: "separate address to serve /metrics on", value: &c.Metrics.Addr},
+		{name: "tracing.exporter", env: "TRACING_EXPORTER", usage: "where to export traces: none, stdout or otlp", value: &c.Tracing.Exporter},
+		{name: "tracing.endpoint", env: "TRACING_ENDPOINT", usage: "host:port of the OTLP/HTTP collector", value: &c.Tracing.Endpoint},
+		{name: "tracing.insecure", env: "TRACING_INSECURE", usage: "send traces to the collector without TLS", value: &c.Tracing.Insecure},
+		{name: "tracing.sample_ratio", env: "TRACING_SAMPLE_RATIO", usage: "fraction of traces to record, from 0 to 1", value: &c.Tracing.SampleRatio},
+	}
+}
+
+func (s setting) set(v string) error {
+	switch p := s.value.(type) {
+	case *string:
+		*p = v
+	case *bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false, got %q", s.name, v)
+		}
+		*p = b
+	case *int:
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s must be a whole number, got %q", s.name, v)
+		}
+		*p = i
+	case *float64:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("%s must be a number, got %q", s.name, v)
+		}
+		*p = f
+	case *time.Duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s must be a duration such as 30s, got %q", s.name, v)
+		}
+		*p = d
+	case *[]string:
+		*p = nil
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+	}
+	return nil
+}
+
+func (s setting) sources() string {
+	return fmt.Sprintf("%s in the config file, %s or -%s", s.name, s.env, s.name)
+}
+
+// Load builds the config from args, which exclude the program name, and the
+// environment read through lookupEnv. The config file is named by the
+// -config flag or CONFIG_FILE. When the loaded config is invalid it is
+// returned along with the validation errors, so it can still be printed.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	c := Default()
+	settings := c.settings()
+
+	fs := flag.NewFlagSet("microblog", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := fs.String("config", "", "YAML config file, also read from CONFIG_FILE")
+	fs.BoolVar(&c.PrintConfig, "print-config", false, "print the effective config with secrets redacted and exit")
+
+	// flags are recorded and applied last, so they override the file and
+	// environment
+	var flagged []func() error
+	for _, s := range settings {
+		record := func(v string) error {
+			flagged = append(flagged, func() error { return s.set(v) })
+			return nil
+		}
+		if _, ok := s.value.(*bool); ok {
+			fs.BoolFunc(s.name, s.usage+" ("+s.env+")", record)
+		} else {
+			fs.Func(s.name, s.usage+" ("+s.env+")", record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		var usage bytes.Buffer
+		fs.SetOutput(&usage)
+		fs.PrintDefaults()
+		return nil, fmt.Errorf("%w\n\nflags:\n%s", err, usage.String())
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	if *configFile == "" {
+		*configFile, _ = lookupEnv("CONFIG_FILE")
+	}
+	if *configFile != "" {
+		if err := c.readFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := lookupEnv(s.env); ok {
+			if err := s.set(v); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	// LOCAL=local predates DB_SSLMODE and turned TLS off for local databases
+	if v, _ := lookupEnv("LOCAL"); v == "local" {
+		if _, ok := lookupEnv("DB_SSLMODE"); !ok {
+			c.Database.SSLMode = "disable"
+		}
+	}
+
+	for _, set := range flagged {
+		if err := set(); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, c.Validate()
+}
+
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+var (
+	sslModes    = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+	indexNowKey = regexp.MustCompile(`^[a-zA-Z0-9-]{8,128}$`)
+)
+
+// Validate reports every problem with the config at once.
+func (c *Config) Validate() error {
+	var errs []error
+	settings := map[string]setting{}
+	for _, s := range c.settings() {
+		settings[s.name] = s
+	}
+	required := func(name, value string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s is required, set %s", name, settings[name].sources()))
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("addr %q must be a host:port such as :8080", c.Addr))
+	}
+	if c.Metrics.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Addr); err != nil {
+			errs = append(errs, fmt.Errorf("metrics.addr %q must be a host:port such as :9090", c.Metrics.Addr))
+		} else if c.Metrics.Addr == c.Addr {
+			errs = append(errs, errors.New("metrics.addr must differ from addr"))
+		}
+	}
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %w", err))
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		errs = append(errs, fmt.Errorf("log.format %q must be text or json", c.Log.Format))
+	}
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"timeouts.read", c.Timeouts.Read},
+		{"timeouts.write", c.Timeouts.Write},
+		{"timeouts.idle", c.Timeouts.Idle},
+		{"timeouts.shutdown", c.Timeouts.Shutdown},
+		{"auth.session_ttl", c.Auth.SessionTTL},
+	} {
+		if timeout.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %s", timeout.name, timeout.value))
+		}
+	}
+	for _, role := range c.Auth.TwoFactorRoles {
+		if !models.Role(role).Valid() {
+			errs = append(errs, fmt.Errorf("auth.two_factor_roles: unknown role %q", role))
+		}
+	}
+	if c.OIDC.Issuer != "" {
+		errs = append(errs, c.OIDC.validate(c.Site.URL)...)
+	}
+	errs = append(errs, c.RateLimit.validate()...)
+	errs = append(errs, c.Security.validate()...)
+	if _, err := os.Stat(c.SchemaPath); err != nil {
+		errs = append(errs, fmt.Errorf("schema_path: %w", err))
+	}
+
+	db := c.Database
+	if db.DSN != "" {
+		if db.Host != "" || db.Port != "" || db.User != "" || db.Password != "" || db.Name != "" {
+			errs = append(errs, errors.New("database.dsn cannot be combined with database.host, port, user, password or name"))
+		}
+	} else {
+		required("database.host", db.Host)
+		required("database.port", db.Port)
+		required("database.user", db.User)
+		required("database.password", db.Password)
+		required("database.name", db.Name)
+		if port, err := strconv.Atoi(db.Port); db.Port != "" && (err != nil || port < 1 || port > 65535) {
+			errs = append(errs, fmt.Errorf("database.port %q must be a number between 1 and 65535", db.Port))
+		}
+		if !slices.Contains(sslModes, db.SSLMode) {
+			errs = append(errs, fmt.Errorf("database.sslmode %q must be one of %s", db.SSLMode, strings.Join(sslModes, ", ")))
+		}
+	}
+
+	if c.Site.URL != "" {
+		u, err := url.Parse(c.Site.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("site.url %q must be an absolute http(s) URL", c.Site.URL))
+		}
+	}
+	if c.Site.RobotsFile != "" {
+		if _, err := os.Stat(c.Site.RobotsFile); err != nil {
+			errs = append(errs, fmt.Errorf("site.robots_file: %w", err))
+		}
+	}
+
+	if c.IndexNow.Key != "" {
+		if !indexNowKey.MatchString(c.IndexNow.Key) {
+			errs = append(errs, errors.New("indexnow.key must be 8 to 128 letters, digits or dashes"))
+		}
+		if u, err := url.Parse(c.IndexNow.Endpoint); err != nil || u.Host == "" {
+			errs = append(errs, fmt.Errorf("indexnow.endpoint %q must be an absolute URL", c.IndexNow.Endpoint))
+		}
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if _, _, err := net.SplitHostPort(c.Tracing.Endpoint); err != nil {
+			errs = append(errs, fmt.Errorf("tracing.endpoint %q must be a host:port such as localhost:4318", c.Tracing.Endpoint))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter %q must be none, stdout or otlp", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("tracing.sample_ratio must be between 0 and 1, got %g", c.Tracing.SampleRatio))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (o OIDC) validate(siteURL string) []error {
+	var errs []error
+	if u, err := url.Parse(o.Issuer); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("oidc.issuer %q must be an absolute http(s) URL", o.Issuer))
+	}
+	if o.ClientID == "" {
+		errs = append(errs, errors.New("oidc.client_id is required with oidc.issuer"))
+	}
+	if o.RedirectURL == "" && siteURL == "" {
+		errs = append(errs, errors.New("oidc.redirect_url or site.url is required with oidc.issuer"))
+	}
+	for _, entry := range o.Roles {
+		group, role, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(group) == "" {
+			errs = append(errs, fmt.Errorf("oidc.roles: %q must be written group=role", entry))
+		} else if !models.Role(strings.TrimSpace(role)).Valid() {
+			errs = append(errs, fmt.Errorf("oidc.roles: unknown role %q", strings.TrimSpace(role)))
+		}
+	}
+	if o.DefaultRole != "" && !models.Role(o.DefaultRole).Valid() {
+		errs = append(errs, fmt.Errorf("oidc.default_role: unknown role %q", o.DefaultRole))
+	}
+	return errs
+}
+
+func (l RateLimit) validate() []error {
+	var errs []error
+	if _, err := ratelimit.ParsePrefixes(l.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("rate_limit.trusted_proxies: %w", err))
+	}
+	for _, policy := range []struct {
+		name string
+		Policy
+	}{
+		{"login", l.Login},
+		{"api", l.API},
+		{"public", l.Public},
+		{"credential", l.Credential},
+	} {
+		if policy.Rate < 0 {
+			errs = append(errs, fmt.Errorf("rate_limit.%s.rate must not be negative, got %g", policy.name, policy.Rate))
+		}
+		if policy.Rate > 0 && policy.Burst < 1 {
+			errs = append(errs, fmt.Errorf("rate_limit.%s.burst must be at least 1, got %d", policy.name, policy.Burst))
+		}
+	}
+	if l.Lockout.Threshold < 0 {
+		errs = append(errs, fmt.Errorf("rate_limit.lockout.threshold must not be negative, got %d", l.Lockout.Threshold))
+	}
+	if l.Lockout.Threshold > 0 && (l.Lockout.Base <= 0 || l.Lockout.Max < l.Lockout.Base) {
+		errs = append(errs, fmt.Errorf("rate_limit.lockout.base must be positive and no longer than rate_limit.lockout.max, got %s and %s", l.Lockout.Base, l.Lockout.Max))
+	}
+	return errs
+}
+
+func (s Security) validate() []error {
+	var errs []error
+	if s.HSTS < 0 {
+		errs = append(errs, fmt.Errorf("security.hsts must not be negative, got %s", s.HSTS))
+	}
+	for _, group := range []struct {
+		name string
+		Headers
+	}{
+		{"public", s.Public},
+		{"admin", s.Admin},
+		{"api", s.API},
+	} {
+		for _, header := range []struct{ name, value string }{
+			{"csp", group.CSP},
+			{"frame_ancestors", group.FrameAncestors},
+			{"referrer_policy", group.ReferrerPolicy},
+			{"permissions_policy", group.PermissionsPolicy},
+		} {
+			if strings.ContainsAny(header.value, "\r\n") {
+				errs = append(errs, fmt.Errorf("security.%s.%s must be a single line", group.name, header.name))
+			}
+		}
+	}
+	return errs
+}
+
+// ConnString returns the connection string for the database, building one from the
+// individual fields when no DSN was given.
+func (d Database) ConnString() string {
+	if d.DSN != "" {
+		return d.DSN
+	}
+
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		quote(d.Host), quote(d.Port), quote(d.User), quote(d.Password), quote(d.Name), quote(d.SSLMode))
+	if d.ID != "" {
+		dsn += " options=" + quote("databaseid%3D"+d.ID)
+	}
+	return dsn
+}
+
+// quote quotes a connection string value when it is empty or contains
+// spaces, quotes or backslashes.
+func quote(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
+
+const redacted = "REDACTED"
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// Redacted returns the config as YAML with secrets replaced.
+func (c *Config) Redacted() (string, error) {
+	copied := *c
+	copied.Render.IframeHosts = append([]string(nil), c.Render.IframeHosts...)
+
+	for _, s := range copied.settings() {
+		p, ok := s.value.(*string)
+		if !s.secret || !ok || *p == "" {
+			continue
+		}
+		if s.name == "database.dsn" {
+			*p = redactDSN(*p)
+			continue
+		}
+		*p = redacted
+	}
+
+	out, err := yaml.Marshal(&copied)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+		q := u.Query()
+		if q.Has("password") {
+			q.Set("password", redacted)
+			u.RawQuery = q.Encode()
+		}
+		return u.String()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+redacted)
+}
@@ -0,0 +1,271 @@
+package config_test
+
+import (
+	"microblog/pkg/config"
+	"microblog/pkg/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	schema := writeFile(t, "schema.sql", "select 1;")
+	file := writeFile(t, "config.yaml", `
+addr: ":7000"
+schema_path: `+schema+`
+auth:
+  username: file-user
+  password: file-password
+database:
+  host: file-host
+  port: "5432"
+  user: postgres
+  password: postgres
+  name: blog
+render:
+  iframe_hosts: [www.youtube.com]
+`)
+
+	cfg, err := config.Load(
+		[]string{"-config", file, "-database.host", "flag-host", "-render.persist_rendered"},
+		env(map[string]string{
+			"AUTH_USERNAME": "env-user",
+			"DB_HOST":       "env-host",
+			"IFRAME_HOSTS":  "player.vimeo.com, www.youtube.com",
+		}),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, ":7000", cfg.Addr, "file overrides defaults")
+	assert.Equal(t, "file-password", cfg.Auth.Password)
+	assert.Equal(t, "env-user", cfg.Auth.Username, "environment overrides file")
+	assert.Equal(t, "flag-host", cfg.Database.Host, "flags override environment")
+	assert.Equal(t, []string{"player.vimeo.com", "www.youtube.com"}, cfg.Render.IframeHosts)
+	assert.True(t, cfg.Render.PersistRendered)
+	assert.Equal(t, "require", cfg.Database.SSLMode)
+}
+
+func TestLoadValidation(t *testing.T) {
+	_, err := config.Load([]string{"-addr", "8080", "-database.port", "eighty", "-site.url", "example.com"}, env(nil))
+	require.Error(t, err)
+
+	for _, want := range []string{
+		`addr "8080" must be a host:port`,
+		"schema_path:",
+		"database.host is required, set database.host in the config file, DB_HOST or -database.host",
+		`database.port "eighty" must be a number`,
+		"database.password is required",
+		`site.url "example.com" must be an absolute http(s) URL`,
+	} {
+		assert.ErrorContains(t, err, want)
+	}
+}
+
+func TestLoadUnknownFileField(t *testing.T) {
+	file := writeFile(t, "config.yaml", "databse:\n  host: localhost\n")
+
+	_, err := config.Load([]string{"-config", file}, env(nil))
+	assert.ErrorContains(t, err, "field databse not found")
+}
+
+func TestDSN(t *testing.T) {
+	schema := writeFile(t, "schema.sql", "select 1;")
+	vars := map[string]string{
+		"SCHEMA_PATH":   schema,
+		"AUTH_USERNAME": "admin",
+		"AUTH_PASSWORD": "secret",
+		"DATABASE_URL":  "postgres://blog:hunter2@db:5432/blog?sslmode=disable",
+	}
+
+	cfg, err := config.Load(nil, env(vars))
+	require.NoError(t, err)
+	assert.Equal(t, vars["DATABASE_URL"], cfg.Database.ConnString())
+
+	vars["DB_HOST"] = "db"
+	_, err = config.Load(nil, env(vars))
+	assert.ErrorContains(t, err, "database.dsn cannot be combined")
+}
+
+func TestConnString(t *testing.T) {
+	db := config.Database{Host: "db", Port: "5432", User: "blog", Password: `it's a secret`, Name: "blog", SSLMode: "require", ID: "1234"}
+	assert.Equal(t, `host=db port=5432 user=blog password='it\'s a secret' dbname=blog sslmode=require options=databaseid%3D1234`, db.ConnString())
+}
+
+func TestLoadLocal(t *testing.T) {
+	schema := writeFile(t, "schema.sql", "select 1;")
+	cfg, err := config.Load(nil, env(map[string]string{
+		"SCHEMA_PATH":   schema,
+		"AUTH_USERNAME": "admin",
+		"AUTH_PASSWORD": "secret",
+		"DB_HOST":       "127.0.0.1",
+		"DB_PORT":       "5438",
+		"DB_USER":       "postgres",
+		"DB_PASSWORD":   "postgres",
+		"DB_NAME":       "postgres",
+		"LOCAL":         "local",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "disable", cfg.Database.SSLMode)
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := config.Default()
+	cfg.Auth = config.Auth{Username: "admin", Password: "admin-password"}
+	cfg.Database.DSN = "postgres://blog:db-password@db/blog"
+	cfg.IndexNow.Key = "indexnow-key"
+	cfg.OIDC.ClientSecret = "oidc-secret"
+
+	out, err := cfg.Redacted()
+	require.NoError(t, err)
+	assert.Contains(t, out, "username: admin")
+	assert.Contains(t, out, "postgres://blog:REDACTED@db/blog")
+	for _, secret := range []string{"admin-password", "db-password", "indexnow-key", "oidc-secret"} {
+		assert.NotContains(t, out, secret)
+	}
+	assert.Equal(t, "admin-password", cfg.Auth.Password, "the config itself is left alone")
+
+	cfg.Database.DSN = "host=db password='db password' dbname=blog"
+	out, err = cfg.Redacted()
+	require.NoError(t, err)
+	assert.Contains(t, out, "host=db password=REDACTED dbname=blog")
+}
+
+func TestLoadTimeouts(t *testing.T) {
+	_, err := config.Load([]string{"-timeouts.read", "soon"}, env(nil))
+	assert.ErrorContains(t, err, `timeouts.read must be a duration such as 30s, got "soon"`)
+
+	file := writeFile(t, "config.yaml", "timeouts:\n  write: 1m\n  idle: 0s\n")
+	cfg, err := config.Load([]string{"-config", file}, env(nil))
+	assert.ErrorContains(t, err, "timeouts.idle must be positive")
+	assert.Equal(t, time.Minute, cfg.Timeouts.Write)
+	assert.Equal(t, 30*time.Second, cfg.Timeouts.Read)
+	assert.Equal(t, 24*time.Hour, cfg.Auth.SessionTTL)
+
+	_, err = config.Load(nil, env(map[string]string{"SESSION_TTL": "-1h"}))
+	assert.ErrorContains(t, err, "auth.session_ttl must be positive")
+
+	cfg, err = config.Load([]string{"-auth.two_factor_roles", "admin, editor"}, env(nil))
+	assert.NotContains(t, err.Error(), "two_factor_roles")
+	assert.Equal(t, []string{"admin", "editor"}, cfg.Auth.TwoFactorRoles)
+	_, err = config.Load(nil, env(map[string]string{"TWO_FACTOR_ROLES": "owner"}))
+	assert.ErrorContains(t, err, `auth.two_factor_roles: unknown role "owner"`)
+}
+
+func TestLoadTracing(t *testing.T) {
+	cfg, _ := config.Load(nil, env(nil))
+	assert.Equal(t, "none", cfg.Tracing.Exporter)
+	assert.Equal(t, 1.0, cfg.Tracing.SampleRatio)
+
+	cfg, err := config.Load([]string{"-tracing.insecure"}, env(map[string]string{
+		"TRACING_EXPORTER":     "otlp",
+		"TRACING_ENDPOINT":     "collector:4318",
+		"TRACING_SAMPLE_RATIO": "0.25",
+	}))
+	assert.NotContains(t, err.Error(), "tracing")
+	assert.Equal(t, "otlp", cfg.Tracing.Exporter)
+	assert.Equal(t, "collector:4318", cfg.Tracing.Endpoint)
+	assert.True(t, cfg.Tracing.Insecure)
+	assert.Equal(t, 0.25, cfg.Tracing.SampleRatio)
+
+	_, err = config.Load([]string{"-tracing.exporter", "jaeger", "-tracing.sample_ratio", "2"}, env(nil))
+	assert.ErrorContains(t, err, `tracing.exporter "jaeger" must be none, stdout or otlp`)
+	assert.ErrorContains(t, err, "tracing.sample_ratio must be between 0 and 1, got 2")
+
+	_, err = config.Load([]string{"-tracing.sample_ratio", "half"}, env(nil))
+	assert.ErrorContains(t, err, `tracing.sample_ratio must be a number, got "half"`)
+}
+
+func TestLoadOIDC(t *testing.T) {
+	cfg, err := config.Load(nil, env(nil))
+	assert.NotContains(t, err.Error(), "oidc")
+	assert.Equal(t, "groups", cfg.OIDC.RoleClaim)
+
+	cfg, err = config.Load([]string{"-oidc.roles", "blog-admins=admin, writers=author"}, env(map[string]string{
+		"OIDC_ISSUER":       "https://id.example.com",
+		"OIDC_CLIENT_ID":    "microblog",
+		"SITE_URL":          "https://blog.example.com",
+		"OIDC_DEFAULT_ROLE": "viewer",
+	}))
+	assert.NotContains(t, err.Error(), "oidc")
+	assert.Equal(t, map[string]models.Role{"blog-admins": models.RoleAdmin, "writers": models.RoleAuthor}, cfg.OIDC.GroupRoles())
+
+	_, err = config.Load([]string{"-oidc.roles", "writers=owner,editors"}, env(map[string]string{
+		"OIDC_ISSUER":       "id.example.com",
+		"OIDC_DEFAULT_ROLE": "guest",
+	}))
+	for _, want := range []string{
+		`oidc.issuer "id.example.com" must be an absolute http(s) URL`,
+		"oidc.client_id is required",
+		"oidc.redirect_url or site.url is required",
+		`oidc.roles: unknown role "owner"`,
+		`oidc.roles: "editors" must be written group=role`,
+		`oidc.default_role: unknown role "guest"`,
+	} {
+		assert.ErrorContains(t, err, want)
+	}
+}
+
+func TestLoadRateLimit(t *testing.T) {
+	cfg, err := config.Load([]string{"-rate_limit.api.rate", "0"}, env(map[string]string{
+		"TRUSTED_PROXIES":        "10.0.0.0/8, 192.0.2.1",
+		"RATE_LIMIT_LOGIN_BURST": "5",
+		"LOCKOUT_MAX":            "30m",
+	}))
+	assert.NotContains(t, err.Error(), "rate_limit")
+	assert.Equal(t, []string{"10.0.0.0/8", "192.0.2.1"}, cfg.RateLimit.TrustedProxies)
+	assert.Equal(t, config.Policy{Rate: 60, Burst: 5}, cfg.RateLimit.Login)
+	assert.Zero(t, cfg.RateLimit.API.Rate)
+	assert.Equal(t, config.Lockout{Threshold: 5, Base: time.Minute, Max: 30 * time.Minute}, cfg.RateLimit.Lockout)
+
+	_, err = config.Load([]string{"-rate_limit.public.burst", "0", "-rate_limit.lockout.max", "30s"}, env(map[string]string{
+		"TRUSTED_PROXIES":       "proxy.internal",
+		"RATE_LIMIT_LOGIN_RATE": "-1",
+	}))
+	for _, want := range []string{
+		`rate_limit.trusted_proxies: "proxy.internal" is not an address or CIDR range`,
+		"rate_limit.login.rate must not be negative",
+		"rate_limit.public.burst must be at least 1",
+		"rate_limit.lockout.base must be positive and no longer than rate_limit.lockout.max",
+	} {
+		assert.ErrorContains(t, err, want)
+	}
+
+	_, err = config.Load(nil, env(map[string]string{"LOCKOUT_THRESHOLD": "five"}))
+	assert.ErrorContains(t, err, "rate_limit.lockout.threshold must be a whole number")
+}
+
+func TestLoadSecurity(t *testing.T) {
+	cfg, err := config.Load([]string{"-security.hsts", "0"}, env(map[string]string{
+		"CSP_REPORT_ONLY": "true",
+		"API_CSP":         "default-src 'none'; sandbox",
+	}))
+	assert.NotContains(t, err.Error(), "security")
+	assert.Zero(t, cfg.Security.HSTS)
+	assert.True(t, cfg.Security.ReportOnly)
+	assert.Equal(t, "default-src 'none'; sandbox", cfg.Security.API.CSP)
+	assert.Equal(t, "'self'", cfg.Security.Public.FrameAncestors)
+
+	_, err = config.Load([]string{"-security.hsts", "-1h"}, env(map[string]string{
+		"ADMIN_REFERRER_POLICY": "same-origin\r\nSet-Cookie: a=b",
+	}))
+	assert.ErrorContains(t, err, "security.hsts must not be negative")
+	assert.ErrorContains(t, err, "security.admin.referrer_policy must be a single line")
+}
@@ -0,0 +1,89 @@
+// Sets up SimpleMDE, vendored in simplemde/, on every textarea with a
+// data-editor attribute. Its preview renders drafts by POSTing them to the
+// URL in data-preview, so they look exactly as the published post. Without
+// SimpleMDE the textarea is left as it is.
+(function () {
+    "use strict";
+
+    var previewDelay = 600;
+
+    if (typeof SimpleMDE === "undefined") {
+        return;
+    }
+
+    function field(form, name) {
+        var el = form && form.elements.namedItem(name);
+        return el && typeof el.value === "string" ? el.value : "";
+    }
+
+    function retryAfter(resp) {
+        var seconds = parseInt(resp.headers.get("Retry-After"), 10);
+        return (seconds > 0 ? seconds : 1) * 1000;
+    }
+
+    function setup(ta) {
+        var form = ta.form;
+        var timer = null;
+        var sent = 0;
+        var shown = 0;
+        var rendered = "";
+
+        function render(preview) {
+            var id = ++sent;
+            var params = new URLSearchParams();
+            params.set("title", field(form, "title"));
+            params.set("summary", field(form, "summary"));
+            params.set("content", editor.value());
+            if (field(form, "id")) {
+                params.set("id", field(form, "id"));
+            }
+            fetch(ta.dataset.preview, {
+                method: "POST",
+                body: params,
+                credentials: "same-origin",
+                headers: { "X-CSRF-Token": field(form, "csrf_token") }
+            }).then(function (resp) {
+                if (resp.status === 429) {
+                    schedule(preview, retryAfter(resp));
+                    return null;
+                }
+                if (!resp.ok) {
+                    throw new Error(resp.status + " " + resp.statusText);
+                }
+                return resp.json();
+            }).then(function (draft) {
+                // only the latest draft is shown, whichever order they arrive in
+                if (!draft || id < shown) {
+                    return;
+                }
+                shown = id;
+                rendered = draft.ContentHTML;
+                preview.innerHTML = rendered;
+            }).catch(function (err) {
+                preview.textContent = "Preview failed: " + err.message;
+            });
+        }
+
+        function schedule(preview, delay) {
+            clearTimeout(timer);
+            timer = setTimeout(function () { render(preview); }, delay);
+        }
+
+        var editor = new SimpleMDE({
+            element: ta,
+            forceSync: true,
+            spellChecker: false,
+            autoDownloadFontAwesome: false,
+            // the preview is rendered by the server, SimpleMDE shows what it
+            // returns until the draft changes
+            previewRender: function (plainText, preview) {
+                schedule(preview, rendered ? previewDelay : 0);
+                return rendered || "Loading…";
+            }
+        });
+        return editor;
+    }
+
+    var editors = Array.prototype.map.call(document.querySelectorAll("textarea[data-editor]"), setup);
+    window.simplemde = editors[0];
+})();
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"microblog/pkg/logging"
+	"microblog/pkg/models"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// auditPageSize is how many entries the audit page shows at once.
+	auditPageSize = 100
+	// auditExportBatch is how many entries are read at a time when the log
+	// is exported.
+	auditExportBatch = 1000
+)
+
+type viaKey struct{}
+
+// withVia records how the user of a request signed in, for the audit log.
+func withVia(ctx context.Context, via string) context.Context {
+	return context.WithValue(ctx, viaKey{}, via)
+}
+
+// audit appends entry to the audit log, filling in the time, the client and
+// the request. The actor is the signed in user unless entry names one.
+// Failing to record an action does not undo it, so errors are only logged.
+func (app *Application) audit(r *http.Request, entry models.AuditEntry) {
+	entry.Time = app.Now().UTC()
+	entry.IP = app.clientIP(r)
+	entry.RequestID = logging.RequestID(r.Context())
+	if user := currentUser(r); entry.ActorHandle == "" && user != nil {
+		entry.ActorID = user.ID
+		entry.ActorHandle = user.Handle
+	}
+	if via, ok := r.Context().Value(viaKey{}).(string); entry.Via == "" && ok {
+		entry.Via = via
+	}
+
+	if err := app.Audit.Append(r.Context(), &entry); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing audit log", "action", entry.Action, "target", entry.Target, "err", err)
+	}
+}
+
+func postTarget(p *models.BlogPost) string {
+	return "post:" + p.Name
+}
+
+func postSummary(p *models.BlogPost) string {
+	return fmt.Sprintf("title=%q content=%d bytes summary=%d bytes cover=%q", p.Title, len(p.Content), len(p.Summary), p.CoverImage)
+}
+
+func userTarget(u *models.User) string {
+	return "user:" + u.Handle
+}
+
+func userSummary(u *models.User) string {
+	return fmt.Sprintf("name=%q role=%s disabled=%t", u.Name, u.Role, u.Disabled)
+}
+
+func tokenTarget(t *models.APIToken) string {
+	return "token:" + t.ID.String()
+}
+
+func tokenSummary(t *models.APIToken) string {
+	expires := "never"
+	if !t.ExpiresAt.IsZero() {
+		expires = t.ExpiresAt.Format(time.DateOnly)
+	}
+	scopes := make([]string, len(t.Scopes))
+	for i, scope := range t.Scopes {
+		scopes[i] = string(scope)
+	}
+	return fmt.Sprintf("name=%q scopes=%s expires=%s", t.Name, strings.Join(scopes, ","), expires)
+}
+
+// auditFilter reads the filter of the audit page from the query of r.
+// Dates are whole days in UTC, until is inclusive.
+func auditFilter(r *http.Request) (models.AuditFilter, error) {
+	q := r.URL.Query()
+	filter := models.AuditFilter{
+		ActorHandle: strings.TrimSpace(q.Get("actor")),
+		Action:      models.AuditAction(q.Get("action")),
+		Target:      strings.TrimSpace(q.Get("target")),
+	}
+	if filter.Action != "" && !slices.Contains(models.AuditActions, filter.Action) {
+		return filter, fmt.Errorf("unknown action %q", filter.Action)
+	}
+	if v := q.Get("since"); v != "" {
+		since, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			return filter, fmt.Errorf("since must be a date such as 2024-05-01")
+		}
+		filter.Since = since
+	}
+	if v := q.Get("until"); v != "" {
+		until, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			return filter, fmt.Errorf("until must be a date such as 2024-05-31")
+		}
+		filter.Until = until.AddDate(0, 0, 1)
+	}
+	if v := q.Get("before"); v != "" {
+		before, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || before < 1 {
+			return filter, fmt.Errorf("invalid before")
+		}
+		filter.BeforeID = before
+	}
+	return filter, nil
+}
+
+type auditPage struct {
+	Entries []*models.AuditEntry
+	Actions []models.AuditAction
+	// Query is the filter the page was asked for, to fill in the form.
+	Query url.Values
+	// Older links to the next page, when there may be one.
+	Older     string
+	Export    string
+	CSRFToken string
+}
+
+// AuditHandler shows the audit log, newest first, filtered by the query.
+func (app *Application) AuditHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Limit = auditPageSize
+	entries, err := app.Audit.List(r.Context(), filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing audit log", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	page := auditPage{Entries: entries, Actions: models.AuditActions, Query: query, CSRFToken: csrfToken(r)}
+	export := url.Values{}
+	for key, values := range query {
+		if key != "before" {
+			export[key] = values
+		}
+	}
+	page.Export = "/admin/audit/export?" + export.Encode()
+	if len(entries) == auditPageSize {
+		older := url.Values{}
+		for key, values := range export {
+			older[key] = values
+		}
+		older.Set("before", strconv.FormatInt(entries[len(entries)-1].ID, 10))
+		page.Older = "/admin/audit?" + older.Encode()
+	}
+
+	tpl, err := parseTemplates(r, "templates/audit.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error parsing audit.gohtml template", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = tpl.Execute(w, page)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error executing audit.gohtml template", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// AuditExportHandler writes the audit log entries matching the query as
+// JSON lines, newest first.
+func (app *Application) AuditExportHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Limit = auditExportBatch
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s.jsonl"`, app.Now().UTC().Format(time.DateOnly)))
+	enc := json.NewEncoder(w)
+	for first := true; ; first = false {
+		entries, err := app.Audit.List(r.Context(), filter)
+		if err != nil {
+			// the status has gone out with the first batch, so a cut short
+			// export can only be told apart by the log
+			slog.ErrorContext(r.Context(), "Error exporting audit log", "err", err)
+			if first {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		for _, entry := range entries {
+			if err := enc.Encode(entry); err != nil {
+				slog.ErrorContext(r.Context(), "Error encoding audit log", "err", err)
+				return
+			}
+		}
+		if len(entries) < filter.Limit {
+			return
+		}
+		filter.BeforeID = entries[len(entries)-1].ID
+	}
+}
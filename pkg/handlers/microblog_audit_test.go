@@ -0,0 +1,87 @@
+package handlers_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"microblog/pkg/models"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	t.Parallel()
+
+	app, b := newSessionsServer(t, testUser(t, "ada", models.RoleAdmin), testUser(t, "ann", models.RoleAuthor))
+
+	resp := b.do(http.MethodPost, "/login", url.Values{"handle": {"ann"}, "password": {"wrong"}}, nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = postAs(t, b.server, "ann", "/api/post/new", url.Values{"title": {"Hello"}, "content": {"hello"}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	token := createToken(t, b, "ann", url.Values{"name": {"ci"}, "scopes": {"posts:write"}})
+	resp = b.do(http.MethodPost, "/api/post/new", url.Values{"title": {"Nightly"}, "content": {"ci"}}, bearer(token))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	entries, err := app.Audit.List(context.Background(), models.AuditFilter{ActorHandle: "ann"})
+	require.NoError(t, err)
+	var actions []models.AuditAction
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+	}
+	assert.Equal(t, []models.AuditAction{models.AuditPostCreate, models.AuditTokenUse, models.AuditTokenCreate, models.AuditPostCreate}, actions)
+	assert.Equal(t, "token:ci", entries[0].Via)
+	assert.Equal(t, "post:nightly", entries[0].Target)
+	assert.Contains(t, entries[0].After, `title="Nightly"`)
+	assert.Equal(t, "basic", entries[3].Via)
+	assert.Equal(t, "127.0.0.1", entries[3].IP)
+
+	failed, err := app.Audit.List(context.Background(), models.AuditFilter{Action: models.AuditLoginFailed})
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	assert.Equal(t, "user:ann", failed[0].Target)
+	assert.Empty(t, failed[0].ActorHandle, "nobody was signed in")
+
+	// only admins may read the log
+	resp = b.do(http.MethodPost, "/login", url.Values{"handle": {"ann"}, "password": {"ann-password"}}, nil)
+	b.cookie = sessionCookie(resp)
+	resp = b.do(http.MethodGet, "/admin/audit", nil, nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = b.do(http.MethodPost, "/login", url.Values{"handle": {"ada"}, "password": {"ada-password"}}, nil)
+	b.cookie = sessionCookie(resp)
+	resp = b.do(http.MethodGet, "/admin/audit?actor=ann&action=post.create", nil, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "post:nightly")
+	assert.NotContains(t, string(body), "user:ann")
+
+	resp = b.do(http.MethodGet, "/admin/audit?action=post.destroy", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = b.do(http.MethodGet, "/admin/audit/export?action=post.create", nil, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), "attachment")
+	var exported []models.AuditEntry
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		var entry models.AuditEntry
+		require.NoError(t, json.Unmarshal(lines.Bytes(), &entry))
+		exported = append(exported, entry)
+	}
+	require.Len(t, exported, 2)
+	assert.Equal(t, "post:nightly", exported[0].Target)
+	assert.Equal(t, "post:hello", exported[1].Target)
+
+	logins, err := app.Audit.List(context.Background(), models.AuditFilter{Action: models.AuditLogin})
+	require.NoError(t, err)
+	require.Len(t, logins, 2)
+	assert.Equal(t, "ada", logins[0].ActorHandle)
+	assert.Equal(t, "password", logins[0].Via)
+}
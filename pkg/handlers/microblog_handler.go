@@ -1,29 +1,30 @@
 package handlers
 
 import (
-	"bytes"
-	"crypto/sha256"
-	"crypto/subtle"
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
-	"log"
+	"log/slog"
 	"microblog/pkg/cache"
+	"microblog/pkg/card"
+	"microblog/pkg/indexnow"
+	"microblog/pkg/logging"
+	"microblog/pkg/media"
+	"microblog/pkg/metrics"
 	"microblog/pkg/models"
+	"microblog/pkg/render"
 	"microblog/pkg/repository"
+	"microblog/pkg/tracing"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
 
-	texttemplate "text/template"
-
 	"github.com/google/uuid"
-	"github.com/yuin/goldmark"
-	"github.com/yuin/goldmark/extension"
-	"github.com/yuin/goldmark/parser"
-	"github.com/yuin/goldmark/renderer/html"
 )
 
 var (
@@ -31,54 +32,84 @@ var (
 	templates embed.FS
 	//go:embed assets/*
 	assets embed.FS
-	md     goldmark.Markdown
-)
-
-func init() {
-	md = goldmark.New(
-		goldmark.WithExtensions(extension.GFM),
-		goldmark.WithParserOptions(
-			parser.WithAutoHeadingID(),
-		),
-		goldmark.WithRendererOptions(
-			html.WithHardWraps(),
-			html.WithXHTML(),
-			html.WithUnsafe(),
-		),
-	)
-}
 
-var funcMap = texttemplate.FuncMap{
-	"truncateChars": func(charCount int, s string) string {
-		if len(s) <= charCount {
-			return s
-		}
-		return s[:charCount] + "..."
-	},
-}
+	defaultRenderer = render.New(render.Policy{})
+)
 
 const re = `[^a-zA-Z0-9\s]+`
 
 type Application struct {
-	Auth      *Auth
+	// Users can sign in to the admin area with the permissions of their
+	// role.
+	Users repository.UserStore
+	// Sessions keeps the sign ins of browsers, which last SessionTTL.
+	Sessions   repository.SessionStore
+	SessionTTL time.Duration
+	// Tokens are the API tokens scripts call the API with.
+	Tokens repository.APITokenStore
+	// TwoFactorRoles have to use two-factor authentication to sign in.
+	TwoFactorRoles []models.Role
+	// OIDC offers single sign-on to the admin area when it is set.
+	OIDC *OIDCLogin
+	// Audit records who changed what, and every sign in.
+	Audit repository.AuditStore
+	// Limits throttle clients and attempts to sign in.
+	Limits RateLimits
+	// Headers are the security headers of each group of routes.
+	Headers SecurityHeaders
+	// Now is the clock sessions and two-factor codes are checked against.
+	Now       func() time.Time
 	PostStore repository.PostStore
-	Cache     *cache.Cache
-}
-
-type Auth struct {
-	UserName string
-	Password string
+	// Media holds uploaded media. Media routes are only registered when it
+	// is set.
+	Media    *media.Library
+	Cache    *cache.Cache
+	Renderer *render.Renderer
+	// PersistRendered stores the rendered HTML alongside the markdown source
+	// when posts are created or updated, so reads can skip rendering.
+	PersistRendered bool
+	// SiteURL is the canonical base URL, e.g. "https://ashouri.xyz", used
+	// for absolute links in page metadata. When empty the request host is
+	// used.
+	SiteURL string
+	// DefaultImage is shown in link previews of pages without an image of
+	// their own. When empty a card with the site name is generated.
+	DefaultImage string
+	// RobotsTxt replaces the generated robots.txt when set.
+	RobotsTxt string
+	// Notifier is told about posts as they are published or updated. It is
+	// optional.
+	Notifier *indexnow.Notifier
+
+	cards     *card.Generator
+	lifecycle *lifecycle
+	sitemap   sitemapCache
 }
 
-func NewApplication(userName, passWord string, postStore repository.PostStore, cache *cache.Cache) *Application {
+func NewApplication(users repository.UserStore, postStore repository.PostStore, cache *cache.Cache) *Application {
+	fontData, err := assets.ReadFile("assets/simplifica-sans.ttf")
+	if err != nil {
+		panic(err)
+	}
+	cards, err := card.New(fontData, siteName)
+	if err != nil {
+		panic(err)
+	}
 
 	return &Application{
-		Auth: &Auth{
-			UserName: userName,
-			Password: passWord,
-		},
-		PostStore: postStore,
-		Cache:     cache,
+		Users:      users,
+		Sessions:   repository.NewMemorySessionStore(),
+		SessionTTL: defaultSessionTTL,
+		Tokens:     repository.NewMemoryAPITokenStore(),
+		Audit:      repository.NewMemoryAuditStore(),
+		Limits:     defaultRateLimits(),
+		Headers:    defaultSecurityHeaders(),
+		Now:        time.Now,
+		PostStore:  postStore,
+		Cache:      cache,
+		Renderer:   defaultRenderer,
+		cards:      cards,
+		lifecycle:  newLifecycle(),
 	}
 }
 
@@ -90,56 +121,124 @@ func RegisterRoutes(mux *http.ServeMux, app *Application) {
 		panic(err)
 	}
 	mux.Handle("/assets/", http.StripPrefix("/assets/", http.FileServer(http.FS(assetFS))))
+	mux.HandleFunc("/assets/highlight.css", app.HighlightCSS)
+	mux.HandleFunc("/assets/card.png", app.SiteCardHandler)
 	mux.HandleFunc("/", app.Home)
 	mux.HandleFunc("/post/{name}", app.GetBlogPostByName)
+	mux.HandleFunc("/post/{name}/card.png", app.PostCardHandler)
 	mux.HandleFunc("/healthz", app.Healthz)
+	mux.HandleFunc("/livez", app.Livez)
+	mux.HandleFunc("/readyz", app.Readyz)
+	mux.HandleFunc("/sitemap.xml", app.SitemapHandler)
+	mux.HandleFunc("/sitemap/{page}", app.SitemapPageHandler)
+	mux.HandleFunc("/robots.txt", app.RobotsHandler)
+	mux.HandleFunc(CSPReportPath, app.CSPReportHandler)
+	mux.HandleFunc("/author/{handle}", app.AuthorHandler)
+	mux.HandleFunc("/invite/{token}", app.AcceptInviteHandler)
+	mux.HandleFunc("/login", app.LoginHandler)
+	mux.HandleFunc("/login/2fa", app.SecondFactorHandler)
+	mux.HandleFunc("/logout", app.LogoutHandler)
+	if app.OIDC != nil {
+		mux.HandleFunc(oidcPath, app.OIDCLoginHandler)
+		mux.HandleFunc(oidcPath+"/callback", app.OIDCCallbackHandler)
+	}
+	if app.Notifier != nil {
+		mux.HandleFunc(indexnow.KeyPath, app.IndexNowKeyHandler)
+	}
+
+	// admin endpoints, authors may only change their own posts
+	mux.HandleFunc("/admin/post/new", app.authorize(models.PermWritePosts, app.NewPostHandler))
+	mux.HandleFunc("/admin/post/edit/{name}", app.authorize(models.PermWritePosts, app.EditPostHandler))
+	mux.HandleFunc("/admin/preview", app.authorize(models.PermWritePosts, app.PreviewHandler))
+	mux.HandleFunc("/admin/users", app.authorize(models.PermManageUsers, app.UsersHandler))
+	mux.HandleFunc("/admin/tokens", app.authorize(models.PermManageTokens, app.TokensHandler))
+	mux.HandleFunc("/admin/2fa", app.authorize(models.PermManageTwoFactor, app.TwoFactorHandler))
+	// tokens and second factors are only managed by signed in users, not
+	// with API tokens
+	mux.HandleFunc("/admin/tokens/new", app.authorize(models.PermManageTokens, app.CreateTokenHandler))
+	mux.HandleFunc("/admin/tokens/delete/{id}", app.authorize(models.PermManageTokens, app.RevokeTokenHandler))
+	mux.HandleFunc("/admin/2fa/enable", app.authorize(models.PermManageTwoFactor, app.EnableTwoFactorHandler))
+	mux.HandleFunc("/admin/2fa/recovery-codes", app.authorize(models.PermManageTwoFactor, app.RecoveryCodesHandler))
+	mux.HandleFunc("/admin/2fa/disable", app.authorize(models.PermManageTwoFactor, app.DisableTwoFactorHandler))
+	mux.HandleFunc("/admin/audit", app.authorize(models.PermViewAudit, app.AuditHandler))
+	mux.HandleFunc("/admin/audit/export", app.authorize(models.PermViewAudit, app.AuditExportHandler))
+
+	// api endpoints, which also take API tokens
+	mux.HandleFunc("/api/posts", app.authorize(models.PermViewAdmin, app.ListPostsHandler))
+	mux.HandleFunc("/api/post/new", app.authorize(models.PermWritePosts, app.SubmitNewPost))
+	mux.HandleFunc("/api/post/edit", app.authorize(models.PermWritePosts, app.SubmitUpdatePostHandler))
+	mux.HandleFunc("/api/post/delete/{id}", app.authorize(models.PermWritePosts, app.DeletePostHandler))
+	mux.HandleFunc("/api/user/invite", app.authorize(models.PermManageUsers, app.InviteUserHandler))
+	mux.HandleFunc("/api/user/edit", app.authorize(models.PermManageUsers, app.UpdateUserHandler))
+	mux.HandleFunc("/api/user/delete/{id}", app.authorize(models.PermManageUsers, app.DeleteUserHandler))
+
+	mux.HandleFunc("/rebuildcache", app.authorize(models.PermRebuildCache, app.RebuildCacheHandler))
+
+	if app.Media != nil {
+		mux.HandleFunc("/media/{hash}/{filename}", app.GetMediaHandler)
+		mux.HandleFunc("/admin/media", app.authorize(models.PermViewAdmin, app.MediaLibraryHandler))
+		mux.HandleFunc("/api/media/upload", app.authorize(models.PermUploadMedia, app.UploadMediaHandler))
+	}
+}
 
-	// admin endpoints
-	mux.HandleFunc("/admin/post/new", app.basicAuth(app.NewPostHandler))
-	mux.HandleFunc("/admin/post/edit/{name}", app.basicAuth(app.EditPostHandler))
-
-	// api endpoints
-	mux.HandleFunc("/api/post/new", app.basicAuth(app.SubmitNewPost))
-	mux.HandleFunc("/api/post/edit", app.basicAuth(app.SubmitUpdatePostHandler))
-	mux.HandleFunc("/api/post/delete/{id}", app.basicAuth(app.DeletePostHandler))
-
-	mux.HandleFunc("/rebuildcache", app.basicAuth(app.RebuildCacheHandler))
+// Handler wraps mux, with the routes of app, in the middleware every request
+// passes through. Tracing and metrics wrap mux directly, as they name
+// requests by the pattern of their route, which mux only sets on the request
+// it is given and not on the copies other middleware pass on.
+func (app *Application) Handler(logger *slog.Logger, m *metrics.Metrics, mux *http.ServeMux) http.Handler {
+	return logging.Middleware(logger, app.SecureHeaders(app.RateLimit(tracing.Middleware(m.Middleware(mux)))))
 }
 
-func (app *Application) basicAuth(next http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		username, password, ok := r.BasicAuth()
-		if ok {
-			usernameHash := sha256.Sum256([]byte(username))
-			passwordHash := sha256.Sum256([]byte(password))
-			expectedUsernameHash := sha256.Sum256([]byte(app.Auth.UserName))
-			expectedPasswordHash := sha256.Sum256([]byte(app.Auth.Password))
+// routeGroup is a group of routes with their own rate limits and security
+// headers.
+type routeGroup int
 
-			usernameMatch := (subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1)
-			passwordMatch := (subtle.ConstantTimeCompare(passwordHash[:], expectedPasswordHash[:]) == 1)
+const (
+	publicRoutes routeGroup = iota
+	// adminRoutes are signing in and the admin pages.
+	adminRoutes
+	apiRoutes
+)
 
-			if usernameMatch && passwordMatch {
-				next.ServeHTTP(w, r)
-				return
+func groupOf(path string) routeGroup {
+	under := func(prefixes ...string) bool {
+		for _, prefix := range prefixes {
+			if path == prefix || strings.HasPrefix(path, prefix+"/") {
+				return true
 			}
 		}
+		return false
+	}
+	switch {
+	case under("/login", "/logout", "/invite", "/admin"):
+		return adminRoutes
+	case under("/api", "/rebuildcache"):
+		return apiRoutes
+	}
+	return publicRoutes
+}
+
+// adminPage is the data of admin pages without any of their own.
+type adminPage struct {
+	CSRFToken string
+}
 
-		w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-	})
+type editPostPage struct {
+	*models.BlogPost
+	CSRFToken string
 }
 
 func (app *Application) NewPostHandler(w http.ResponseWriter, r *http.Request) {
-	tpl, err := texttemplate.ParseFS(templates, "templates/newpost.gohtml")
+	tpl, err := parseTemplates(r, "templates/newpost.gohtml")
 	if err != nil {
-		log.Printf("Failed to load template: %v", err)
+		slog.ErrorContext(r.Context(), "Failed to load template", "err", err)
 		http.Error(w, "Failed to load template", http.StatusInternalServerError)
 		return
 	}
 
-	err = tpl.Execute(w, nil)
+	err = tpl.Execute(w, adminPage{CSRFToken: csrfToken(r)})
 	if err != nil {
-		log.Printf("Failed to render template: %v", err)
+		slog.ErrorContext(r.Context(), "Failed to render template", "err", err)
 		http.Error(w, "Failed to render template", http.StatusInternalServerError)
 		return
 	}
@@ -153,76 +252,77 @@ func (app *Application) EditPostHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	blog, err := app.PostStore.GetByName(name)
+	blog, err := app.PostStore.GetByName(r.Context(), name)
+	if errors.Is(err, repository.ErrPostNotFound) {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
-		log.Printf("Error getting post by name %s: %v", name, err)
+		slog.ErrorContext(r.Context(), "Error getting post by name", "name", name, "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if !app.canEdit(w, r, blog) {
+		return
+	}
 
-	tpl, err := texttemplate.ParseFS(templates, "templates/editpost.gohtml")
+	tpl, err := parseTemplates(r, "templates/editpost.gohtml")
 	if err != nil {
-		log.Printf("Error parsing editpost.gohtml template: %v", err)
+		slog.ErrorContext(r.Context(), "Error parsing editpost.gohtml template", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = tpl.Execute(w, blog)
+	err = tpl.Execute(w, editPostPage{BlogPost: blog, CSRFToken: csrfToken(r)})
 	if err != nil {
-		log.Printf("Error executing editpost.gohtml template: %v", err)
+		slog.ErrorContext(r.Context(), "Error executing editpost.gohtml template", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
 func (app *Application) Home(w http.ResponseWriter, r *http.Request) {
-	tpl, err := texttemplate.New("home.gohtml").Funcs(funcMap).ParseFS(templates, "templates/home.gohtml")
+	tpl, err := parseTemplates(r, "templates/home.gohtml", "templates/meta.gohtml")
 	if err != nil {
-		log.Printf("Error parsing home.gohtml template: %v", err)
+		slog.ErrorContext(r.Context(), "Error parsing home.gohtml template", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	var blogPosts []*models.BlogPost
+	lookupDone := app.cacheLookup(r.Context(), "home")
 	if len(app.Cache.BlogPosts) < 1 {
 		// cache miss, lets fetch from the database
-		unNormalizedblogPosts, err := app.PostStore.FetchLast10BlogPosts()
+		lookupDone(false)
+		unNormalizedblogPosts, err := app.PostStore.FetchLast10BlogPosts(r.Context())
 		if err != nil {
-			log.Printf("Error fetching last 10 blog posts: %v", err)
+			slog.ErrorContext(r.Context(), "Error fetching last 10 blog posts", "err", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		// inflate the cache with normalized posts
-		normalizedBlogPosts := normalizeBlogPost(unNormalizedblogPosts)
+		normalizedBlogPosts := app.Renderer.Posts(r.Context(), unNormalizedblogPosts)
 		app.Cache.Load(normalizedBlogPosts)
 		blogPosts = normalizedBlogPosts
 	} else {
 		//cache is already hydrated
 		blogPosts = app.Cache.GetAll()
+		lookupDone(true)
 	}
 
 	// cache hit - posts are already normalized, just use them directly from the cache
-	err = tpl.Execute(w, blogPosts)
+	err = tpl.Execute(w, app.homePage(r, blogPosts))
 	if err != nil {
-		log.Printf("Error executing home.gohtml template: %v", err)
+		slog.ErrorContext(r.Context(), "Error executing home.gohtml template", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
-func (app *Application) Healthz(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	if _, err := app.PostStore.GetAll(); err != nil {
-		http.Error(w, "unhealthy", http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, "ok")
+func (app *Application) HighlightCSS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(render.HighlightCSS())
 }
 
 func (app *Application) GetBlogPostByID(w http.ResponseWriter, r *http.Request) {
@@ -230,15 +330,19 @@ func (app *Application) GetBlogPostByID(w http.ResponseWriter, r *http.Request)
 	queryParams := r.URL.Query()
 	id := queryParams.Get("id")
 
-	blog, err := app.PostStore.GetByID(uuid.MustParse(id))
+	blog, err := app.PostStore.GetByID(r.Context(), uuid.MustParse(id))
+	if errors.Is(err, repository.ErrPostNotFound) {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
-		log.Printf("Error getting blog post by ID %s: %v", id, err)
+		slog.ErrorContext(r.Context(), "Error getting blog post by ID", "id", id, "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	resp, err := json.Marshal(blog)
 	if err != nil {
-		log.Printf("Error marshalling blog post ID %s: %v", id, err)
+		slog.ErrorContext(r.Context(), "Error marshalling blog post", "id", id, "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -254,24 +358,26 @@ func (app *Application) GetBlogPostByName(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	lookupDone := app.cacheLookup(r.Context(), "post")
 	app.Cache.Lock()
 	if len(app.Cache.BlogPosts) > 0 {
 		for _, cachedPost := range app.Cache.BlogPosts {
 			//blog exists in cache
 			if cachedPost.Name == name {
-				log.Printf("GetBlogPostByName cache hit")
+				slog.DebugContext(r.Context(), "GetBlogPostByName cache hit", "name", name)
+				lookupDone(true)
 
-				tpl, err := texttemplate.New("blogpost.gohtml").Funcs(funcMap).ParseFS(templates, "templates/blogpost.gohtml")
+				tpl, err := parseTemplates(r, "templates/blogpost.gohtml", "templates/meta.gohtml")
 				if err != nil {
-					log.Printf("Error parsing blogpost.gohtml template: %v", err)
+					slog.ErrorContext(r.Context(), "Error parsing blogpost.gohtml template", "err", err)
 					app.Cache.Unlock()
 					http.Error(w, err.Error(), http.StatusInternalServerError)
 					return
 				}
 
-				err = tpl.Execute(w, cachedPost) // Use cached post directly
+				err = tpl.Execute(w, app.postPage(r, cachedPost)) // Use cached post directly
 				if err != nil {
-					log.Printf("Error executing blogpost.gohtml template: %v", err)
+					slog.ErrorContext(r.Context(), "Error executing blogpost.gohtml template", "err", err)
 					app.Cache.Unlock()
 					http.Error(w, err.Error(), http.StatusInternalServerError)
 					return
@@ -285,36 +391,44 @@ func (app *Application) GetBlogPostByName(w http.ResponseWriter, r *http.Request
 	app.Cache.Unlock()
 
 	// cache miss, lets fetch from the database
-	unNormalizedblogPosts, err := app.PostStore.FetchLast10BlogPosts()
+	lookupDone(false)
+	unNormalizedblogPosts, err := app.PostStore.FetchLast10BlogPosts(r.Context())
 	if err != nil {
-		log.Printf("Error fetching last 10 blog posts: %v", err)
+		slog.ErrorContext(r.Context(), "Error fetching last 10 blog posts", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// inflate the cache with normalized posts
-	normalizedBlogPosts := normalizeBlogPost(unNormalizedblogPosts)
+	normalizedBlogPosts := app.Renderer.Posts(r.Context(), unNormalizedblogPosts)
 	app.Cache.Load(normalizedBlogPosts)
 
-	var blog *models.BlogPost
-	for _, cachedBlogPost := range app.Cache.BlogPosts {
-		if cachedBlogPost.Name == name {
-			blog = cachedBlogPost
+	blog := app.cachedPost(name)
+	if blog == nil {
+		// older posts are not cached
+		stored, err := app.PostStore.GetByName(r.Context(), name)
+		if errors.Is(err, repository.ErrPostNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error getting post by name", "name", name, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		blog = app.Renderer.Posts(r.Context(), []*models.BlogPost{stored})[0]
 	}
 
-	log.Printf("Processed Content for %s: %s", name, blog.Content)
-
-	tpl, err := texttemplate.New("blogpost.gohtml").Funcs(funcMap).ParseFS(templates, "templates/blogpost.gohtml")
+	tpl, err := parseTemplates(r, "templates/blogpost.gohtml", "templates/meta.gohtml")
 	if err != nil {
-		log.Printf("Error parsing blogpost.gohtml template: %v", err)
+		slog.ErrorContext(r.Context(), "Error parsing blogpost.gohtml template", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = tpl.Execute(w, blog)
+	err = tpl.Execute(w, app.postPage(r, blog))
 	if err != nil {
-		log.Printf("Error executing blogpost.gohtml template: %v", err)
+		slog.ErrorContext(r.Context(), "Error executing blogpost.gohtml template", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -339,6 +453,15 @@ func (app *Application) SubmitNewPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	summary := r.FormValue("summary")
+	description := r.FormValue("description")
+
+	coverImage := r.FormValue("cover_image")
+	if !validCoverImage(coverImage) {
+		http.Error(w, "Cover image must be a path or an http(s) URL", http.StatusBadRequest)
+		return
+	}
+
 	ID := uuid.New()
 
 	titleCopy := title
@@ -348,36 +471,49 @@ func (app *Application) SubmitNewPost(w http.ResponseWriter, r *http.Request) {
 	rexp := regexp.MustCompile(re)
 	name = rexp.ReplaceAllString(name, "")
 
+	author := currentUser(r)
 	now := time.Now().UTC()
 	newBlogPost := &models.BlogPost{
 		ID:            ID,
+		AuthorID:      author.ID,
+		AuthorHandle:  author.Handle,
+		AuthorName:    author.Name,
 		Name:          name,
 		Title:         title,
 		Content:       content,
+		Summary:       summary,
+		CoverImage:    coverImage,
+		Description:   description,
 		CreatedAt:     now,
 		UpdatedAt:     now,
 		FormattedDate: formattedDate(now),
 	}
+	if app.PersistRendered {
+		app.Renderer.Prepare(r.Context(), newBlogPost)
+	}
 
-	err = app.PostStore.Create(newBlogPost)
+	err = app.PostStore.Create(r.Context(), newBlogPost)
 	if err != nil {
-		log.Printf("Error creating post: %v", err)
+		slog.ErrorContext(r.Context(), "Error creating post", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	unNormalizedblogPosts, err := app.PostStore.FetchLast10BlogPosts()
+	app.audit(r, models.AuditEntry{Action: models.AuditPostCreate, Target: postTarget(newBlogPost), After: postSummary(newBlogPost)})
+	unNormalizedblogPosts, err := app.PostStore.FetchLast10BlogPosts(r.Context())
 	if err != nil {
 		http.Error(w, "unable to fetch last 10 blog posts", http.StatusInternalServerError)
 		return
 	}
 
 	// inflate the cache with normalized posts
-	normalizedBlogPosts := normalizeBlogPost(unNormalizedblogPosts)
+	normalizedBlogPosts := app.Renderer.Posts(r.Context(), unNormalizedblogPosts)
 	app.Cache.Load(normalizedBlogPosts)
+	app.sitemap.invalidate()
+	app.notify(r, "/post/"+url.PathEscape(newBlogPost.Name), "/")
 
 	err = json.NewEncoder(w).Encode(newBlogPost)
 	if err != nil {
-		log.Printf("Error encoding new blog post: %v", err)
+		slog.ErrorContext(r.Context(), "Error encoding new blog post", "err", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -385,10 +521,26 @@ func (app *Application) SubmitNewPost(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Post submitted successfully!")
 }
 
+// ListPostsHandler returns every post as JSON.
+func (app *Application) ListPostsHandler(w http.ResponseWriter, r *http.Request) {
+	blogPosts, err := app.PostStore.GetAll(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error getting all posts", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(blogPosts)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding posts", "err", err)
+	}
+}
+
 func (app *Application) SubmitUpdatePostHandler(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		log.Printf("Unable to parse form: %v", err)
+		slog.WarnContext(r.Context(), "Unable to parse form", "err", err)
 		http.Error(w, "Unable to parse form", http.StatusBadRequest)
 		return
 	}
@@ -396,40 +548,73 @@ func (app *Application) SubmitUpdatePostHandler(w http.ResponseWriter, r *http.R
 	id := r.FormValue("id")
 	title := r.FormValue("title")
 	content := r.FormValue("content")
+	summary := r.FormValue("summary")
+	description := r.FormValue("description")
+	coverImage := r.FormValue("cover_image")
 
-	log.Printf("Updating Post - ID: %s, Title: %s, Content: %s\n", id, title, content)
+	slog.InfoContext(r.Context(), "Updating post", "id", id)
+	slog.DebugContext(r.Context(), "Updated post content", "id", id, "title", title, "content", content)
 
 	idUUID, err := uuid.Parse(id)
 	if err != nil {
-		log.Printf("Invalid ID for update: %s, error: %v", id, err)
+		slog.WarnContext(r.Context(), "Invalid ID for update", "id", id, "err", err)
 		http.Error(w, "Invalid ID", http.StatusBadRequest)
 		return
 	}
 
+	if !validCoverImage(coverImage) {
+		http.Error(w, "Cover image must be a path or an http(s) URL", http.StatusBadRequest)
+		return
+	}
+
+	existing, err := app.PostStore.GetByID(r.Context(), idUUID)
+	if errors.Is(err, repository.ErrPostNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error getting post to update", "id", id, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !app.canEdit(w, r, existing) {
+		return
+	}
+
 	now := time.Now().UTC()
 	newBlogPost := &models.BlogPost{
-		ID:        idUUID,
-		Title:     title,
-		Content:   content,
-		UpdatedAt: now,
+		ID:          idUUID,
+		Title:       title,
+		Content:     content,
+		Summary:     summary,
+		CoverImage:  coverImage,
+		Description: description,
+		UpdatedAt:   now,
+	}
+	if app.PersistRendered {
+		app.Renderer.Prepare(r.Context(), newBlogPost)
 	}
 
-	err = app.PostStore.Update(newBlogPost)
+	err = app.PostStore.Update(r.Context(), newBlogPost)
 	if err != nil {
-		log.Printf("Error updating post ID %s: %v", id, err)
+		slog.ErrorContext(r.Context(), "Error updating post", "id", id, "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	app.audit(r, models.AuditEntry{Action: models.AuditPostUpdate, Target: postTarget(existing), Before: postSummary(existing), After: postSummary(newBlogPost)})
 
-	unNormalizedblogPosts, err := app.PostStore.FetchLast10BlogPosts()
+	unNormalizedblogPosts, err := app.PostStore.FetchLast10BlogPosts(r.Context())
 	if err != nil {
 		http.Error(w, "unable to fetch last 10 blog posts", http.StatusInternalServerError)
 		return
 	}
 
 	// inflate the cache with normalized posts
-	normalizedBlogPosts := normalizeBlogPost(unNormalizedblogPosts)
+	normalizedBlogPosts := app.Renderer.Posts(r.Context(), unNormalizedblogPosts)
 	app.Cache.Load(normalizedBlogPosts)
+	app.sitemap.invalidate()
+	// updates keep the name, which is not part of the form
+	app.notify(r, "/post/"+url.PathEscape(existing.Name))
 	fmt.Fprintf(w, "cache reloaded")
 	fmt.Fprintf(w, "Post updated successfully!")
 }
@@ -445,19 +630,35 @@ func (app *Application) DeletePostHandler(w http.ResponseWriter, r *http.Request
 
 	idUUID, err := uuid.Parse(id)
 	if err != nil {
-		log.Printf("Invalid ID for delete: %s, error: %v", id, err)
+		slog.WarnContext(r.Context(), "Invalid ID for delete", "id", id, "err", err)
 		http.Error(w, "Invalid ID", http.StatusBadRequest)
 		return
 	}
 
-	err = app.PostStore.Delete(idUUID)
+	existing, err := app.PostStore.GetByID(r.Context(), idUUID)
+	if errors.Is(err, repository.ErrPostNotFound) {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
-		log.Printf("Error deleting post ID %s: %v", id, err)
+		slog.ErrorContext(r.Context(), "Error getting post to delete", "id", id, "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if !app.canEdit(w, r, existing) {
+		return
+	}
+
+	err = app.PostStore.Delete(r.Context(), idUUID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting post", "id", id, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.audit(r, models.AuditEntry{Action: models.AuditPostDelete, Target: postTarget(existing), Before: postSummary(existing)})
 
 	app.Cache.Invalidate()
+	app.sitemap.invalidate()
 	fmt.Fprintf(w, "Post deleted successfully!")
 }
 
@@ -467,61 +668,44 @@ func (app *Application) RebuildCacheHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	allPosts, err := app.rebuildCache()
+	allPosts, err := app.rebuildCache(r.Context())
 	if err != nil {
 		http.Error(w, "Failed to rebuild cache: could not fetch posts", http.StatusInternalServerError)
 		return
 	}
+	app.audit(r, models.AuditEntry{Action: models.AuditCacheRebuild, Target: "cache", After: fmt.Sprintf("%d posts", len(allPosts))})
 
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "Cache invalidated and rebuilt successfully with %d posts.\n", len(allPosts))
 }
 
-func (app *Application) rebuildCache() ([]*models.BlogPost, error) {
+func (app *Application) rebuildCache(ctx context.Context) ([]*models.BlogPost, error) {
 	app.Cache.Invalidate()
-	log.Println("Cache invalidated.")
+	app.sitemap.invalidate()
+	slog.InfoContext(ctx, "Cache invalidated")
 
-	allPosts, err := app.PostStore.FetchLast10BlogPosts()
+	allPosts, err := app.PostStore.FetchLast10BlogPosts(ctx)
 	if err != nil {
-		log.Printf("Error fetching posts from store to rebuild cache: %v", err)
+		slog.ErrorContext(ctx, "Error fetching posts from store to rebuild cache", "err", err)
 		return nil, err
 	}
 
-	app.Cache.Load(allPosts)
-	log.Printf("Cache rebuilt successfully with %d posts.", len(allPosts))
+	app.Cache.Load(app.Renderer.Posts(ctx, allPosts))
+	app.lifecycle.cacheWarmed.Store(true)
+	slog.InfoContext(ctx, "Cache rebuilt", "posts", len(allPosts))
 	return allPosts, err
 }
 
-func normalizeBlogPost(unNormalizedBlogPosts []*models.BlogPost) []*models.BlogPost {
-
-	normalizedBlogPosts := make([]*models.BlogPost, len(unNormalizedBlogPosts))
-
-	for i := range unNormalizedBlogPosts {
-		normalizedBlogPosts[i] = &models.BlogPost{
-			ID:            unNormalizedBlogPosts[i].ID,
-			Title:         unNormalizedBlogPosts[i].Title,
-			TitleNonHTML:  unNormalizedBlogPosts[i].Title,
-			Content:       unNormalizedBlogPosts[i].Content,
-			Name:          unNormalizedBlogPosts[i].Name,
-			CreatedAt:     unNormalizedBlogPosts[i].CreatedAt,
-			UpdatedAt:     unNormalizedBlogPosts[i].UpdatedAt,
-			FormattedDate: unNormalizedBlogPosts[i].FormattedDate,
-		}
-		var contentBuf bytes.Buffer
-		if err := md.Convert([]byte(normalizedBlogPosts[i].Content), &contentBuf); err != nil {
-			log.Printf("Error converting blog post content to HTML: %v\n", err)
-		} else {
-			normalizedBlogPosts[i].Content = contentBuf.String()
-		}
-		var titleBuf bytes.Buffer
-		if err := md.Convert([]byte(normalizedBlogPosts[i].Title), &titleBuf); err != nil {
-			log.Printf("Error converting blog post title to HTML: %v\n", err)
-		} else {
-			normalizedBlogPosts[i].Title = titleBuf.String()
-		}
+// canEdit checks the signed in user may change blogPost, writing the error
+// response when they may not. Stores return a post without an ID when there
+// is none with the requested name or ID.
+func (app *Application) canEdit(w http.ResponseWriter, r *http.Request, blogPost *models.BlogPost) bool {
+	if user := currentUser(r); !user.CanEdit(blogPost) {
+		slog.WarnContext(r.Context(), "Permission denied to edit post", "user", user.Handle, "id", blogPost.ID)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
 	}
-
-	return normalizedBlogPosts
+	return true
 }
 
 func formattedDate(now time.Time) string {
@@ -529,17 +713,10 @@ func formattedDate(now time.Time) string {
 }
 
 func RenderMarkdown(content string) string {
-	var buf bytes.Buffer
-	if err := md.Convert([]byte(content), &buf); err != nil {
-		log.Printf("Error converting markdown to HTML: %v", err)
+	rendered, err := defaultRenderer.Content(content)
+	if err != nil {
+		slog.Error("Error converting markdown to HTML", "err", err)
 		return content
 	}
-	parsedContent := buf.String()
-
-	log.Println("=== MARKDOWN DEBUG ====")
-	log.Println("Input:", content)
-	log.Println("Output:", parsedContent)
-	log.Println("=======================")
-
-	return parsedContent
+	return string(rendered)
 }
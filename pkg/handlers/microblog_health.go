@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"microblog/pkg/repository"
+	"net/http"
+	"time"
+)
+
+// checkTimeout bounds each readiness check so a stalled database fails the
+// probe rather than hanging it.
+const checkTimeout = 2 * time.Second
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+type checkResult struct {
+	Status   string `json:"status"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+type check struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// Livez reports that the process is up and serving. It checks nothing else,
+// so a failing dependency never gets the process restarted.
+func (app *Application) Livez(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeHealth(w, healthReport{Status: "ok"})
+}
+
+// Readyz reports whether the application should be sent traffic, with the
+// outcome of each check.
+func (app *Application) Readyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeHealth(w, app.runChecks(r.Context(), app.readinessChecks()))
+}
+
+// Healthz is kept for existing monitors. It checks the database only and
+// responds with plain text.
+func (app *Application) Healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	checks := []check{{name: "shutdown", run: app.checkShutdown}}
+	if checker, ok := app.PostStore.(repository.Checker); ok {
+		checks = append(checks, check{name: "database", run: checker.Ping})
+	}
+	if report := app.runChecks(r.Context(), checks); report.Status != "ok" {
+		http.Error(w, "unhealthy", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "ok")
+}
+
+func (app *Application) readinessChecks() []check {
+	checks := []check{{name: "shutdown", run: app.checkShutdown}}
+	if checker, ok := app.PostStore.(repository.Checker); ok {
+		checks = append(checks,
+			check{name: "database", run: checker.Ping},
+			check{name: "schema", run: checker.CheckSchema},
+		)
+	}
+	return append(checks, check{name: "cache", run: app.checkCache})
+}
+
+func (app *Application) checkShutdown(context.Context) error {
+	if !app.Ready() {
+		return errors.New("shutting down")
+	}
+	return nil
+}
+
+func (app *Application) checkCache(context.Context) error {
+	if !app.lifecycle.cacheWarmed.Load() && len(app.Cache.GetAll()) == 0 {
+		return errors.New("not warmed")
+	}
+	return nil
+}
+
+func (app *Application) runChecks(ctx context.Context, checks []check) healthReport {
+	report := healthReport{Status: "ok", Checks: make(map[string]checkResult, len(checks))}
+
+	for _, c := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+		start := time.Now()
+		err := c.run(checkCtx)
+		cancel()
+
+		result := checkResult{Status: "ok", Duration: time.Since(start).String()}
+		if err != nil {
+			slog.WarnContext(ctx, "Readiness check failed", "check", c.name, "err", err)
+			result.Status = "failed"
+			result.Error = err.Error()
+			report.Status = "unavailable"
+		}
+		report.Checks[c.name] = result
+	}
+	return report
+}
+
+func writeHealth(w http.ResponseWriter, report healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		slog.Error("Error encoding health report", "err", err)
+	}
+}
+
+// WarmCache loads the latest posts into the cache, so the first visitors do
+// not wait on the database. Readiness waits for the cache to be warmed.
+func (app *Application) WarmCache(ctx context.Context) error {
+	_, err := app.rebuildCache(ctx)
+	return err
+}
@@ -0,0 +1,127 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"microblog/pkg/cache"
+	"microblog/pkg/handlers"
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkedStore is a post store with readiness checks which counts how often
+// every post is loaded.
+type checkedStore struct {
+	repository.MemoryPostStore
+	pingErr   error
+	schemaErr error
+	getAll    int
+}
+
+func (s *checkedStore) GetAll(ctx context.Context) ([]*models.BlogPost, error) {
+	s.getAll++
+	return s.MemoryPostStore.GetAll(ctx)
+}
+
+func (s *checkedStore) Ping(ctx context.Context) error        { return s.pingErr }
+func (s *checkedStore) CheckSchema(ctx context.Context) error { return s.schemaErr }
+
+type healthReport struct {
+	Status string
+	Checks map[string]struct {
+		Status string
+		Error  string
+	}
+}
+
+func getHealth(t *testing.T, rawURL string) (int, healthReport) {
+	t.Helper()
+	resp, err := http.Get(rawURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var report healthReport
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	return resp.StatusCode, report
+}
+
+func newHealthTestServer(t *testing.T, store repository.PostStore) (*handlers.Application, *httptest.Server) {
+	t.Helper()
+	app := handlers.NewApplication(testUsers(t), store, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	mux := http.NewServeMux()
+	handlers.RegisterRoutes(mux, app)
+	return app, httptest.NewServer(mux)
+}
+
+func TestLivez(t *testing.T) {
+	t.Parallel()
+
+	store := &checkedStore{pingErr: errors.New("connection refused")}
+	app, server := newHealthTestServer(t, store)
+	defer server.Close()
+	app.SetNotReady()
+
+	status, report := getHealth(t, server.URL+"/livez")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "ok", report.Status)
+}
+
+func TestReadyz(t *testing.T) {
+	t.Parallel()
+
+	store := &checkedStore{}
+	app, server := newHealthTestServer(t, store)
+	defer server.Close()
+
+	status, report := getHealth(t, server.URL+"/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "unavailable", report.Status)
+	assert.Equal(t, "ok", report.Checks["database"].Status)
+	assert.Equal(t, "ok", report.Checks["schema"].Status)
+	assert.Equal(t, "failed", report.Checks["cache"].Status)
+	assert.Equal(t, "not warmed", report.Checks["cache"].Error)
+
+	require.NoError(t, app.WarmCache(context.Background()))
+	status, report = getHealth(t, server.URL+"/readyz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "ok", report.Status)
+	assert.Len(t, report.Checks, 4)
+
+	for i := 0; i < 3; i++ {
+		getHealth(t, server.URL+"/readyz")
+	}
+	assert.Zero(t, store.getAll, "probes must not load every post")
+
+	store.schemaErr = errors.New(`column "blog_description" does not exist`)
+	status, report = getHealth(t, server.URL+"/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "failed", report.Checks["schema"].Status)
+	assert.Equal(t, `column "blog_description" does not exist`, report.Checks["schema"].Error)
+
+	store.schemaErr = nil
+	app.SetNotReady()
+	status, report = getHealth(t, server.URL+"/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "shutting down", report.Checks["shutdown"].Error)
+}
+
+func TestHealthzDatabaseDown(t *testing.T) {
+	t.Parallel()
+
+	_, server := newHealthTestServer(t, &checkedStore{pingErr: errors.New("connection refused")})
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/healthz")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
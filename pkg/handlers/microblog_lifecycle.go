@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// lifecycle tracks whether the application is ready for traffic and the
+// background jobs started by requests, so they can be stopped on shutdown.
+type lifecycle struct {
+	notReady    atomic.Bool
+	cacheWarmed atomic.Bool
+
+	jobsMu   sync.Mutex
+	jobs     sync.WaitGroup
+	jobsCtx  context.Context
+	stopJobs context.CancelFunc
+	stopped  bool
+}
+
+func newLifecycle() *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lifecycle{jobsCtx: ctx, stopJobs: cancel}
+}
+
+// Ready reports whether the application should be sent traffic. It is ready
+// from creation until SetNotReady is called at the start of shutdown.
+func (app *Application) Ready() bool {
+	return !app.lifecycle.notReady.Load()
+}
+
+func (app *Application) SetNotReady() {
+	app.lifecycle.notReady.Store(true)
+}
+
+// goJob runs job in the background. Its context is cancelled by StopJobs,
+// and no jobs are started once StopJobs has been called.
+func (app *Application) goJob(job func(ctx context.Context)) {
+	l := app.lifecycle
+	l.jobsMu.Lock()
+	defer l.jobsMu.Unlock()
+	if l.stopped {
+		return
+	}
+
+	l.jobs.Add(1)
+	go func() {
+		defer l.jobs.Done()
+		job(l.jobsCtx)
+	}()
+}
+
+// StopJobs waits for background jobs to finish, cancelling them if ctx is
+// done first.
+func (app *Application) StopJobs(ctx context.Context) error {
+	l := app.lifecycle
+	l.jobsMu.Lock()
+	l.stopped = true
+	l.jobsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		l.jobs.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		l.stopJobs()
+		return nil
+	case <-ctx.Done():
+		l.stopJobs()
+		<-done
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"microblog/pkg/media"
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxUploadSize limits the size of a single uploaded file.
+const maxUploadSize = 10 << 20
+
+// allowedMediaTypes are the sniffed content types accepted for upload. SVG
+// and HTML are left out as they can carry script.
+var allowedMediaTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"video/mp4":       true,
+	"video/webm":      true,
+	"audio/mpeg":      true,
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+type mediaResponse struct {
+	*models.Media
+	URL      string
+	Markdown string
+}
+
+func (app *Application) UploadMediaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// leave room for the multipart headers around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize+1<<20)
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "File is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "File is missing", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxUploadSize+1))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading upload", "filename", header.Filename, "err", err)
+		http.Error(w, "Failed to read file", http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxUploadSize {
+		http.Error(w, "File is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(data) == 0 {
+		http.Error(w, "File is empty", http.StatusBadRequest)
+		return
+	}
+
+	// the type the browser claims is ignored in favour of the content
+	contentType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	if !allowedMediaTypes[contentType] {
+		http.Error(w, "Unsupported file type "+contentType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	uploaded, err := app.Media.Upload(&models.Media{
+		Filename:    mediaFilename(header.Filename),
+		ContentType: contentType,
+		CreatedAt:   time.Now().UTC(),
+	}, data)
+	if errors.Is(err, media.ErrInvalidImage) || errors.Is(err, media.ErrImageTooLarge) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error storing upload", "filename", header.Filename, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.audit(r, models.AuditEntry{Action: models.AuditMediaUpload, Target: "media:" + uploaded.Hash,
+		After: fmt.Sprintf("filename=%q type=%s size=%d", uploaded.Filename, uploaded.ContentType, uploaded.Size)})
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(mediaResponse{Media: uploaded, URL: uploaded.URL(), Markdown: uploaded.Markdown()})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding media", "hash", uploaded.Hash, "err", err)
+	}
+}
+
+func (app *Application) GetMediaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hash := r.PathValue("hash")
+	stored, data, err := app.Media.Get(hash)
+	if errors.Is(err, repository.ErrMediaNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error getting media", "hash", hash, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the content behind a hash never changes, whatever filename is used
+	w.Header().Set("Content-Type", stored.ContentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+stored.Hash+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, stored.Filename, stored.CreatedAt, bytes.NewReader(data))
+}
+
+type mediaPage struct {
+	Media     []*models.Media
+	CSRFToken string
+}
+
+func (app *Application) MediaLibraryHandler(w http.ResponseWriter, r *http.Request) {
+	mediaList, err := app.Media.List()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing media", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tpl, err := parseTemplates(r, "templates/media.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error parsing media.gohtml template", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = tpl.Execute(w, mediaPage{Media: mediaList, CSRFToken: csrfToken(r)})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error executing media.gohtml template", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// mediaFilename keeps the base name of an upload with anything outside a
+// conservative character set replaced, so it is safe in URLs and markdown.
+func mediaFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Trim(unsafeFilenameChars.ReplaceAllString(name, "-"), "-.")
+	if name == "" {
+		return "file"
+	}
+	return name
+}
@@ -0,0 +1,190 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"microblog/pkg/cache"
+	"microblog/pkg/handlers"
+	"microblog/pkg/media"
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadAndServeMedia(t *testing.T) {
+	t.Parallel()
+
+	server := newMediaTestServer(t)
+	defer server.Close()
+
+	pngData := testPNG(t)
+	resp := uploadMedia(t, server, "../My Photo!.png", pngData)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var uploaded struct {
+		Hash        string
+		Filename    string
+		ContentType string
+		URL         string
+		Markdown    string
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&uploaded))
+	assert.Equal(t, "My-Photo-.png", uploaded.Filename)
+	assert.Equal(t, "image/png", uploaded.ContentType)
+	assert.Equal(t, "/media/"+uploaded.Hash+"/My-Photo-.png", uploaded.URL)
+	assert.Equal(t, "![My-Photo-.png]("+uploaded.URL+")", uploaded.Markdown)
+
+	get, err := http.Get(server.URL + uploaded.URL)
+	require.NoError(t, err)
+	defer get.Body.Close()
+
+	require.Equal(t, http.StatusOK, get.StatusCode)
+	assert.Equal(t, "image/png", get.Header.Get("Content-Type"))
+	assert.Equal(t, "public, max-age=31536000, immutable", get.Header.Get("Cache-Control"))
+	assert.Equal(t, `"`+uploaded.Hash+`"`, get.Header.Get("ETag"))
+	body, err := io.ReadAll(get.Body)
+	require.NoError(t, err)
+	assert.Equal(t, pngData, body)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+uploaded.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("If-None-Match", `"`+uploaded.Hash+`"`)
+	notModified, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer notModified.Body.Close()
+	assert.Equal(t, http.StatusNotModified, notModified.StatusCode)
+}
+
+func TestUploadMediaDeduplicates(t *testing.T) {
+	t.Parallel()
+
+	server := newMediaTestServer(t)
+	defer server.Close()
+
+	pngData := testPNG(t)
+	for _, name := range []string{"a.png", "b.png"} {
+		resp := uploadMedia(t, server, name, pngData)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/admin/media", nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("foo", "foo")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(body), "Copy as markdown"))
+	assert.Contains(t, string(body), "a.png")
+	assert.NotContains(t, string(body), "b.png")
+}
+
+func TestUploadMediaRejectsUnsupportedTypeError(t *testing.T) {
+	t.Parallel()
+
+	server := newMediaTestServer(t)
+	defer server.Close()
+
+	resp := uploadMedia(t, server, "evil.png", []byte("<html><script>alert(1)</script></html>"))
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+}
+
+func TestUploadMediaTooLargeError(t *testing.T) {
+	t.Parallel()
+
+	server := newMediaTestServer(t)
+	defer server.Close()
+
+	data := append(testPNG(t), make([]byte, 11<<20)...)
+	resp := uploadMedia(t, server, "big.png", data)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func TestUploadMediaBasicAuthError(t *testing.T) {
+	t.Parallel()
+
+	server := newMediaTestServer(t)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/api/media/upload", "multipart/form-data", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestGetMediaNotFoundError(t *testing.T) {
+	t.Parallel()
+
+	server := newMediaTestServer(t)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/media/" + strings.Repeat("a", 64) + "/missing.png")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func uploadMedia(t *testing.T, server *httptest.Server, filename string, data []byte) *http.Response {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", filename)
+	require.NoError(t, err)
+	_, err = fw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/media/upload", &body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.SetBasicAuth("foo", "foo")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))))
+	return buf.Bytes()
+}
+
+func newMediaTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mediaStore, err := repository.NewFileMediaStore(t.TempDir())
+	require.NoError(t, err)
+
+	app := handlers.NewApplication(testUsers(t), &repository.MemoryPostStore{}, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	app.Media = media.New(mediaStore)
+
+	mux := http.NewServeMux()
+	handlers.RegisterRoutes(mux, app)
+	return httptest.NewServer(mux)
+}
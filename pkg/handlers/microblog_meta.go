@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"microblog/pkg/models"
+	"microblog/pkg/render"
+	"microblog/pkg/repository"
+	"microblog/pkg/tracing"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	siteName = "Ashouri"
+
+	// descriptionLength is the number of characters kept by generated
+	// descriptions, about what search results and link previews show.
+	descriptionLength = 200
+)
+
+// pageMeta is the metadata emitted in the head of a page for search engines
+// and link previews.
+type pageMeta struct {
+	Title       string
+	Description string
+	URL         string
+	Image       string
+	// Type is the OpenGraph type, "website", "article" or "profile".
+	Type      string
+	SiteName  string
+	Published string
+	Modified  string
+	// JSONLD is structured data, encoded into the page by html/template.
+	JSONLD any
+}
+
+type homePage struct {
+	Meta pageMeta
+	// Heading is shown above the posts of listings other than the home
+	// page.
+	Heading string
+	Posts   []*models.BlogPost
+}
+
+type postPage struct {
+	*models.BlogPost
+	Meta pageMeta
+}
+
+type blogPosting struct {
+	Context          string `json:"@context"`
+	Type             string `json:"@type"`
+	Headline         string `json:"headline"`
+	Description      string `json:"description,omitempty"`
+	Image            string `json:"image"`
+	URL              string `json:"url"`
+	MainEntityOfPage string `json:"mainEntityOfPage"`
+	DatePublished    string `json:"datePublished,omitempty"`
+	DateModified     string `json:"dateModified,omitempty"`
+	Author           person `json:"author"`
+	Publisher        person `json:"publisher"`
+}
+
+type person struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+func (app *Application) homePage(r *http.Request, blogPosts []*models.BlogPost) homePage {
+	return homePage{
+		Posts: blogPosts,
+		Meta: pageMeta{
+			Title:    siteName,
+			URL:      app.absoluteURL(r, "/"),
+			Image:    app.defaultImage(r),
+			Type:     "website",
+			SiteName: siteName,
+		},
+	}
+}
+
+func (app *Application) authorPage(r *http.Request, user *models.User, blogPosts []*models.BlogPost) homePage {
+	return homePage{
+		Heading: "Posts by " + user.DisplayName(),
+		Posts:   blogPosts,
+		Meta: pageMeta{
+			Title:    user.DisplayName() + " - " + siteName,
+			URL:      app.absoluteURL(r, authorPath(user.Handle)),
+			Image:    app.defaultImage(r),
+			Type:     "profile",
+			SiteName: siteName,
+		},
+	}
+}
+
+func (app *Application) postPage(r *http.Request, blogPost *models.BlogPost) postPage {
+	title := render.PlainText(blogPost.TitleHTML, descriptionLength)
+	if title == "" {
+		title = blogPost.Title
+	}
+
+	description := blogPost.Description
+	if description == "" {
+		description = render.PlainText(blogPost.Excerpt, descriptionLength)
+	}
+
+	pageURL := app.absoluteURL(r, "/post/"+url.PathEscape(blogPost.Name))
+
+	// without a cover a card is drawn with the post title
+	image := app.absoluteURL(r, "/post/"+url.PathEscape(blogPost.Name)+"/card.png")
+	if blogPost.CoverImage != "" {
+		image = app.absoluteURL(r, blogPost.CoverImage)
+	}
+
+	meta := pageMeta{
+		Title:       title,
+		Description: description,
+		URL:         pageURL,
+		Image:       image,
+		Type:        "article",
+		SiteName:    siteName,
+		Published:   formatMetaTime(blogPost.CreatedAt),
+		Modified:    formatMetaTime(blogPost.UpdatedAt),
+	}
+	meta.JSONLD = blogPosting{
+		Context:          "https://schema.org",
+		Type:             "BlogPosting",
+		Headline:         title,
+		Description:      description,
+		Image:            image,
+		URL:              pageURL,
+		MainEntityOfPage: pageURL,
+		DatePublished:    meta.Published,
+		DateModified:     meta.Modified,
+		Author:           app.postAuthor(r, blogPost),
+		Publisher:        person{Type: "Person", Name: siteName},
+	}
+
+	return postPage{BlogPost: blogPost, Meta: meta}
+}
+
+// postAuthor credits the author of blogPost, or the site for posts from
+// before there were users.
+func (app *Application) postAuthor(r *http.Request, blogPost *models.BlogPost) person {
+	if blogPost.AuthorHandle == "" {
+		return person{Type: "Person", Name: siteName}
+	}
+	name := blogPost.AuthorName
+	if name == "" {
+		name = blogPost.AuthorHandle
+	}
+	return person{Type: "Person", Name: name, URL: app.absoluteURL(r, authorPath(blogPost.AuthorHandle))}
+}
+
+// absoluteURL resolves path against SiteURL, or the host of the request
+// when no site URL is configured. Absolute URLs are returned unchanged.
+func (app *Application) absoluteURL(r *http.Request, path string) string {
+	if strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://") {
+		return path
+	}
+
+	base := strings.TrimSuffix(app.SiteURL, "/")
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return base + path
+}
+
+func (app *Application) defaultImage(r *http.Request) string {
+	if app.DefaultImage != "" {
+		return app.absoluteURL(r, app.DefaultImage)
+	}
+	return app.absoluteURL(r, "/assets/card.png")
+}
+
+func formatMetaTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// validCoverImage accepts site relative paths and http(s) URLs.
+func validCoverImage(coverImage string) bool {
+	if coverImage == "" {
+		return true
+	}
+	if strings.HasPrefix(coverImage, "/") && !strings.HasPrefix(coverImage, "//") {
+		return true
+	}
+	u, err := url.Parse(coverImage)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+func (app *Application) SiteCardHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeCard(w, r, siteName)
+}
+
+func (app *Application) PostCardHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	lookupDone := app.cacheLookup(r.Context(), "card")
+	blogPost := app.cachedPost(name)
+	lookupDone(blogPost != nil)
+	if blogPost == nil {
+		var err error
+		blogPost, err = app.PostStore.GetByName(r.Context(), name)
+		if err != nil && !errors.Is(err, repository.ErrPostNotFound) {
+			slog.ErrorContext(r.Context(), "Error getting post by name", "name", name, "err", err)
+		}
+	}
+	if blogPost == nil || blogPost.Title == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	title := render.PlainText(blogPost.TitleHTML, descriptionLength)
+	if title == "" {
+		title = blogPost.Title
+	}
+	app.writeCard(w, r, title)
+}
+
+func (app *Application) writeCard(w http.ResponseWriter, r *http.Request, title string) {
+	cardPNG, err := app.cards.Render(title)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering card", "title", title, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(cardPNG)
+}
+
+// cacheLookup starts a span for a lookup in the post cache. The returned
+// function ends it and counts the hit or miss.
+func (app *Application) cacheLookup(ctx context.Context, name string) func(hit bool) {
+	_, span := tracing.Start(ctx, "cache.lookup", attribute.String("cache.lookup", name))
+	return func(hit bool) {
+		if hit {
+			app.Cache.Hit()
+		} else {
+			app.Cache.Miss()
+		}
+		span.SetAttributes(attribute.Bool("cache.hit", hit))
+		span.End()
+	}
+}
+
+func (app *Application) cachedPost(name string) *models.BlogPost {
+	app.Cache.Lock()
+	defer app.Cache.Unlock()
+
+	for _, cachedPost := range app.Cache.BlogPosts {
+		if cachedPost.Name == name {
+			return cachedPost
+		}
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"microblog/pkg/models"
+	"microblog/pkg/oidc"
+	"microblog/pkg/repository"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// oidcCookie holds the state, nonce and PKCE verifier of a sign in while
+	// the user is at the provider.
+	oidcCookie = "microblog_oidc"
+	oidcPath   = "/login/oidc"
+	// oidcTTL is how long there is to sign in at the provider.
+	oidcTTL = 10 * time.Minute
+)
+
+// OIDCLogin signs users in with an OpenID Connect provider. Users are
+// created on their first sign in, and get their role from their groups on
+// every sign in.
+type OIDCLogin struct {
+	Provider *oidc.Provider
+	// Name is shown on the login button.
+	Name string
+	// RoleClaim is the ID token claim holding the groups of the user, which
+	// GroupRoles map to roles.
+	RoleClaim  string
+	GroupRoles map[string]models.Role
+	// DefaultRole is given to users in none of the groups. When it is empty
+	// they are refused.
+	DefaultRole models.Role
+}
+
+// role returns the most privileged role the groups in claims map to.
+func (o *OIDCLogin) role(claims *oidc.Claims) (models.Role, bool) {
+	groups := claims.Strings(o.RoleClaim)
+	for _, role := range models.Roles {
+		for _, group := range groups {
+			if o.GroupRoles[group] == role {
+				return role, true
+			}
+		}
+	}
+	return o.DefaultRole, o.DefaultRole.Valid()
+}
+
+// OIDCLoginHandler sends the user to the provider to sign in.
+func (app *Application) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flow := url.Values{"next": {localPath(r.FormValue("next"))}}
+	for _, field := range []string{"state", "nonce", "verifier"} {
+		value, err := oidc.NewVerifier()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error starting single sign-on", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		flow.Set(field, value)
+	}
+
+	authURL, err := app.OIDC.Provider.AuthCodeURL(r.Context(), flow.Get("state"), flow.Get("nonce"), flow.Get("verifier"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error starting single sign-on", "err", err)
+		http.Error(w, "Single sign-on is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	// the cookie has to come back with the provider's redirect, so it is
+	// Lax like the session cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    base64.RawURLEncoding.EncodeToString([]byte(flow.Encode())),
+		Path:     oidcPath,
+		MaxAge:   int(oidcTTL.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler signs in the user the provider sent back, once the
+// code it came with is redeemed for their ID token.
+func (app *Application) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// the flow can only be completed once
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: oidcPath, MaxAge: -1, Secure: true, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+	page := app.loginPage(r)
+	flow, ok := oidcFlow(r)
+	if !ok {
+		page.Error = "Your sign in expired, please try again"
+		app.renderLogin(w, r, page, http.StatusBadRequest)
+		return
+	}
+	page.Next = flow.Get("next")
+
+	q := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(flow.Get("state"))) != 1 {
+		slog.WarnContext(r.Context(), "Single sign-on state mismatch")
+		page.Error = "Your sign in expired, please try again"
+		app.renderLogin(w, r, page, http.StatusBadRequest)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		slog.WarnContext(r.Context(), "Single sign-on refused by provider", "error", e, "description", q.Get("error_description"))
+		page.Error = "Signing in with " + app.OIDC.Name + " was cancelled or refused"
+		app.renderLogin(w, r, page, http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := app.OIDC.Provider.Exchange(r.Context(), q.Get("code"), flow.Get("verifier"), flow.Get("nonce"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error completing single sign-on", "err", err)
+		page.Error = "Signing in with " + app.OIDC.Name + " failed"
+		app.renderLogin(w, r, page, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.oidcUser(r.Context(), claims)
+	var refused oidcRefusal
+	if errors.As(err, &refused) {
+		slog.WarnContext(r.Context(), "Single sign-on refused", "subject", claims.Subject, "reason", string(refused))
+		app.audit(r, models.AuditEntry{Via: "oidc", Action: models.AuditLoginFailed, Target: "oidc:" + claims.Subject, After: string(refused)})
+		page.Error = string(refused)
+		app.renderLogin(w, r, page, http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error signing in single sign-on user", "subject", claims.Subject, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.startSession(w, r, user); err != nil {
+		slog.ErrorContext(r.Context(), "Error starting session", "handle", user.Handle, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Signed in", "handle", user.Handle, "oidc", true)
+	app.auditLogin(r, user, "oidc")
+	http.Redirect(w, r, page.Next, http.StatusSeeOther)
+}
+
+func oidcFlow(r *http.Request) (url.Values, bool) {
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		return nil, false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil, false
+	}
+	flow, err := url.ParseQuery(string(b))
+	if err != nil || flow.Get("state") == "" {
+		return nil, false
+	}
+	flow.Set("next", localPath(flow.Get("next")))
+	return flow, true
+}
+
+// oidcRefusal is why a user who signed in at the provider may not sign in
+// here, which is shown to them.
+type oidcRefusal string
+
+func (e oidcRefusal) Error() string {
+	return string(e)
+}
+
+// oidcUser returns the user with the subject of claims, creating them on
+// their first sign in, with the role their groups give them now.
+func (app *Application) oidcUser(ctx context.Context, claims *oidc.Claims) (*models.User, error) {
+	role, ok := app.OIDC.role(claims)
+	if !ok {
+		return nil, oidcRefusal("Your account is not allowed to sign in here")
+	}
+	now := app.Now().UTC()
+
+	user, err := app.Users.GetBySubject(ctx, claims.Subject)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		user = &models.User{
+			ID:          uuid.New(),
+			Handle:      oidcHandle(claims),
+			Name:        claims.Name,
+			Role:        role,
+			OIDCSubject: claims.Subject,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		err = app.Users.Create(ctx, user)
+		if errors.Is(err, repository.ErrHandleTaken) {
+			return nil, oidcRefusal(fmt.Sprintf("The handle %s is taken by another user", user.Handle))
+		}
+		if err != nil {
+			return nil, err
+		}
+		slog.InfoContext(ctx, "Created single sign-on user", "handle", user.Handle, "role", role)
+		return user, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Disabled {
+		return nil, oidcRefusal("Your account is disabled")
+	}
+	if user.Role != role {
+		slog.InfoContext(ctx, "Updated role from single sign-on groups", "handle", user.Handle, "from", user.Role, "to", role)
+		user.Role = role
+		user.UpdatedAt = now
+		if err := app.Users.Update(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+var handleInvalid = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// oidcHandle makes a handle from the username or email address of the
+// user, falling back to one made from their subject.
+func oidcHandle(claims *oidc.Claims) string {
+	for _, name := range []string{claims.PreferredUsername, strings.Split(claims.Email, "@")[0]} {
+		handle := strings.Trim(handleInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-")
+		if len(handle) > 64 {
+			handle = strings.TrimRight(handle[:64], "-")
+		}
+		if validHandle.MatchString(handle) {
+			return handle
+		}
+	}
+	sum := sha256.Sum256([]byte(claims.Subject))
+	return "sso-" + hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,157 @@
+package handlers_test
+
+import (
+	"context"
+	"io"
+	"microblog/pkg/cache"
+	"microblog/pkg/handlers"
+	"microblog/pkg/models"
+	"microblog/pkg/oidc"
+	"microblog/pkg/oidc/oidctest"
+	"microblog/pkg/repository"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newOIDCServer(t *testing.T, users ...*models.User) (*handlers.Application, *browser, *oidctest.Provider) {
+	t.Helper()
+	idp := oidctest.New("microblog", "client-secret")
+	t.Cleanup(idp.Close)
+	idp.Claims["sub"] = "00u-jane"
+	idp.Claims["preferred_username"] = "Jane.Doe"
+	idp.Claims["name"] = "Jane Doe"
+	idp.Claims["groups"] = []string{"staff", "writers", "editors"}
+
+	app := handlers.NewApplication(testUsers(t, users...), &repository.MemoryPostStore{}, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	app.OIDC = &handlers.OIDCLogin{
+		Provider:   oidc.New(idp.URL, "microblog", "client-secret", server.URL+"/login/oidc/callback", []string{"profile"}),
+		Name:       "Example ID",
+		RoleClaim:  "groups",
+		GroupRoles: map[string]models.Role{"writers": models.RoleAuthor, "editors": models.RoleEditor},
+	}
+	handlers.RegisterRoutes(mux, app)
+	return app, &browser{t: t, server: server}, idp
+}
+
+// signInWithOIDC goes to the provider from the login page and returns the
+// response to coming back from it.
+func (b *browser) signInWithOIDC(next string) *http.Response {
+	b.t.Helper()
+	resp := b.do(http.MethodGet, "/login/oidc?next="+url.QueryEscape(next), nil, nil)
+	require.Equal(b.t, http.StatusFound, resp.StatusCode)
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "microblog_oidc" {
+			b.cookie = cookie
+		}
+	}
+	require.NotNil(b.t, b.cookie)
+	assert.True(b.t, b.cookie.HttpOnly)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(resp.Header.Get("Location"))
+	require.NoError(b.t, err)
+	resp.Body.Close()
+	require.Equal(b.t, http.StatusFound, resp.StatusCode)
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(b.t, err)
+	require.Equal(b.t, "/login/oidc/callback", callback.Path)
+
+	return b.do(http.MethodGet, callback.RequestURI(), nil, nil)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	t.Parallel()
+
+	app, b, idp := newOIDCServer(t)
+	app.TwoFactorRoles = []models.Role{models.RoleEditor}
+
+	resp := b.do(http.MethodGet, "/login", nil, nil)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "Sign in with Example ID")
+
+	resp = b.signInWithOIDC("/admin/media")
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/admin/media", resp.Header.Get("Location"))
+	b.cookie = sessionCookie(resp)
+	require.NotNil(t, b.cookie)
+
+	jane, err := app.Users.GetBySubject(context.Background(), "00u-jane")
+	require.NoError(t, err)
+	assert.Equal(t, "jane-doe", jane.Handle)
+	assert.Equal(t, "Jane Doe", jane.Name)
+	assert.Equal(t, models.RoleEditor, jane.Role, "the most privileged group wins")
+	assert.Empty(t, jane.PasswordHash)
+
+	// the provider takes care of the second factor of single sign-on users
+	resp = b.do(http.MethodGet, "/admin/post/new", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the role follows the groups on the next sign in
+	idp.Claims["groups"] = []string{"writers"}
+	resp = b.signInWithOIDC("/admin/post/new")
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	again, err := app.Users.GetBySubject(context.Background(), "00u-jane")
+	require.NoError(t, err)
+	assert.Equal(t, jane.ID, again.ID)
+	assert.Equal(t, models.RoleAuthor, again.Role)
+
+	assert.Equal(t, 1, idp.RequestCount("/.well-known/openid-configuration"))
+	assert.Equal(t, 1, idp.RequestCount("/jwks"))
+}
+
+func TestOIDCLoginRefused(t *testing.T) {
+	t.Parallel()
+
+	taken := testUser(t, "jane-doe", models.RoleViewer)
+	app, b, idp := newOIDCServer(t, taken)
+
+	resp := b.signInWithOIDC("/admin/media")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Nil(t, sessionCookie(resp))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "The handle jane-doe is taken")
+
+	delete(idp.Claims, "preferred_username")
+	idp.Claims["groups"] = []string{"staff"}
+	resp = b.signInWithOIDC("/admin/media")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "users in none of the groups are refused without a default role")
+
+	app.OIDC.DefaultRole = models.RoleViewer
+	resp = b.signInWithOIDC("/admin/media")
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	user, err := app.Users.GetBySubject(context.Background(), "00u-jane")
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleViewer, user.Role)
+	assert.True(t, strings.HasPrefix(user.Handle, "sso-"))
+
+	user.Disabled = true
+	require.NoError(t, app.Users.Update(context.Background(), user))
+	resp = b.signInWithOIDC("/admin/media")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// the state has to match the one the sign in was started with
+	resp = b.do(http.MethodGet, "/login/oidc?next=%2F", nil, nil)
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "microblog_oidc" {
+			b.cookie = cookie
+		}
+	}
+	resp = b.do(http.MethodGet, "/login/oidc/callback?code=forged&state=forged", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	b.cookie = nil
+	resp = b.do(http.MethodGet, "/login/oidc/callback?code=forged&state=forged", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
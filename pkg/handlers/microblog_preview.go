@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// maxPreviewSize bounds the drafts previewed, which are sent as they are
+// typed.
+const maxPreviewSize = 1 << 20
+
+type previewResponse struct {
+	TitleHTML   template.HTML
+	ContentHTML template.HTML
+	WordCount   int
+	ReadingTime int
+}
+
+// PreviewHandler renders a draft the way its post would be rendered once
+// published, for the preview pane of the editor. Drafts of existing posts
+// pass their id, so they are rendered with the policy of their author.
+func (app *Application) PreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPreviewSize)
+	if err := r.ParseForm(); err != nil {
+		slog.WarnContext(r.Context(), "Unable to parse form", "err", err)
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+
+	draft := &models.BlogPost{
+		Title:    r.FormValue("title"),
+		Content:  r.FormValue("content"),
+		Summary:  r.FormValue("summary"),
+		AuthorID: currentUser(r).ID,
+	}
+	if id := r.FormValue("id"); id != "" {
+		idUUID, err := uuid.Parse(id)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		existing, err := app.PostStore.GetByID(r.Context(), idUUID)
+		if errors.Is(err, repository.ErrPostNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error getting post to preview", "id", id, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !app.canEdit(w, r, existing) {
+			return
+		}
+		draft.ID = existing.ID
+		draft.AuthorID = existing.AuthorID
+	}
+	app.Renderer.Prepare(r.Context(), draft)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err := json.NewEncoder(w).Encode(previewResponse{
+		TitleHTML:   draft.TitleHTML,
+		ContentHTML: draft.ContentHTML,
+		WordCount:   draft.WordCount,
+		ReadingTime: draft.ReadingTime,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding preview", "err", err)
+	}
+}
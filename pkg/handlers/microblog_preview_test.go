@@ -0,0 +1,89 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"microblog/pkg/cache"
+	"microblog/pkg/handlers"
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreview(t *testing.T) {
+	t.Parallel()
+
+	ann := testUser(t, "ann", models.RoleAuthor)
+	bob := testUser(t, "bob", models.RoleAuthor)
+	bobs := &models.BlogPost{ID: uuid.New(), Title: "Bob's", Name: "bobs", Content: "hi", AuthorID: bob.ID}
+	store := &repository.MemoryPostStore{BlogPosts: []*models.BlogPost{bobs}}
+	app := handlers.NewApplication(testUsers(t, ann, bob, testUser(t, "vic", models.RoleViewer)), store, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	mux := http.NewServeMux()
+	handlers.RegisterRoutes(mux, app)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	content := "## Setup\n\n```go\nfunc main() {}\n```\n\n<script>alert(1)</script>\n"
+	resp := postAs(t, server, "ann", "/admin/preview", url.Values{"title": {"_Hello_"}, "content": {content}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	var preview struct {
+		TitleHTML   string
+		ContentHTML string
+		WordCount   int
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&preview))
+	rendered, err := app.Renderer.Content(content)
+	require.NoError(t, err)
+	assert.Equal(t, string(rendered), preview.ContentHTML, "drafts are rendered like published posts")
+	assert.Contains(t, preview.ContentHTML, `class="chroma"`)
+	assert.NotContains(t, preview.ContentHTML, "<script>")
+	assert.Equal(t, "<em>Hello</em>", preview.TitleHTML)
+	assert.Positive(t, preview.WordCount)
+
+	resp = postAs(t, server, "ann", "/admin/preview", url.Values{"id": {bobs.ID.String()}, "content": {"mine now"}})
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "authors cannot preview the posts of others")
+	resp = postAs(t, server, "bob", "/admin/preview", url.Values{"id": {bobs.ID.String()}, "content": {"edited"}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = postAs(t, server, "bob", "/admin/preview", url.Values{"id": {"nope"}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = postAs(t, server, "vic", "/admin/preview", url.Values{"content": {"hi"}})
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestEditorAssets(t *testing.T) {
+	t.Parallel()
+
+	ann := testUser(t, "ann", models.RoleAuthor)
+	app, b := newSessionsServer(t, ann)
+	require.NoError(t, app.PostStore.Create(context.Background(), &models.BlogPost{ID: uuid.New(), Name: "hello", Title: "Hello", AuthorID: ann.ID}))
+	resp := b.do(http.MethodPost, "/login", url.Values{"handle": {"ann"}, "password": {"ann-password"}}, nil)
+	b.cookie = sessionCookie(resp)
+	require.NotNil(t, b.cookie)
+
+	for _, path := range []string{"/admin/post/new", "/admin/post/edit/hello"} {
+		resp = b.do(http.MethodGet, path, nil, nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode, path)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), `<link rel="stylesheet" href="/assets/simplemde/simplemde.min.css">`, path)
+		assert.Contains(t, string(body), `<script src="/assets/simplemde/simplemde.min.js" defer></script>`+"\n"+`    <script src="/assets/editor.js" defer></script>`, path)
+		assert.Contains(t, string(body), `data-editor data-preview="/admin/preview"`, path)
+		assert.NotContains(t, string(body), "https://", "the editor works offline")
+	}
+
+	for path, contentType := range map[string]string{"/assets/editor.js": "text/javascript", "/assets/editor.css": "text/css"} {
+		resp = b.do(http.MethodGet, path, nil, nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+		assert.Contains(t, resp.Header.Get("Content-Type"), contentType, path)
+	}
+}
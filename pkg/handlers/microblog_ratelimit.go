@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"microblog/pkg/models"
+	"microblog/pkg/ratelimit"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// RateLimits throttle clients by IP, with a policy for each group of
+// routes, and attempts to sign in with each credential wherever they come
+// from. Nil limiters do not limit.
+type RateLimits struct {
+	// Login covers signing in and the admin pages.
+	Login  *ratelimit.Limiter
+	API    *ratelimit.Limiter
+	Public *ratelimit.Limiter
+	// Credential limits the use of each handle and API token.
+	Credential *ratelimit.Limiter
+	// Lockout locks handles and client IPs out after repeated failed sign
+	// ins.
+	Lockout *ratelimit.Lockout
+	// TrustedProxies are the proxies whose X-Forwarded-For header is
+	// believed.
+	TrustedProxies []netip.Prefix
+}
+
+func defaultRateLimits() RateLimits {
+	return RateLimits{
+		Login:      ratelimit.New(ratelimit.Policy{Rate: 60, Burst: 30}),
+		API:        ratelimit.New(ratelimit.Policy{Rate: 120, Burst: 60}),
+		Public:     ratelimit.New(ratelimit.Policy{Rate: 300, Burst: 100}),
+		Credential: ratelimit.New(ratelimit.Policy{Rate: 120, Burst: 60}),
+		Lockout:    ratelimit.NewLockout(5, time.Minute, time.Hour),
+	}
+}
+
+// clientIP returns the address of the client of r, behind any trusted
+// proxies.
+func (app *Application) clientIP(r *http.Request) string {
+	return ratelimit.ClientIP(r, app.Limits.TrustedProxies)
+}
+
+// RateLimit refuses requests from clients which exceed the policy of the
+// group of routes they call.
+func (app *Application) RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiter := app.Limits.Public
+		switch groupOf(r.URL.Path) {
+		case adminRoutes:
+			limiter = app.Limits.Login
+		case apiRoutes:
+			limiter = app.Limits.API
+		}
+		ip := app.clientIP(r)
+		if ok, wait := limiter.Allow(ip); !ok {
+			slog.WarnContext(r.Context(), "Rate limited", "ip", ip, "path", r.URL.Path)
+			ratelimit.TooManyRequests(w, wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// userCredential names the handle a client signs in with, for the limits
+// on attempts.
+func userCredential(handle string) string {
+	return "user:" + strings.ToLower(handle)
+}
+
+// attemptWait returns how long the client of r has to wait before it may
+// try credential, or zero when it may now. Every attempt counts against
+// the limit of the credential. An empty credential only checks the client,
+// as for unknown API tokens.
+func (app *Application) attemptWait(r *http.Request, credential string) time.Duration {
+	wait := app.Limits.Lockout.Locked("ip:" + app.clientIP(r))
+	if credential == "" {
+		return wait
+	}
+	wait = max(wait, app.Limits.Lockout.Locked(credential))
+	if ok, limited := app.Limits.Credential.Allow(credential); !ok {
+		wait = max(wait, limited)
+	}
+	return wait
+}
+
+// failedAttempt counts a failed sign in against credential and the client
+// of r, which are locked out after too many.
+func (app *Application) failedAttempt(r *http.Request, credential string) {
+	ip := app.clientIP(r)
+	lock := app.Limits.Lockout.Fail("ip:" + ip)
+	if credential != "" {
+		lock = max(lock, app.Limits.Lockout.Fail(credential))
+	}
+	if lock > 0 {
+		slog.WarnContext(r.Context(), "Locked out after failed sign ins", "credential", credential, "ip", ip, "for", lock)
+	}
+}
+
+// succeededAttempt forgets the failed sign ins of credential and the client
+// IP, so users sharing an address are not locked out by failures which were
+// followed by a success.
+func (app *Application) succeededAttempt(r *http.Request, credential string) {
+	app.Limits.Lockout.Reset("ip:" + app.clientIP(r))
+	app.Limits.Lockout.Reset(credential)
+}
+
+// signIn checks password like checkPassword, within the limits on
+// attempts. When the client may not try now it returns how long to wait
+// instead.
+func (app *Application) signIn(r *http.Request, handle, password string) (*models.User, time.Duration) {
+	credential := userCredential(handle)
+	if wait := app.attemptWait(r, credential); wait > 0 {
+		slog.WarnContext(r.Context(), "Sign in refused by rate limit", "handle", handle, "wait", wait)
+		return nil, wait
+	}
+	user, ok := app.checkPassword(r.Context(), handle, password)
+	if !ok {
+		app.failedAttempt(r, credential)
+		return nil, 0
+	}
+	app.succeededAttempt(r, credential)
+	return user, 0
+}
+
+// waitMessage tells a user locked out for wait when to try again.
+func waitMessage(wait time.Duration) string {
+	if wait <= time.Minute {
+		return fmt.Sprintf("Too many attempts, try again in %d seconds", int(math.Ceil(wait.Seconds())))
+	}
+	return fmt.Sprintf("Too many attempts, try again in %d minutes", int(math.Ceil(wait.Minutes())))
+}
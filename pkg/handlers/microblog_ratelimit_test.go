@@ -0,0 +1,129 @@
+package handlers_test
+
+import (
+	"io"
+	"microblog/pkg/cache"
+	"microblog/pkg/handlers"
+	"microblog/pkg/models"
+	"microblog/pkg/ratelimit"
+	"microblog/pkg/repository"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+
+	app := handlers.NewApplication(testUsers(t), &repository.MemoryPostStore{}, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	app.Limits.Public = ratelimit.New(ratelimit.Policy{Rate: 1, Burst: 2})
+	app.Limits.TrustedProxies, _ = ratelimit.ParsePrefixes([]string{"127.0.0.1"})
+	mux := http.NewServeMux()
+	handlers.RegisterRoutes(mux, app)
+	server := httptest.NewServer(app.RateLimit(mux))
+	t.Cleanup(server.Close)
+
+	get := func(path, forwardedFor string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	for range 2 {
+		assert.Equal(t, http.StatusOK, get("/robots.txt", "203.0.113.1").StatusCode)
+	}
+	resp := get("/robots.txt", "203.0.113.1")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, get("/robots.txt", "203.0.113.2").StatusCode, "clients behind the proxy are told apart")
+	assert.Equal(t, http.StatusOK, get("/robots.txt", "203.0.113.66, 203.0.113.2").StatusCode, "addresses the client adds are ignored")
+	assert.Equal(t, http.StatusUnauthorized, get("/api/posts", "203.0.113.1").StatusCode, "the API has a policy of its own")
+}
+
+func TestLoginLockout(t *testing.T) {
+	t.Parallel()
+
+	app, b := newSessionsServer(t, testUser(t, "ann", models.RoleAuthor), testUser(t, "bob", models.RoleAuthor))
+	now := time.Now()
+	app.Limits.Lockout = ratelimit.NewLockout(3, time.Minute, time.Hour)
+	app.Limits.Lockout.Now = func() time.Time { return now }
+
+	login := func(handle, password string) *http.Response {
+		return b.do(http.MethodPost, "/login", url.Values{"handle": {handle}, "password": {password}}, nil)
+	}
+	for range 3 {
+		assert.Equal(t, http.StatusUnauthorized, login("ann", "wrong").StatusCode)
+	}
+	resp := login("ann", "ann-password")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "the right password does not help while locked")
+	assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "Too many attempts, try again in 60 seconds")
+	assert.Nil(t, sessionCookie(resp))
+
+	resp = postAs(t, b.server, "ann", "/api/post/new", url.Values{"title": {"Hi"}, "content": {"hi"}})
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "basic auth is locked as well")
+	assert.Equal(t, http.StatusTooManyRequests, login("bob", "bob-password").StatusCode, "so is the client")
+
+	// the next failure doubles the lock
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusUnauthorized, login("ann", "wrong").StatusCode)
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusTooManyRequests, login("ann", "ann-password").StatusCode)
+	now = now.Add(time.Minute)
+	resp = login("ann", "ann-password")
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.NotNil(t, sessionCookie(resp))
+}
+
+func TestLoginLockoutSharedIP(t *testing.T) {
+	t.Parallel()
+
+	app, b := newSessionsServer(t, testUser(t, "ann", models.RoleAuthor), testUser(t, "bob", models.RoleAuthor))
+	app.Limits.Lockout = ratelimit.NewLockout(3, time.Minute, time.Hour)
+
+	login := func(handle, password string) *http.Response {
+		return b.do(http.MethodPost, "/login", url.Values{"handle": {handle}, "password": {password}}, nil)
+	}
+	// users behind one NAT mistype their passwords now and then
+	for range 5 {
+		assert.Equal(t, http.StatusUnauthorized, login("ann", "wrong").StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, login("bob", "wrong").StatusCode)
+		assert.Equal(t, http.StatusSeeOther, login("ann", "ann-password").StatusCode)
+		assert.Equal(t, http.StatusSeeOther, login("bob", "bob-password").StatusCode)
+	}
+}
+
+func TestTokenLimits(t *testing.T) {
+	t.Parallel()
+
+	app, b := newSessionsServer(t, testUser(t, "ann", models.RoleAuthor))
+	token := createToken(t, b, "ann", url.Values{"name": {"ci"}, "scopes": {"posts:read"}})
+	app.Limits.Credential = ratelimit.New(ratelimit.Policy{Rate: 1, Burst: 1})
+	app.Limits.Lockout = ratelimit.NewLockout(2, time.Minute, time.Hour)
+
+	assert.Equal(t, http.StatusOK, b.do(http.MethodGet, "/api/posts", nil, bearer(token)).StatusCode)
+	resp := b.do(http.MethodGet, "/api/posts", nil, bearer(token))
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "each token has its own limit")
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+	for range 2 {
+		assert.Equal(t, http.StatusUnauthorized, b.do(http.MethodGet, "/api/posts", nil, bearer("mb_guess")).StatusCode)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, b.do(http.MethodGet, "/api/posts", nil, bearer("mb_guess")).StatusCode,
+		"clients guessing tokens are locked out")
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"microblog/pkg/security"
+	"net/http"
+	"path"
+	"time"
+)
+
+const (
+	// CSPReportPath is where browsers report violations of the CSP.
+	CSPReportPath = "/csp-report"
+	// maxCSPReportSize bounds the reports read, which anyone can send.
+	maxCSPReportSize = 16 << 10
+)
+
+// SecurityHeaders are the security headers of each group of routes.
+type SecurityHeaders struct {
+	Public security.Policy
+	// Admin covers signing in and the admin pages.
+	Admin security.Policy
+	API   security.Policy
+}
+
+func defaultSecurityHeaders() SecurityHeaders {
+	return SecurityHeaders{
+		Public: security.Policy{
+			CSP:               "default-src 'self'; script-src 'self'; style-src 'self'; img-src 'self' data: https:; object-src 'none'; base-uri 'self'; form-action 'self'",
+			FrameAncestors:    "'self'",
+			ReferrerPolicy:    "strict-origin-when-cross-origin",
+			PermissionsPolicy: "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+			HSTS:              365 * 24 * time.Hour,
+			ReportURI:         CSPReportPath,
+		},
+		Admin: security.Policy{
+			CSP:               "default-src 'self'; script-src 'self'; style-src 'self'; img-src 'self' data: https:; object-src 'none'; base-uri 'none'; form-action 'self'",
+			FrameAncestors:    "'none'",
+			ReferrerPolicy:    "same-origin",
+			PermissionsPolicy: "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+			HSTS:              365 * 24 * time.Hour,
+			ReportURI:         CSPReportPath,
+		},
+		API: security.Policy{
+			CSP:            "default-src 'none'",
+			FrameAncestors: "'none'",
+			ReferrerPolicy: "no-referrer",
+			HSTS:           365 * 24 * time.Hour,
+			ReportURI:      CSPReportPath,
+		},
+	}
+}
+
+// SecureHeaders sets the security headers of the group of routes each
+// request is for, with a fresh nonce for the inline scripts and styles of
+// the page.
+func (app *Application) SecureHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := app.Headers.Public
+		switch groupOf(r.URL.Path) {
+		case adminRoutes:
+			policy = app.Headers.Admin
+		case apiRoutes:
+			policy = app.Headers.API
+		}
+		nonce, err := security.NewNonce()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error generating nonce", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		policy.Set(w, nonce)
+		next.ServeHTTP(w, r.WithContext(security.WithNonce(r.Context(), nonce)))
+	})
+}
+
+// parseTemplates parses the template files for r. Inline scripts and
+// styles carry {{nonce}}, the nonce of the request, for the CSP to allow
+// them.
+func parseTemplates(r *http.Request, files ...string) (*template.Template, error) {
+	nonce := security.Nonce(r.Context())
+	funcs := template.FuncMap{"nonce": func() string { return nonce }}
+	return template.New(path.Base(files[0])).Funcs(funcs).ParseFS(templates, files...)
+}
+
+// cspReport is a violation of the CSP, as browsers send to report-uri.
+type cspReport struct {
+	DocumentURI        string `json:"document-uri"`
+	EffectiveDirective string `json:"effective-directive"`
+	ViolatedDirective  string `json:"violated-directive"`
+	BlockedURI         string `json:"blocked-uri"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+	Disposition        string `json:"disposition"`
+}
+
+// CSPReportHandler logs the violations of the CSP browsers report.
+func (app *Application) CSPReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Report cspReport `json:"csp-report"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCSPReportSize)).Decode(&body); err != nil {
+		http.Error(w, "Invalid report", http.StatusBadRequest)
+		return
+	}
+	report := body.Report
+	directive := report.EffectiveDirective
+	if directive == "" {
+		directive = report.ViolatedDirective
+	}
+	slog.WarnContext(r.Context(), "CSP violation",
+		"document", report.DocumentURI,
+		"directive", directive,
+		"blocked", report.BlockedURI,
+		"source", report.SourceFile,
+		"line", report.LineNumber,
+		"disposition", report.Disposition)
+	w.WriteHeader(http.StatusNoContent)
+}
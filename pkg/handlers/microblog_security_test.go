@@ -0,0 +1,118 @@
+package handlers_test
+
+import (
+	"io"
+	"log/slog"
+	"microblog/pkg/cache"
+	"microblog/pkg/handlers"
+	"microblog/pkg/metrics"
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerRecordsRoutes(t *testing.T) {
+	t.Parallel()
+
+	store := &repository.MemoryPostStore{BlogPosts: []*models.BlogPost{{ID: uuid.New(), Name: "hello", Title: "Hello"}}}
+	app := handlers.NewApplication(testUsers(t), store, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	mux := http.NewServeMux()
+	handlers.RegisterRoutes(mux, app)
+	m := metrics.New()
+	server := httptest.NewServer(app.Handler(slog.New(slog.NewTextHandler(io.Discard, nil)), m, mux))
+	t.Cleanup(server.Close)
+
+	for _, path := range []string{"/livez", "/post/hello"} {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.NotEmpty(t, resp.Header.Get("Content-Security-Policy"), "the security headers are set on %s", path)
+	}
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := rec.Body.String()
+	assert.Contains(t, out, `microblog_http_requests_total{code="200",method="GET",route="/livez"} 1`)
+	assert.Contains(t, out, `microblog_http_requests_total{code="200",method="GET",route="/post/{name}"} 1`)
+	assert.NotContains(t, out, `route="unmatched"`)
+}
+
+func TestSecureHeaders(t *testing.T) {
+	t.Parallel()
+
+	app := handlers.NewApplication(testUsers(t), &repository.MemoryPostStore{}, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	mux := http.NewServeMux()
+	handlers.RegisterRoutes(mux, app)
+	server := httptest.NewServer(app.SecureHeaders(mux))
+	t.Cleanup(server.Close)
+
+	get := func(path string) (*http.Response, string) {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	resp, body := get("/login")
+	csp := resp.Header.Get("Content-Security-Policy")
+	assert.Contains(t, csp, "frame-ancestors 'none'")
+	assert.Contains(t, csp, "report-uri /csp-report")
+	assert.Equal(t, "DENY", resp.Header.Get("X-Frame-Options"))
+	assert.Equal(t, "same-origin", resp.Header.Get("Referrer-Policy"))
+	assert.Equal(t, "nosniff", resp.Header.Get("X-Content-Type-Options"))
+	assert.Equal(t, "max-age=31536000", resp.Header.Get("Strict-Transport-Security"))
+
+	nonce := regexp.MustCompile(`<style nonce="([^"]+)">`).FindStringSubmatch(body)
+	require.Len(t, nonce, 2, "inline styles carry the nonce")
+	assert.Contains(t, csp, "style-src 'self' 'nonce-"+nonce[1]+"'")
+	assert.NotContains(t, body, ` style="`, "inline style attributes are blocked by the CSP")
+
+	resp, body = get("/login")
+	assert.NotContains(t, resp.Header.Get("Content-Security-Policy"), nonce[1], "every response has a nonce of its own")
+	assert.NotContains(t, body, nonce[1])
+
+	resp, _ = get("/")
+	assert.Contains(t, resp.Header.Get("Content-Security-Policy"), "frame-ancestors 'self'")
+	assert.Equal(t, "SAMEORIGIN", resp.Header.Get("X-Frame-Options"))
+
+	resp, _ = get("/api/posts")
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Security-Policy"), "default-src 'none'"))
+	assert.Equal(t, "no-referrer", resp.Header.Get("Referrer-Policy"))
+}
+
+func TestCSPReport(t *testing.T) {
+	t.Parallel()
+
+	app := handlers.NewApplication(testUsers(t), &repository.MemoryPostStore{}, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	mux := http.NewServeMux()
+	handlers.RegisterRoutes(mux, app)
+	server := httptest.NewServer(app.SecureHeaders(mux))
+	t.Cleanup(server.Close)
+
+	report := `{"csp-report": {"document-uri": "https://example.com/", "violated-directive": "script-src", "blocked-uri": "inline", "line-number": 3}}`
+	resp, err := http.Post(server.URL+"/csp-report", "application/csp-report", strings.NewReader(report))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, err = http.Post(server.URL+"/csp-report", "application/csp-report", strings.NewReader("not json"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + "/csp-report")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"microblog/pkg/auth"
+	"microblog/pkg/models"
+	"microblog/pkg/ratelimit"
+	"microblog/pkg/repository"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookie = "microblog_session"
+	csrfField     = "csrf_token"
+	csrfHeader    = "X-CSRF-Token"
+
+	// defaultSessionTTL is how long a sign in lasts unless SessionTTL is set.
+	defaultSessionTTL = 24 * time.Hour
+	// pendingSessionTTL is how long there is to enter the second factor
+	// after the password.
+	pendingSessionTTL = 5 * time.Minute
+	// afterLogin is where users land when there is no page to return to.
+	afterLogin = "/admin/post/new"
+)
+
+type sessionKey struct{}
+
+func withSession(ctx context.Context, session *models.Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// csrfToken returns the CSRF token of the session r was made with, which is
+// empty for requests signed in with basic auth.
+func csrfToken(r *http.Request) string {
+	if session, ok := r.Context().Value(sessionKey{}).(*models.Session); ok {
+		return session.CSRFToken
+	}
+	return ""
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// sameOrigin reports whether r was sent by a page of this site. Requests
+// without an Origin header, such as those of scripts, are let through.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// validCSRF checks the token sent in the X-CSRF-Token header or the
+// csrf_token form field against the session. Multipart bodies are left for
+// the handler to parse within its own limits, so they have to use the
+// header.
+func validCSRF(r *http.Request, session *models.Session) bool {
+	token := r.Header.Get(csrfHeader)
+	if token == "" && !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		token = r.PostFormValue(csrfField)
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) == 1
+}
+
+// localPath returns next when it is a path on this site, so the login form
+// cannot be used to send users elsewhere.
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return afterLogin
+	}
+	return next
+}
+
+// sessionUser returns the session in the cookie of r and its user, or nil
+// when there is no valid session.
+func (app *Application) sessionUser(r *http.Request) (*models.User, *models.Session) {
+	user, session := app.cookieSession(r)
+	if session == nil || session.Pending {
+		return nil, nil
+	}
+	return user, session
+}
+
+// pendingSession returns the session in the cookie of r and its user when
+// they still have to enter their second factor.
+func (app *Application) pendingSession(r *http.Request) (*models.User, *models.Session) {
+	user, session := app.cookieSession(r)
+	if session == nil || !session.Pending {
+		return nil, nil
+	}
+	return user, session
+}
+
+func (app *Application) cookieSession(r *http.Request) (*models.User, *models.Session) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, nil
+	}
+
+	session, err := app.Sessions.Get(r.Context(), auth.HashToken(cookie.Value))
+	if err != nil {
+		if !errors.Is(err, repository.ErrSessionNotFound) {
+			slog.ErrorContext(r.Context(), "Error getting session", "err", err)
+		}
+		return nil, nil
+	}
+	if session.Expired(app.Now()) {
+		return nil, nil
+	}
+
+	// the user is loaded on every request, so disabling them or changing
+	// their role applies at once
+	user, err := app.Users.GetByID(r.Context(), session.UserID)
+	if err != nil {
+		if !errors.Is(err, repository.ErrUserNotFound) {
+			slog.ErrorContext(r.Context(), "Error getting session user", "err", err)
+		}
+		return nil, nil
+	}
+	if !user.Active() {
+		return nil, nil
+	}
+	return user, session
+}
+
+// startSession signs user in by setting a new session cookie.
+func (app *Application) startSession(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	return app.newSession(w, r, &models.Session{UserID: user.ID}, app.SessionTTL)
+}
+
+// newSession stores session with a new ID and CSRF token, and sets its
+// cookie.
+func (app *Application) newSession(w http.ResponseWriter, r *http.Request, session *models.Session, ttl time.Duration) error {
+	id, idHash, err := auth.NewToken()
+	if err != nil {
+		return err
+	}
+	csrf, _, err := auth.NewToken()
+	if err != nil {
+		return err
+	}
+
+	now := app.Now().UTC()
+	session.IDHash = idHash
+	session.CSRFToken = csrf
+	session.CreatedAt = now
+	session.ExpiresAt = now.Add(ttl)
+	if err := app.Sessions.Create(r.Context(), session); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		MaxAge:   int(ttl.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// sign ins are a good moment to tidy up the sessions that ran out
+	app.goJob(func(ctx context.Context) {
+		if err := app.Sessions.DeleteExpired(ctx, now); err != nil {
+			slog.ErrorContext(ctx, "Error deleting expired sessions", "err", err)
+		}
+	})
+	return nil
+}
+
+// auditLogin records that user signed in via a method such as password.
+func (app *Application) auditLogin(r *http.Request, user *models.User, via string) {
+	app.audit(r, models.AuditEntry{ActorID: user.ID, ActorHandle: user.Handle, Via: via, Action: models.AuditLogin, Target: userTarget(user)})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// unauthorized sends browsers to the login page and asks everyone else for
+// basic auth credentials.
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	if safeMethod(r.Method) && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+type loginPage struct {
+	Handle string
+	Next   string
+	Error  string
+	// SSO is the name of the single sign-on provider, when there is one.
+	SSO string
+}
+
+func (app *Application) loginPage(r *http.Request) loginPage {
+	page := loginPage{Next: localPath(r.FormValue("next"))}
+	if app.OIDC != nil {
+		page.SSO = app.OIDC.Name
+	}
+	return page
+}
+
+// LoginHandler shows the login form and starts a session when it is
+// submitted with a valid handle and password. Users with two-factor
+// authentication get a pending session instead, until they enter a code.
+func (app *Application) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	page := app.loginPage(r)
+	status := http.StatusOK
+	if r.Method == http.MethodPost {
+		// the form has no CSRF token of its own, so logins from other sites
+		// are refused by their origin
+		if !sameOrigin(r) {
+			http.Error(w, "Cross-origin request refused", http.StatusForbidden)
+			return
+		}
+
+		page.Handle = r.PostFormValue("handle")
+		user, wait := app.signIn(r, page.Handle, r.PostFormValue("password"))
+		if wait > 0 {
+			ratelimit.SetRetryAfter(w, wait)
+			page.Error = waitMessage(wait)
+			app.renderLogin(w, r, page, http.StatusTooManyRequests)
+			return
+		}
+		ok := user != nil
+		if ok && user.TwoFactorEnabled() {
+			if err := app.newSession(w, r, &models.Session{UserID: user.ID, Pending: true}, pendingSessionTTL); err != nil {
+				slog.ErrorContext(r.Context(), "Error starting session", "handle", user.Handle, "err", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, "/login/2fa?next="+url.QueryEscape(page.Next), http.StatusSeeOther)
+			return
+		}
+		if ok {
+			if err := app.startSession(w, r, user); err != nil {
+				slog.ErrorContext(r.Context(), "Error starting session", "handle", user.Handle, "err", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			slog.InfoContext(r.Context(), "Signed in", "handle", user.Handle)
+			app.auditLogin(r, user, "password")
+			http.Redirect(w, r, page.Next, http.StatusSeeOther)
+			return
+		}
+		slog.WarnContext(r.Context(), "Failed sign in", "handle", page.Handle)
+		app.audit(r, models.AuditEntry{Via: "password", Action: models.AuditLoginFailed, Target: "user:" + page.Handle})
+		page.Error = "Invalid handle or password"
+		status = http.StatusUnauthorized
+	}
+
+	app.renderLogin(w, r, page, status)
+}
+
+func (app *Application) renderLogin(w http.ResponseWriter, r *http.Request, page loginPage, status int) {
+	tpl, err := parseTemplates(r, "templates/login.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error parsing login.gohtml template", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+	err = tpl.Execute(w, page)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error executing login.gohtml template", "err", err)
+	}
+}
+
+// LogoutHandler ends the session of the request and returns to the login
+// page.
+func (app *Application) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, session := app.cookieSession(r)
+	if session != nil {
+		if !session.Pending && !validCSRF(r, session) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		if err := app.Sessions.Delete(r.Context(), session.IDHash); err != nil {
+			slog.ErrorContext(r.Context(), "Error deleting session", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "Signed out", "handle", user.Handle)
+		app.audit(r, models.AuditEntry{ActorID: user.ID, ActorHandle: user.Handle, Via: "session", Action: models.AuditLogout, Target: userTarget(user)})
+	}
+
+	clearSessionCookie(w)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
@@ -0,0 +1,206 @@
+package handlers_test
+
+import (
+	"context"
+	"io"
+	"microblog/pkg/auth"
+	"microblog/pkg/cache"
+	"microblog/pkg/handlers"
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var csrfInput = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// browser makes requests with a session cookie, without following
+// redirects.
+type browser struct {
+	t      *testing.T
+	server *httptest.Server
+	cookie *http.Cookie
+}
+
+func (b *browser) do(method, path string, form url.Values, header http.Header) *http.Response {
+	b.t.Helper()
+	req, err := http.NewRequest(method, b.server.URL+path, strings.NewReader(form.Encode()))
+	require.NoError(b.t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "text/html")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if b.cookie != nil {
+		req.AddCookie(b.cookie)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Do(req)
+	require.NoError(b.t, err)
+	b.t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// csrfToken reads the CSRF token from the form on the admin page at path.
+func (b *browser) csrfToken(path string) string {
+	b.t.Helper()
+	resp := b.do(http.MethodGet, path, nil, nil)
+	require.Equal(b.t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(b.t, err)
+	match := csrfInput.FindSubmatch(body)
+	require.NotNil(b.t, match, "no CSRF token on %s", path)
+	return string(match[1])
+}
+
+func sessionCookie(resp *http.Response) *http.Cookie {
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "microblog_session" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func newSessionsServer(t *testing.T, users ...*models.User) (*handlers.Application, *browser) {
+	t.Helper()
+	app := handlers.NewApplication(testUsers(t, users...), &repository.MemoryPostStore{}, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	mux := http.NewServeMux()
+	handlers.RegisterRoutes(mux, app)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return app, &browser{t: t, server: server}
+}
+
+func TestLoginAndLogout(t *testing.T) {
+	t.Parallel()
+
+	_, b := newSessionsServer(t, testUser(t, "ann", models.RoleAuthor))
+
+	resp := b.do(http.MethodGet, "/admin/post/new", nil, nil)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode, "browsers are sent to the login page")
+	assert.Equal(t, "/login?next=%2Fadmin%2Fpost%2Fnew", resp.Header.Get("Location"))
+
+	resp = b.do(http.MethodPost, "/login", url.Values{"handle": {"ann"}, "password": {"wrong"}}, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Nil(t, sessionCookie(resp))
+
+	resp = b.do(http.MethodPost, "/login", url.Values{"handle": {"ann"}, "password": {"ann-password"}, "next": {"/admin/media"}}, nil)
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/admin/media", resp.Header.Get("Location"))
+	b.cookie = sessionCookie(resp)
+	require.NotNil(t, b.cookie)
+	assert.True(t, b.cookie.Secure)
+	assert.True(t, b.cookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, b.cookie.SameSite)
+
+	token := b.csrfToken("/admin/post/new")
+	post := url.Values{"title": {"Hello"}, "content": {"hello"}}
+	resp = b.do(http.MethodPost, "/api/post/new", post, nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "a CSRF token is required")
+	post.Set("csrf_token", "forged")
+	resp = b.do(http.MethodPost, "/api/post/new", post, nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	post.Set("csrf_token", token)
+	resp = b.do(http.MethodPost, "/api/post/new", post, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	post.Del("csrf_token")
+	resp = b.do(http.MethodPost, "/api/post/new", post, http.Header{"X-Csrf-Token": {token}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "the token can be sent as a header")
+
+	resp = b.do(http.MethodPost, "/logout", nil, nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = b.do(http.MethodPost, "/logout", url.Values{"csrf_token": {token}}, nil)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	cleared := sessionCookie(resp)
+	require.NotNil(t, cleared)
+	assert.Empty(t, cleared.Value)
+
+	// the old cookie no longer works either
+	resp = b.do(http.MethodGet, "/admin/post/new", nil, nil)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+}
+
+func TestLoginRefusesOtherSites(t *testing.T) {
+	t.Parallel()
+
+	_, b := newSessionsServer(t)
+	credentials := url.Values{"handle": {"foo"}, "password": {"foo"}}
+
+	resp := b.do(http.MethodPost, "/login", credentials, http.Header{"Origin": {"https://evil.example"}})
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	credentials.Set("next", "//evil.example/")
+	resp = b.do(http.MethodPost, "/login", credentials, http.Header{"Origin": {b.server.URL}})
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/admin/post/new", resp.Header.Get("Location"), "only local pages are returned to")
+}
+
+func TestSessionExpires(t *testing.T) {
+	t.Parallel()
+
+	app, b := newSessionsServer(t)
+	users, err := app.Users.List(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, app.Sessions.Create(context.Background(), &models.Session{
+		IDHash:    auth.HashToken("expired"),
+		UserID:    users[0].ID,
+		CSRFToken: "token",
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}))
+
+	b.cookie = &http.Cookie{Name: "microblog_session", Value: "expired"}
+	resp := b.do(http.MethodGet, "/admin/users", nil, http.Header{"Accept": {"application/json"}})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("WWW-Authenticate"))
+
+	require.NoError(t, app.Sessions.DeleteExpired(context.Background(), time.Now()))
+	_, err = app.Sessions.Get(context.Background(), auth.HashToken("expired"))
+	assert.ErrorIs(t, err, repository.ErrSessionNotFound)
+}
+
+func TestSessionEndsWhenUserIsDisabled(t *testing.T) {
+	t.Parallel()
+
+	ann := testUser(t, "ann", models.RoleAuthor)
+	app, b := newSessionsServer(t, ann)
+	resp := b.do(http.MethodPost, "/login", url.Values{"handle": {"ann"}, "password": {"ann-password"}}, nil)
+	b.cookie = sessionCookie(resp)
+	require.NotNil(t, b.cookie)
+
+	disabled := *ann
+	disabled.Disabled = true
+	require.NoError(t, app.Users.Update(context.Background(), &disabled))
+	resp = b.do(http.MethodGet, "/admin/post/new", nil, nil)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+}
+
+func TestBasicAuthFromOtherSitesIsRefused(t *testing.T) {
+	t.Parallel()
+
+	_, b := newSessionsServer(t)
+	post := url.Values{"title": {"Hello"}, "content": {"hello"}}
+	basicAuth := func(origin string) http.Header {
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth("foo", "foo")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		return req.Header
+	}
+
+	resp := b.do(http.MethodPost, "/api/post/new", post, basicAuth("https://evil.example"))
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = b.do(http.MethodPost, "/api/post/new", post, basicAuth(""))
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "scripts need no CSRF token")
+}
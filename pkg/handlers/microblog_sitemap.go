@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"microblog/pkg/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sitemapLimit is the most URLs a single sitemap may list. Beyond it the
+// sitemap becomes an index of numbered sitemaps.
+const sitemapLimit = 50_000
+
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// sitemapTTL bounds how long the sitemap is kept, for posts written by other
+// instances of the site.
+const sitemapTTL = 5 * time.Minute
+
+// sitemapCache keeps the paths the sitemap lists between writes to posts, so
+// crawlers do not read every post on each request.
+type sitemapCache struct {
+	mu       sync.Mutex
+	paths    []sitemapURL
+	loadedAt time.Time
+}
+
+func (c *sitemapCache) invalidate() {
+	c.mu.Lock()
+	c.paths = nil
+	c.mu.Unlock()
+}
+
+type urlSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+func (app *Application) SitemapHandler(w http.ResponseWriter, r *http.Request) {
+	urls, err := app.sitemapURLs(r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error building sitemap", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(urls) <= sitemapLimit {
+		writeXML(w, urlSet{Xmlns: sitemapNamespace, URLs: urls})
+		return
+	}
+
+	index := sitemapIndex{Xmlns: sitemapNamespace}
+	for page := 1; (page-1)*sitemapLimit < len(urls); page++ {
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{
+			Loc:     app.absoluteURL(r, fmt.Sprintf("/sitemap/%d.xml", page)),
+			LastMod: newestLastMod(sitemapPage(urls, page)),
+		})
+	}
+	writeXML(w, index)
+}
+
+// SitemapPageHandler serves one of the numbered sitemaps listed by the
+// sitemap index.
+func (app *Application) SitemapPageHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(strings.TrimSuffix(r.PathValue("page"), ".xml"))
+	if err != nil || page < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	urls, err := app.sitemapURLs(r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error building sitemap", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pageURLs := sitemapPage(urls, page)
+	if len(pageURLs) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	writeXML(w, urlSet{Xmlns: sitemapNamespace, URLs: pageURLs})
+}
+
+// sitemapURLs lists the home page followed by every post.
+func (app *Application) sitemapURLs(r *http.Request) ([]sitemapURL, error) {
+	paths, err := app.sitemapPaths(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make([]sitemapURL, len(paths))
+	for i, path := range paths {
+		urls[i] = sitemapURL{Loc: app.absoluteURL(r, path.Loc), LastMod: path.LastMod}
+	}
+	return urls, nil
+}
+
+// sitemapPaths returns the paths of the sitemap from the cache, reading the
+// posts when it is empty or has expired. Requests wait for one read of the
+// posts rather than each making their own.
+func (app *Application) sitemapPaths(ctx context.Context) ([]sitemapURL, error) {
+	c := &app.sitemap
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paths != nil && app.Now().Sub(c.loadedAt) < sitemapTTL {
+		return c.paths, nil
+	}
+
+	blogPosts, err := app.PostStore.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]sitemapURL, 0, len(blogPosts)+1)
+	paths = append(paths, sitemapURL{Loc: "/"})
+	for _, blogPost := range blogPosts {
+		if blogPost.Name == "" {
+			continue
+		}
+		paths = append(paths, sitemapURL{
+			Loc:     "/post/" + url.PathEscape(blogPost.Name),
+			LastMod: lastMod(blogPost),
+		})
+	}
+	paths[0].LastMod = newestLastMod(paths[1:])
+	c.paths, c.loadedAt = paths, app.Now()
+	return paths, nil
+}
+
+func sitemapPage(urls []sitemapURL, page int) []sitemapURL {
+	start := (page - 1) * sitemapLimit
+	if start >= len(urls) {
+		return nil
+	}
+	return urls[start:min(start+sitemapLimit, len(urls))]
+}
+
+func lastMod(blogPost *models.BlogPost) string {
+	if blogPost.UpdatedAt.IsZero() {
+		return formatMetaTime(blogPost.CreatedAt)
+	}
+	return formatMetaTime(blogPost.UpdatedAt)
+}
+
+// newestLastMod relies on RFC 3339 UTC times sorting as strings.
+func newestLastMod(urls []sitemapURL) string {
+	newest := ""
+	for _, u := range urls {
+		newest = max(newest, u.LastMod)
+	}
+	return newest
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	out, err := xml.Marshal(v)
+	if err != nil {
+		slog.Error("Error encoding sitemap", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	io.WriteString(w, xml.Header)
+	w.Write(out)
+}
+
+func (app *Application) RobotsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if app.RobotsTxt != "" {
+		io.WriteString(w, app.RobotsTxt)
+		return
+	}
+
+	fmt.Fprintf(w, "User-agent: *\nDisallow: /admin/\nDisallow: /api/\n\nSitemap: %s\n", app.absoluteURL(r, "/sitemap.xml"))
+}
+
+func (app *Application) IndexNowKeyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, app.Notifier.Key)
+}
+
+// notify submits paths to the search engine notifier when one is configured.
+// It runs as a background job so publishing is not held up by the search
+// engine, failures are only logged.
+func (app *Application) notify(r *http.Request, paths ...string) {
+	if app.Notifier == nil {
+		return
+	}
+
+	urls := make([]string, len(paths))
+	for i, path := range paths {
+		urls[i] = app.absoluteURL(r, path)
+	}
+
+	app.goJob(func(ctx context.Context) {
+		if err := app.Notifier.Notify(ctx, urls...); err != nil {
+			slog.ErrorContext(ctx, "Error notifying search engines", "endpoint", app.Notifier.Endpoint, "urls", urls, "err", err)
+		}
+	})
+}
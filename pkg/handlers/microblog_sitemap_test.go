@@ -0,0 +1,260 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"microblog/pkg/cache"
+	"microblog/pkg/handlers"
+	"microblog/pkg/indexnow"
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sitemapDoc struct {
+	XMLName xml.Name
+	URLs    []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+func getSitemap(t *testing.T, rawURL string) sitemapDoc {
+	t.Helper()
+	resp, err := http.Get(rawURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/xml; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	var doc sitemapDoc
+	require.NoError(t, xml.NewDecoder(resp.Body).Decode(&doc))
+	return doc
+}
+
+func TestSitemap(t *testing.T) {
+	t.Parallel()
+
+	older := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	newer := time.Date(2025, 7, 2, 10, 30, 0, 0, time.UTC)
+	store := &repository.MemoryPostStore{BlogPosts: []*models.BlogPost{
+		{ID: uuid.New(), Name: "first", Title: "first", CreatedAt: older, UpdatedAt: older},
+		{ID: uuid.New(), Name: "second", Title: "second", CreatedAt: older, UpdatedAt: newer},
+	}}
+	server := newTestServer(t, store, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	defer server.Close()
+
+	doc := getSitemap(t, server.URL+"/sitemap.xml")
+	assert.Equal(t, "urlset", doc.XMLName.Local)
+	require.Len(t, doc.URLs, 3)
+	assert.Equal(t, server.URL+"/", doc.URLs[0].Loc)
+	assert.Equal(t, "2025-07-02T10:30:00Z", doc.URLs[0].LastMod)
+	assert.Equal(t, server.URL+"/post/first", doc.URLs[1].Loc)
+	assert.Equal(t, "2025-06-01T09:00:00Z", doc.URLs[1].LastMod)
+	assert.Equal(t, server.URL+"/post/second", doc.URLs[2].Loc)
+	assert.Equal(t, "2025-07-02T10:30:00Z", doc.URLs[2].LastMod)
+}
+
+// countingPostStore counts the reads of every post.
+type countingPostStore struct {
+	*repository.MemoryPostStore
+	mu     sync.Mutex
+	getAll int
+}
+
+func (s *countingPostStore) GetAll(ctx context.Context) ([]*models.BlogPost, error) {
+	s.mu.Lock()
+	s.getAll++
+	s.mu.Unlock()
+	return s.MemoryPostStore.GetAll(ctx)
+}
+
+func (s *countingPostStore) reads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getAll
+}
+
+func TestSitemapIsCachedUntilPostsChange(t *testing.T) {
+	t.Parallel()
+
+	store := &countingPostStore{MemoryPostStore: &repository.MemoryPostStore{BlogPosts: []*models.BlogPost{
+		{ID: uuid.New(), Name: "first", Title: "first"},
+	}}}
+	server := newTestServer(t, store, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	defer server.Close()
+
+	require.Len(t, getSitemap(t, server.URL+"/sitemap.xml").URLs, 2)
+	require.Len(t, getSitemap(t, server.URL+"/sitemap.xml").URLs, 2)
+	assert.Equal(t, 1, store.reads())
+
+	form := url.Values{"title": {"Second"}, "content": {"body"}}
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/post/new?"+form.Encode(), nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("foo", "foo")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	doc := getSitemap(t, server.URL+"/sitemap.xml")
+	require.Len(t, doc.URLs, 3)
+	assert.Equal(t, server.URL+"/post/second", doc.URLs[2].Loc)
+	assert.Equal(t, 2, store.reads())
+}
+
+func TestSitemapIndex(t *testing.T) {
+	t.Parallel()
+
+	// with the home page this is one URL over the limit of a sitemap
+	blogPosts := make([]*models.BlogPost, 50_000)
+	for i := range blogPosts {
+		blogPosts[i] = &models.BlogPost{Name: fmt.Sprintf("post-%d", i), Title: "post"}
+	}
+	store := &repository.MemoryPostStore{BlogPosts: blogPosts}
+	server := newTestServer(t, store, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	defer server.Close()
+
+	index := getSitemap(t, server.URL+"/sitemap.xml")
+	assert.Equal(t, "sitemapindex", index.XMLName.Local)
+	require.Len(t, index.Sitemaps, 2)
+	assert.Equal(t, server.URL+"/sitemap/1.xml", index.Sitemaps[0].Loc)
+	assert.Equal(t, server.URL+"/sitemap/2.xml", index.Sitemaps[1].Loc)
+
+	first := getSitemap(t, index.Sitemaps[0].Loc)
+	assert.Len(t, first.URLs, 50_000)
+	second := getSitemap(t, index.Sitemaps[1].Loc)
+	require.Len(t, second.URLs, 1)
+	assert.Equal(t, server.URL+"/post/post-49999", second.URLs[0].Loc)
+
+	resp, err := http.Get(server.URL + "/sitemap/3.xml")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestRobots(t *testing.T) {
+	t.Parallel()
+
+	store := &repository.MemoryPostStore{}
+	server := newTestServer(t, store, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/robots.txt")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "Disallow: /admin/\n")
+	assert.Contains(t, string(body), "Sitemap: "+server.URL+"/sitemap.xml\n")
+
+	app := handlers.NewApplication(testUsers(t), store, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	app.RobotsTxt = "User-agent: *\nDisallow: /\n"
+	mux := http.NewServeMux()
+	handlers.RegisterRoutes(mux, app)
+	configured := httptest.NewServer(mux)
+	defer configured.Close()
+
+	resp, err = http.Get(configured.URL + "/robots.txt")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, app.RobotsTxt, string(body))
+}
+
+func TestSubmitNewPostNotifiesSearchEngines(t *testing.T) {
+	t.Parallel()
+
+	submissions := make(chan map[string]any, 1)
+	searchEngine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var submission map[string]any
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&submission))
+		submissions <- submission
+	}))
+	defer searchEngine.Close()
+
+	app := handlers.NewApplication(testUsers(t), &repository.MemoryPostStore{}, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	app.SiteURL = "https://example.com"
+	app.Notifier = indexnow.New(searchEngine.URL, "0123456789abcdef")
+	mux := http.NewServeMux()
+	handlers.RegisterRoutes(mux, app)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	form := url.Values{"title": {"Hello"}, "content": {"body"}}
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/post/new", nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("foo", "foo")
+	req.URL.RawQuery = form.Encode()
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	select {
+	case submission := <-submissions:
+		assert.Equal(t, "example.com", submission["host"])
+		assert.Equal(t, "0123456789abcdef", submission["key"])
+		assert.Equal(t, "https://example.com/indexnow.txt", submission["keyLocation"])
+		assert.Equal(t, []any{"https://example.com/post/hello", "https://example.com/"}, submission["urlList"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("search engine was not notified")
+	}
+
+	resp, err = http.Get(server.URL + "/indexnow.txt")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	key, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef", string(key))
+}
+
+func TestStopJobsCancelsNotifications(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan struct{})
+	searchEngine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		close(received)
+		// hold the request until the notifier gives up on it
+		<-r.Context().Done()
+	}))
+	defer searchEngine.Close()
+
+	app := handlers.NewApplication(testUsers(t), &repository.MemoryPostStore{}, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	app.SiteURL = "https://example.com"
+	app.Notifier = indexnow.New(searchEngine.URL, "0123456789abcdef")
+	mux := http.NewServeMux()
+	handlers.RegisterRoutes(mux, app)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/post/new?title=Hello&content=body", nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("foo", "foo")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	<-received
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, app.StopJobs(ctx), context.DeadlineExceeded)
+}
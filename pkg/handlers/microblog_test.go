@@ -1,11 +1,16 @@
 package handlers_test
 
 import (
+	"context"
 	"encoding/json"
+	"html/template"
+	"image/png"
 	"io"
+	"microblog/pkg/auth"
 	"microblog/pkg/cache"
 	"microblog/pkg/handlers"
 	"microblog/pkg/models"
+	"microblog/pkg/render"
 	"microblog/pkg/repository"
 	"net/http"
 	"net/http/httptest"
@@ -13,6 +18,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -64,8 +70,7 @@ func TestListenAndServe_NoCache(t *testing.T) {
 	require.NoError(t, err)
 
 	got := string(read)
-	assert.Contains(t, got, "<h2><a href=\"/post/foo")
-	assert.Contains(t, got, "<p>foo</p>")
+	assert.Contains(t, got, "<h2><a href=\"/post/foo\">foo</a></h2>")
 	assert.Contains(t, got, "<p>boo</p>")
 	assert.Contains(t, got, "<h3>1 June, 2025</h3>")
 	assert.Contains(t, got, "<title>Ashouri</title>")
@@ -104,10 +109,15 @@ func TestListenAndServe_CacheHit(t *testing.T) {
 	require.Len(t, cache.BlogPosts, 1)
 	require.Equal(t, []*models.BlogPost{
 		{
-			Title:         "<p>foo</p>\n",
-			TitleNonHTML:  "foo",
+			Title:         "foo",
+			TitleHTML:     "foo",
 			Name:          "foo",
-			Content:       "<p>boo</p>\n",
+			Content:       "boo",
+			ContentHTML:   "<p>boo</p>\n",
+			Excerpt:       "<p>boo</p>\n",
+			RenderVersion: render.New(render.Policy{}).Version(false),
+			WordCount:     1,
+			ReadingTime:   1,
 			ID:            id,
 			FormattedDate: blogPost.FormattedDate,
 		},
@@ -118,8 +128,7 @@ func TestListenAndServe_CacheHit(t *testing.T) {
 	require.NoError(t, err)
 
 	got := string(read)
-	assert.Contains(t, got, "<h2><a href=\"/post/foo")
-	assert.Contains(t, got, "<p>foo</p>")
+	assert.Contains(t, got, "<h2><a href=\"/post/foo\">foo</a></h2>")
 	assert.Contains(t, got, "<p>boo</p>")
 	assert.Contains(t, got, "<h3>1 June, 2025</h3>")
 
@@ -134,8 +143,7 @@ func TestListenAndServe_CacheHit(t *testing.T) {
 	require.NoError(t, err)
 
 	got = string(read)
-	assert.Contains(t, got, "<h2><a href=\"/post/foo")
-	assert.Contains(t, got, "<p>foo</p>")
+	assert.Contains(t, got, "<h2><a href=\"/post/foo\">foo</a></h2>")
 	assert.Contains(t, got, "<p>boo</p>")
 	assert.Contains(t, got, "<h3>1 June, 2025</h3>")
 }
@@ -160,6 +168,25 @@ func TestHealthz(t *testing.T) {
 	assert.Equal(t, "ok", string(body))
 }
 
+func TestHealthzNotReady(t *testing.T) {
+	t.Parallel()
+
+	app := handlers.NewApplication(testUsers(t), &repository.MemoryPostStore{}, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	mux := http.NewServeMux()
+	handlers.RegisterRoutes(mux, app)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	assert.True(t, app.Ready())
+	app.SetNotReady()
+	assert.False(t, app.Ready())
+
+	resp, err := http.Get(server.URL + "/healthz")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
 func TestSubmitHandler(t *testing.T) {
 	t.Parallel()
 
@@ -236,7 +263,7 @@ func TestUpdatePostHandler(t *testing.T) {
 
 	require.Equal(t, http.StatusOK, editResp.StatusCode)
 
-	updatedPost, err := store.GetByID(createdPost.ID)
+	updatedPost, err := store.GetByID(context.Background(), createdPost.ID)
 	require.NoError(t, err)
 	require.NotNil(t, updatedPost)
 
@@ -245,8 +272,37 @@ func TestUpdatePostHandler(t *testing.T) {
 	assert.Equal(t, createdPost.ID, updatedPost.ID)
 
 	// Assert that the cache has been hydrated when a blogpost is updated
-	assert.Equal(t, "<p>Updated Title</p>\n", cache.BlogPosts[0].Title)
-	assert.Equal(t, "<p>Updated Content</p>\n", cache.BlogPosts[0].Content)
+	assert.Equal(t, template.HTML("Updated Title"), cache.BlogPosts[0].TitleHTML)
+	assert.Equal(t, template.HTML("<p>Updated Content</p>\n"), cache.BlogPosts[0].ContentHTML)
+}
+
+func TestUpdatePersistsRendered(t *testing.T) {
+	t.Parallel()
+
+	stale := &models.BlogPost{ID: uuid.New(), Name: "post", Title: "Old", Content: "Old", ContentHTML: "<p>Old</p>\n", RenderVersion: "stale"}
+	store := &repository.MemoryPostStore{BlogPosts: []*models.BlogPost{stale}}
+	app := handlers.NewApplication(testUsers(t), store, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	app.PersistRendered = true
+	mux := http.NewServeMux()
+	handlers.RegisterRoutes(mux, app)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	form := url.Values{"id": {stale.ID.String()}, "title": {"Updated Title"}, "content": {"Updated Content"}}
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/post/edit", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("foo", "foo")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	updated, err := store.GetByID(context.Background(), stale.ID)
+	require.NoError(t, err)
+	assert.Equal(t, template.HTML("<p>Updated Content</p>\n"), updated.ContentHTML)
+	assert.Equal(t, app.Renderer.Version(false), updated.RenderVersion)
+	assert.False(t, updated.UpdatedAt.IsZero())
 }
 
 func TestUpdateHandlerBasicAuthError(t *testing.T) {
@@ -287,7 +343,7 @@ func TestEditPostHandlerBasicAuthError(t *testing.T) {
 	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 }
 
-func TestSubmitHandlerBasicAuthError(t *testing.T) {
+func TestEditPostHandlerNotFound(t *testing.T) {
 	t.Parallel()
 
 	store := &repository.MemoryPostStore{}
@@ -296,34 +352,54 @@ func TestSubmitHandlerBasicAuthError(t *testing.T) {
 	server := newTestServer(t, store, cache)
 	defer server.Close()
 
-	req, err := http.NewRequest("GET", server.URL+"/admin/post/new", nil)
+	req, err := http.NewRequest("GET", server.URL+"/admin/post/edit/doesnotexist", nil)
 	require.NoError(t, err)
+	req.SetBasicAuth("foo", "foo")
 
 	resp, err := http.DefaultClient.Do(req)
 	require.NoError(t, err)
 	defer resp.Body.Close()
 
-	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
 }
 
-func TestIsAuthenticatedWhenCorrectPasswordProvidedReturnsTrue(t *testing.T) {
+func TestUnknownPostIDNotFound(t *testing.T) {
+	t.Parallel()
 
-	t.Setenv(handlers.MicroblogToken, "password123")
+	store := &repository.MemoryPostStore{}
+	cache := cache.New([]*models.BlogPost{}, &sync.Mutex{})
 
-	got := handlers.IsAuthenticated("password123")
-	want := true
+	server := newTestServer(t, store, cache)
+	defer server.Close()
 
-	assert.Equal(t, want, got)
+	id := uuid.New().String()
+	for path, form := range map[string]url.Values{
+		"/api/post/edit":         {"id": {id}, "title": {"Title"}, "content": {"Content"}},
+		"/api/post/delete/" + id: nil,
+		"/admin/preview":         {"id": {id}, "content": {"Content"}},
+	} {
+		resp := postAs(t, server, "foo", path, form)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, path)
+	}
 }
 
-func TestIsAuthenticatedReturnsFalseWhenIncorrectPasswordProvided(t *testing.T) {
+func TestSubmitHandlerBasicAuthError(t *testing.T) {
+	t.Parallel()
+
+	store := &repository.MemoryPostStore{}
+	cache := cache.New([]*models.BlogPost{}, &sync.Mutex{})
+
+	server := newTestServer(t, store, cache)
+	defer server.Close()
 
-	t.Setenv(handlers.MicroblogToken, "password123")
+	req, err := http.NewRequest("GET", server.URL+"/admin/post/new", nil)
+	require.NoError(t, err)
 
-	got := handlers.IsAuthenticated("hotdog")
-	want := false
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
 
-	assert.Equal(t, want, got)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 }
 
 func TestGetBlogPostByName_ServesFromCacheOnSubsequentRequests(t *testing.T) {
@@ -400,10 +476,183 @@ func TestGetBlogPostByName_NoCache(t *testing.T) {
 	assert.Contains(t, content1, "Test Content")
 }
 
+// recentPostStore only fetches the posts after the first as the last 10.
+type recentPostStore struct {
+	*repository.MemoryPostStore
+}
+
+func (s recentPostStore) FetchLast10BlogPosts(ctx context.Context) ([]*models.BlogPost, error) {
+	return s.BlogPosts[1:], nil
+}
+
+func TestGetBlogPostByName_NotInLast10(t *testing.T) {
+	t.Parallel()
+
+	older := &models.BlogPost{ID: uuid.New(), Name: "older", Title: "Older Title", Content: "Older Content", FormattedDate: "1 June, 2024"}
+	newer := &models.BlogPost{ID: uuid.New(), Name: "newer", Title: "Newer Title", Content: "Newer Content", FormattedDate: "1 June, 2025"}
+	store := recentPostStore{&repository.MemoryPostStore{BlogPosts: []*models.BlogPost{older, newer}}}
+	server := newTestServer(t, store, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/post/older")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "Older Title")
+	assert.Contains(t, string(body), "<p>Older Content</p>")
+
+	resp, err = http.Get(server.URL + "/post/nope")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHighlightCSS(t *testing.T) {
+	t.Parallel()
+
+	store := &repository.MemoryPostStore{}
+	cache := cache.New([]*models.BlogPost{}, &sync.Mutex{})
+
+	server := newTestServer(t, store, cache)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/assets/highlight.css")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/css; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), ".chroma")
+}
+
+func TestPostPageMetadata(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	blogPost := &models.BlogPost{ID: uuid.New(), Name: "testtitle", Title: "Test *Title*", Content: "Some **content** here", CreatedAt: createdAt, UpdatedAt: createdAt}
+	store := &repository.MemoryPostStore{BlogPosts: []*models.BlogPost{blogPost}}
+	cache := cache.New([]*models.BlogPost{}, &sync.Mutex{})
+
+	server := newTestServer(t, store, cache)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/post/testtitle")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	content := string(body)
+
+	assert.Contains(t, content, `<link rel="canonical" href="`+server.URL+`/post/testtitle">`)
+	assert.Contains(t, content, `<meta property="og:type" content="article">`)
+	assert.Contains(t, content, `<meta property="og:title" content="Test Title">`)
+	assert.Contains(t, content, `<meta property="og:description" content="Some content here">`)
+	assert.Contains(t, content, `<meta property="og:image" content="`+server.URL+`/post/testtitle/card.png">`)
+	assert.Contains(t, content, `<meta property="article:published_time" content="2025-06-01T12:00:00Z">`)
+	assert.Contains(t, content, `<meta name="twitter:card" content="summary_large_image">`)
+	assert.Contains(t, content, `<script type="application/ld+json">{"@context":"https://schema.org","@type":"BlogPosting","headline":"Test Title"`)
+}
+
+func TestPostPageMetadataUsesCoverAndDescription(t *testing.T) {
+	t.Parallel()
+
+	blogPost := &models.BlogPost{ID: uuid.New(), Name: "testtitle", Title: "Test Title", Content: "Test Content", Description: `Say "hi" </script>`, CoverImage: "/media/abc/cover.png"}
+	store := &repository.MemoryPostStore{BlogPosts: []*models.BlogPost{blogPost}}
+	cache := cache.New([]*models.BlogPost{}, &sync.Mutex{})
+
+	server := newTestServer(t, store, cache)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/post/testtitle")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	content := string(body)
+
+	assert.Contains(t, content, `<meta property="og:image" content="`+server.URL+`/media/abc/cover.png">`)
+	assert.Contains(t, content, `<meta property="og:description" content="Say &#34;hi&#34; &lt;/script&gt;">`)
+	assert.Contains(t, content, `<img class="cover" src="/media/abc/cover.png" alt="">`)
+	assert.NotContains(t, content, `hi" </script>`)
+}
+
+func TestPostCard(t *testing.T) {
+	t.Parallel()
+
+	blogPost := &models.BlogPost{ID: uuid.New(), Name: "testtitle", Title: "Test Title", Content: "Test Content"}
+	store := &repository.MemoryPostStore{BlogPosts: []*models.BlogPost{blogPost}}
+	cache := cache.New([]*models.BlogPost{}, &sync.Mutex{})
+
+	server := newTestServer(t, store, cache)
+	defer server.Close()
+
+	for _, path := range []string{"/post/testtitle/card.png", "/assets/card.png"} {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		require.Equal(t, http.StatusOK, resp.StatusCode, path)
+		assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+		cfg, err := png.DecodeConfig(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, 1200, cfg.Width)
+		assert.Equal(t, 630, cfg.Height)
+	}
+
+	resp, err := http.Get(server.URL + "/post/missing/card.png")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestSubmitHandlerInvalidCoverImageError(t *testing.T) {
+	t.Parallel()
+
+	store := &repository.MemoryPostStore{}
+	cache := cache.New([]*models.BlogPost{}, &sync.Mutex{})
+
+	server := newTestServer(t, store, cache)
+	defer server.Close()
+
+	form := url.Values{}
+	form.Add("title", "Title")
+	form.Add("content", "Content")
+	form.Add("cover_image", "javascript:alert(1)")
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/post/new", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("foo", "foo")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Empty(t, store.BlogPosts)
+}
+
 func newTestServer(t *testing.T, store repository.PostStore, cache *cache.Cache) *httptest.Server {
 	t.Helper()
 	mux := http.NewServeMux()
-	handlers.RegisterRoutes(mux, handlers.NewApplication("foo", "foo", store, cache))
+	handlers.RegisterRoutes(mux, handlers.NewApplication(testUsers(t), store, cache))
 	server := httptest.NewServer(mux)
 	return server
 }
+
+// testUsers returns a user store with the admin foo, whose password is foo.
+func testUsers(t *testing.T, users ...*models.User) *repository.MemoryUserStore {
+	t.Helper()
+	hash, err := auth.HashPassword("foo")
+	require.NoError(t, err)
+	admin := &models.User{ID: uuid.New(), Handle: "foo", PasswordHash: hash, Role: models.RoleAdmin}
+	return repository.NewMemoryUserStore(append([]*models.User{admin}, users...)...)
+}
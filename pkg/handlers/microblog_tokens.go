@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"microblog/pkg/auth"
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// tokenPrefix marks API tokens, so they are recognised when leaked.
+	tokenPrefix = "mb_"
+	// touchInterval is how often the last use of a token is recorded, so
+	// busy tokens do not cause a write on every request.
+	touchInterval = time.Minute
+	// maxTokenDays is the longest a token can be made to last, other than
+	// not expiring at all.
+	maxTokenDays = 366
+)
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// tokenUser returns the API token with the value token and its user, or nil
+// when the token is unknown, expired or its user may not sign in.
+func (app *Application) tokenUser(r *http.Request, token string) (*models.User, *models.APIToken) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return nil, nil
+	}
+	apiToken, err := app.Tokens.GetByHash(r.Context(), auth.HashToken(token))
+	if err != nil {
+		if !errors.Is(err, repository.ErrTokenNotFound) {
+			slog.ErrorContext(r.Context(), "Error getting API token", "err", err)
+		}
+		return nil, nil
+	}
+	now := app.Now()
+	if apiToken.Expired(now) {
+		return nil, nil
+	}
+
+	user, err := app.Users.GetByID(r.Context(), apiToken.UserID)
+	if err != nil {
+		if !errors.Is(err, repository.ErrUserNotFound) {
+			slog.ErrorContext(r.Context(), "Error getting API token user", "err", err)
+		}
+		return nil, nil
+	}
+	if !user.Active() {
+		return nil, nil
+	}
+
+	// uses are recorded in the audit log as often as they are touched
+	if now.Sub(apiToken.LastUsedAt) >= touchInterval {
+		if err := app.Tokens.Touch(r.Context(), apiToken.ID, now.UTC()); err != nil {
+			slog.ErrorContext(r.Context(), "Error recording API token use", "token", apiToken.Name, "err", err)
+		}
+		app.audit(r, models.AuditEntry{ActorID: user.ID, ActorHandle: user.Handle, Via: "token:" + apiToken.Name,
+			Action: models.AuditTokenUse, Target: tokenTarget(apiToken), After: r.Method + " " + r.URL.Path})
+	}
+	return user, apiToken
+}
+
+type tokensPage struct {
+	Tokens []*models.APIToken
+	// Owners maps user IDs to handles when the tokens of every user are
+	// shown, which only admins see.
+	Owners    map[uuid.UUID]string
+	Scopes    []models.Scope
+	CSRFToken string
+}
+
+// TokensHandler lists the API tokens of the user, or those of every user
+// for admins.
+func (app *Application) TokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	page := tokensPage{Scopes: models.Scopes, CSRFToken: csrfToken(r)}
+
+	var err error
+	if user.Can(models.PermManageUsers) {
+		page.Tokens, err = app.Tokens.List(r.Context())
+		if err == nil {
+			page.Owners, err = app.userHandles(r)
+		}
+	} else {
+		page.Tokens, err = app.Tokens.ListByUser(r.Context(), user.ID)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing API tokens", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tpl, err := parseTemplates(r, "templates/tokens.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error parsing tokens.gohtml template", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = tpl.Execute(w, page)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error executing tokens.gohtml template", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (app *Application) userHandles(r *http.Request) (map[uuid.UUID]string, error) {
+	users, err := app.Users.List(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	handles := map[uuid.UUID]string{}
+	for _, u := range users {
+		handles[u.ID] = u.Handle
+	}
+	return handles, nil
+}
+
+type tokenResponse struct {
+	Token    string           `json:"token"`
+	APIToken *models.APIToken `json:"api_token"`
+}
+
+// CreateTokenHandler creates an API token for the user. The token is only
+// shown in the response, the server keeps a hash of it.
+func (app *Application) CreateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.PostForm.Get("name"))
+	if name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+	var scopes []models.Scope
+	for _, s := range r.PostForm["scopes"] {
+		scope := models.Scope(s)
+		if !scope.Valid() {
+			http.Error(w, fmt.Sprintf("Unknown scope %q", s), http.StatusBadRequest)
+			return
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+	// an empty number of days makes a token which does not expire
+	var days int
+	if v := r.PostForm.Get("expires_days"); v != "" {
+		var err error
+		if days, err = strconv.Atoi(v); err != nil || days < 1 || days > maxTokenDays {
+			http.Error(w, fmt.Sprintf("Expiry must be between 1 and %d days", maxTokenDays), http.StatusBadRequest)
+			return
+		}
+	}
+
+	secret, _, err := auth.NewToken()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error creating API token", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token := tokenPrefix + secret
+
+	now := app.Now().UTC()
+	apiToken := &models.APIToken{
+		ID:        uuid.New(),
+		UserID:    currentUser(r).ID,
+		Name:      name,
+		Hash:      auth.HashToken(token),
+		Scopes:    scopes,
+		CreatedAt: now,
+	}
+	if days > 0 {
+		apiToken.ExpiresAt = now.AddDate(0, 0, days)
+	}
+	if err := app.Tokens.Create(r.Context(), apiToken); err != nil {
+		slog.ErrorContext(r.Context(), "Error creating API token", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Created API token", "token", name, "scopes", scopes, "by", currentUser(r).Handle)
+	app.audit(r, models.AuditEntry{Action: models.AuditTokenCreate, Target: tokenTarget(apiToken), After: tokenSummary(apiToken)})
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(tokenResponse{Token: token, APIToken: apiToken})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding API token", "err", err)
+	}
+}
+
+// RevokeTokenHandler deletes an API token. Users can revoke their own
+// tokens and admins those of anyone.
+func (app *Application) RevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	user := currentUser(r)
+	if !user.Can(models.PermManageUsers) {
+		tokens, err := app.Tokens.ListByUser(r.Context(), user.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error listing API tokens", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		owned := false
+		for _, t := range tokens {
+			owned = owned || t.ID == id
+		}
+		if !owned {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	if err := app.Tokens.Delete(r.Context(), id); err != nil {
+		slog.ErrorContext(r.Context(), "Error revoking API token", "id", id, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Revoked API token", "id", id, "by", user.Handle)
+	app.audit(r, models.AuditEntry{Action: models.AuditTokenRevoke, Target: "token:" + id.String()})
+
+	fmt.Fprintf(w, "Token revoked successfully!")
+}
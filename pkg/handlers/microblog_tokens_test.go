@@ -0,0 +1,192 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"microblog/pkg/auth"
+	"microblog/pkg/cache"
+	"microblog/pkg/handlers"
+	"microblog/pkg/media"
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+// createToken creates an API token as handle through the API and returns
+// it.
+func createToken(t *testing.T, b *browser, handle string, form url.Values) string {
+	t.Helper()
+	resp := postAs(t, b.server, handle, "/admin/tokens/new", form)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var created struct {
+		Token    string          `json:"token"`
+		APIToken models.APIToken `json:"api_token"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	assert.Empty(t, created.APIToken.Hash)
+	return created.Token
+}
+
+func TestAPITokens(t *testing.T) {
+	t.Parallel()
+
+	app, b := newSessionsServer(t, testUser(t, "ann", models.RoleAuthor))
+	token := createToken(t, b, "ann", url.Values{"name": {"ci"}, "scopes": {"posts:write"}, "expires_days": {"30"}})
+	assert.True(t, strings.HasPrefix(token, "mb_"), token)
+
+	resp := b.do(http.MethodPost, "/api/post/new", url.Values{"title": {"From CI"}, "content": {"ci"}}, bearer(token))
+	require.Equal(t, http.StatusOK, resp.StatusCode, "tokens need no CSRF token")
+	resp = b.do(http.MethodGet, "/api/posts", nil, bearer(token))
+	require.Equal(t, http.StatusOK, resp.StatusCode, "writing posts includes reading them")
+	var posts []models.BlogPost
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&posts))
+	require.Len(t, posts, 1)
+	assert.Equal(t, "ann", posts[0].AuthorHandle)
+
+	resp = b.do(http.MethodPost, "/admin/tokens/new", url.Values{"name": {"more"}, "scopes": {"admin"}}, bearer(token))
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "tokens cannot create tokens")
+	resp = b.do(http.MethodPost, "/api/post/new", url.Values{"title": {"Forged"}, "content": {"x"}}, bearer(token+"x"))
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "Bearer")
+
+	tokens, err := app.Tokens.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	assert.False(t, tokens[0].LastUsedAt.IsZero(), "use is recorded")
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, 30), tokens[0].ExpiresAt, time.Minute)
+
+	resp = postAs(t, b.server, "ann", "/admin/tokens/delete/"+tokens[0].ID.String(), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = b.do(http.MethodGet, "/api/posts", nil, bearer(token))
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "revoked tokens stop working")
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	t.Parallel()
+
+	_, b := newSessionsServer(t, testUser(t, "ann", models.RoleAuthor), testUser(t, "vic", models.RoleViewer))
+
+	reader := createToken(t, b, "ann", url.Values{"name": {"reader"}, "scopes": {"posts:read"}})
+	resp := b.do(http.MethodGet, "/api/posts", nil, bearer(reader))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = b.do(http.MethodPost, "/api/post/new", url.Values{"title": {"Nope"}, "content": {"x"}}, bearer(reader))
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	admin := createToken(t, b, "vic", url.Values{"name": {"wishful"}, "scopes": {"admin"}})
+	resp = b.do(http.MethodPost, "/rebuildcache", nil, bearer(admin))
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "tokens cannot do more than their user")
+
+	resp = postAs(t, b.server, "ann", "/admin/tokens/new", url.Values{"name": {"bad"}, "scopes": {"everything"}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = postAs(t, b.server, "ann", "/admin/tokens/new", url.Values{"name": {"forever"}, "scopes": {"posts:read"}, "expires_days": {"1000"}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestAPITokenOnEveryAPIRoute(t *testing.T) {
+	t.Parallel()
+
+	mediaStore, err := repository.NewFileMediaStore(t.TempDir())
+	require.NoError(t, err)
+	app := handlers.NewApplication(testUsers(t), &repository.MemoryPostStore{}, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	app.Media = media.New(mediaStore)
+	mux := http.NewServeMux()
+	handlers.RegisterRoutes(mux, app)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	b := &browser{t: t, server: server}
+
+	token := createToken(t, b, "foo", url.Values{"name": {"everything"}, "scopes": {"admin"}})
+	id := uuid.New().String()
+	for _, route := range []struct {
+		method, path string
+		form         url.Values
+	}{
+		{http.MethodGet, "/api/posts", nil},
+		{http.MethodPost, "/api/post/new", url.Values{"title": {"Hello"}, "content": {"hi"}}},
+		{http.MethodPost, "/api/post/edit", url.Values{"id": {id}, "title": {"Hello"}, "content": {"hi"}}},
+		{http.MethodPost, "/api/post/delete/" + id, nil},
+		{http.MethodPost, "/api/user/invite", url.Values{"handle": {"ann"}, "role": {"author"}}},
+		{http.MethodPost, "/api/user/edit", url.Values{"id": {id}, "role": {"author"}}},
+		{http.MethodPost, "/api/user/delete/" + id, nil},
+		{http.MethodPost, "/api/media/upload", nil},
+	} {
+		resp := b.do(route.method, route.path, route.form, bearer(token))
+		assert.NotContains(t, []int{http.StatusUnauthorized, http.StatusForbidden}, resp.StatusCode, "%s takes API tokens", route.path)
+	}
+}
+
+func TestAPITokenExpires(t *testing.T) {
+	t.Parallel()
+
+	app, b := newSessionsServer(t)
+	users, err := app.Users.List(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, app.Tokens.Create(context.Background(), &models.APIToken{
+		ID:        uuid.New(),
+		UserID:    users[0].ID,
+		Name:      "old",
+		Hash:      auth.HashToken("mb_expired"),
+		Scopes:    []models.Scope{models.ScopeAdmin},
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}))
+
+	resp := b.do(http.MethodGet, "/api/posts", nil, bearer("mb_expired"))
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestManageAPITokens(t *testing.T) {
+	t.Parallel()
+
+	bob := testUser(t, "bob", models.RoleAuthor)
+	app, b := newSessionsServer(t, testUser(t, "ann", models.RoleAuthor), bob)
+	createToken(t, b, "ann", url.Values{"name": {"ann-ci"}, "scopes": {"posts:write"}})
+	createToken(t, b, "bob", url.Values{"name": {"bob-ci"}, "scopes": {"posts:write"}})
+
+	page := func(handle string) string {
+		req, err := http.NewRequest(http.MethodGet, b.server.URL+"/admin/tokens", nil)
+		require.NoError(t, err)
+		req.SetBasicAuth(handle, handle+"-password")
+		if handle == "foo" {
+			req.SetBasicAuth("foo", "foo")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	annPage := page("ann")
+	assert.Contains(t, annPage, "ann-ci")
+	assert.NotContains(t, annPage, "bob-ci", "users only see their own tokens")
+
+	adminPage := page("foo")
+	assert.Contains(t, adminPage, "ann-ci")
+	assert.Contains(t, adminPage, "bob-ci")
+
+	bobTokens, err := app.Tokens.ListByUser(context.Background(), bob.ID)
+	require.NoError(t, err)
+	require.Len(t, bobTokens, 1)
+	bobToken := bobTokens[0].ID.String()
+	resp := postAs(t, b.server, "ann", "/admin/tokens/delete/"+bobToken, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "users cannot revoke the tokens of others")
+	resp = postAs(t, b.server, "foo", "/admin/tokens/delete/"+bobToken, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "admins can")
+}
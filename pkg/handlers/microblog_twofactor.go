@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"microblog/pkg/auth"
+	"microblog/pkg/models"
+	"microblog/pkg/ratelimit"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// maxTwoFactorAttempts is how many wrong codes can be entered before the
+// password has to be entered again.
+const maxTwoFactorAttempts = 5
+
+// requiresTwoFactor reports whether the role of user requires two-factor
+// authentication. Single sign-on users are left to their provider.
+func (app *Application) requiresTwoFactor(user *models.User) bool {
+	return user.OIDCSubject == "" && slices.Contains(app.TwoFactorRoles, user.Role)
+}
+
+// twoFactorRequired sends browsers of users who have yet to enrol in
+// two-factor authentication to do so.
+func twoFactorRequired(w http.ResponseWriter, r *http.Request) {
+	if safeMethod(r.Method) && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, "/admin/2fa", http.StatusSeeOther)
+		return
+	}
+	http.Error(w, "Two-factor authentication is required, enrol at /admin/2fa", http.StatusForbidden)
+}
+
+// checkSecondFactor reports whether code is a current TOTP code or an unused
+// recovery code of user, and records its use so it cannot be used again.
+func (app *Application) checkSecondFactor(ctx context.Context, user *models.User, code string) bool {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if step, ok := auth.CheckTOTP(user.TOTPSecret, code, app.Now(), user.TOTPLastStep); ok {
+		user.TOTPLastStep = step
+	} else if i := slices.Index(user.RecoveryCodes, auth.HashRecoveryCode(code)); i >= 0 {
+		user.RecoveryCodes = slices.Delete(user.RecoveryCodes, i, i+1)
+		slog.InfoContext(ctx, "Used recovery code", "user", user.Handle, "remaining", len(user.RecoveryCodes))
+	} else {
+		return false
+	}
+
+	user.UpdatedAt = app.Now().UTC()
+	if err := app.Users.Update(ctx, user); err != nil {
+		slog.ErrorContext(ctx, "Error recording second factor use", "user", user.Handle, "err", err)
+		return false
+	}
+	return true
+}
+
+type secondFactorPage struct {
+	Next  string
+	Error string
+}
+
+// SecondFactorHandler asks users with a pending session for a code from
+// their authenticator, or a recovery code, and signs them in with it.
+func (app *Application) SecondFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	page := secondFactorPage{Next: localPath(r.FormValue("next"))}
+	user, session := app.pendingSession(r)
+	if session == nil {
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(page.Next), http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if !sameOrigin(r) {
+			http.Error(w, "Cross-origin request refused", http.StatusForbidden)
+			return
+		}
+
+		credential := userCredential(user.Handle)
+		if wait := app.attemptWait(r, credential); wait > 0 {
+			ratelimit.SetRetryAfter(w, wait)
+			page.Error = waitMessage(wait)
+			w.WriteHeader(http.StatusTooManyRequests)
+			app.renderSecondFactor(w, r, page)
+			return
+		}
+
+		// the pending session is replaced either way, so each one can only
+		// be tried once
+		if err := app.Sessions.Delete(r.Context(), session.IDHash); err != nil {
+			slog.ErrorContext(r.Context(), "Error deleting session", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if app.checkSecondFactor(r.Context(), user, r.PostFormValue("code")) {
+			app.succeededAttempt(r, credential)
+			if err := app.startSession(w, r, user); err != nil {
+				slog.ErrorContext(r.Context(), "Error starting session", "handle", user.Handle, "err", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			slog.InfoContext(r.Context(), "Signed in", "handle", user.Handle, "two_factor", true)
+			app.auditLogin(r, user, "2fa")
+			http.Redirect(w, r, page.Next, http.StatusSeeOther)
+			return
+		}
+
+		attempts := session.Attempts + 1
+		app.failedAttempt(r, credential)
+		slog.WarnContext(r.Context(), "Failed second factor", "handle", user.Handle, "attempts", attempts)
+		app.audit(r, models.AuditEntry{ActorID: user.ID, ActorHandle: user.Handle, Via: "2fa", Action: models.AuditLoginFailed, Target: userTarget(user), After: fmt.Sprintf("attempt %d", attempts)})
+		if attempts >= maxTwoFactorAttempts {
+			clearSessionCookie(w)
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(page.Next), http.StatusSeeOther)
+			return
+		}
+		pending := &models.Session{UserID: user.ID, Pending: true, Attempts: attempts}
+		if err := app.newSession(w, r, pending, pendingSessionTTL); err != nil {
+			slog.ErrorContext(r.Context(), "Error starting session", "handle", user.Handle, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page.Error = "Invalid code"
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	app.renderSecondFactor(w, r, page)
+}
+
+func (app *Application) renderSecondFactor(w http.ResponseWriter, r *http.Request, page secondFactorPage) {
+	tpl, err := parseTemplates(r, "templates/login2fa.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error parsing login2fa.gohtml template", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = tpl.Execute(w, page)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error executing login2fa.gohtml template", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+type twoFactorPage struct {
+	User *models.User
+	// Required is set when the role of the user requires two-factor
+	// authentication, so it cannot be turned off.
+	Required bool
+	// Secret and URI enrol an authenticator, for users who have not yet.
+	Secret string
+	URI    template.URL
+	// RecoveryCodes are only shown right after they are generated.
+	RecoveryCodes []string
+	Error         string
+	CSRFToken     string
+}
+
+// TwoFactorHandler shows whether the user has two-factor authentication and
+// lets them enrol an authenticator or manage it.
+func (app *Application) TwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	app.renderTwoFactor(w, r, app.twoFactorPage(r, ""), http.StatusOK)
+}
+
+func (app *Application) twoFactorPage(r *http.Request, secret string) twoFactorPage {
+	user := currentUser(r)
+	page := twoFactorPage{User: user, Required: app.requiresTwoFactor(user), CSRFToken: csrfToken(r)}
+	if !user.TwoFactorEnabled() {
+		if secret == "" {
+			var err error
+			if secret, err = auth.NewTOTPSecret(); err != nil {
+				slog.ErrorContext(r.Context(), "Error creating TOTP secret", "err", err)
+			}
+		}
+		page.Secret = secret
+		page.URI = template.URL(auth.TOTPURI(siteName, user.Handle, secret))
+	}
+	return page
+}
+
+func (app *Application) renderTwoFactor(w http.ResponseWriter, r *http.Request, page twoFactorPage, status int) {
+	tpl, err := parseTemplates(r, "templates/twofactor.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error parsing twofactor.gohtml template", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+	err = tpl.Execute(w, page)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error executing twofactor.gohtml template", "err", err)
+	}
+}
+
+// EnableTwoFactorHandler enrols the authenticator with the secret shown on
+// the two-factor page, once a code from it proves it was set up. It shows
+// the new recovery codes.
+func (app *Application) EnableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := currentUser(r)
+	if user.TwoFactorEnabled() {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusBadRequest)
+		return
+	}
+	// the secret comes back from the page, so it is held to the length of
+	// those made by NewTOTPSecret
+	secret := r.PostFormValue("secret")
+	step, ok := auth.CheckTOTP(secret, strings.TrimSpace(r.PostFormValue("code")), app.Now(), 0)
+	if !ok || len(secret) != 32 {
+		page := app.twoFactorPage(r, secret)
+		page.Error = "Invalid code, check the time on your device and try again"
+		app.renderTwoFactor(w, r, page, http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error creating recovery codes", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user.TOTPSecret = secret
+	user.TOTPLastStep = step
+	user.RecoveryCodes = hashes
+	user.UpdatedAt = app.Now().UTC()
+	if err := app.Users.Update(r.Context(), user); err != nil {
+		slog.ErrorContext(r.Context(), "Error enabling two-factor authentication", "user", user.Handle, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Enabled two-factor authentication", "user", user.Handle)
+	app.audit(r, models.AuditEntry{Action: models.AuditTwoFactorOn, Target: userTarget(user), Before: "disabled", After: "enabled"})
+
+	page := app.twoFactorPage(r, "")
+	page.RecoveryCodes = codes
+	app.renderTwoFactor(w, r, page, http.StatusOK)
+}
+
+// RecoveryCodesHandler replaces the recovery codes of the user, who has to
+// confirm with a code.
+func (app *Application) RecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.confirmSecondFactor(w, r)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error creating recovery codes", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user.RecoveryCodes = hashes
+	user.UpdatedAt = app.Now().UTC()
+	if err := app.Users.Update(r.Context(), user); err != nil {
+		slog.ErrorContext(r.Context(), "Error replacing recovery codes", "user", user.Handle, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Replaced recovery codes", "user", user.Handle)
+	app.audit(r, models.AuditEntry{Action: models.AuditRecoveryCodes, Target: userTarget(user), After: fmt.Sprintf("%d recovery codes", len(hashes))})
+
+	page := app.twoFactorPage(r, "")
+	page.RecoveryCodes = codes
+	app.renderTwoFactor(w, r, page, http.StatusOK)
+}
+
+// DisableTwoFactorHandler turns two-factor authentication off for the user,
+// who has to confirm with a code, unless their role requires it.
+func (app *Application) DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if app.requiresTwoFactor(currentUser(r)) {
+		http.Error(w, "Your role requires two-factor authentication", http.StatusBadRequest)
+		return
+	}
+	user, ok := app.confirmSecondFactor(w, r)
+	if !ok {
+		return
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPLastStep = 0
+	user.RecoveryCodes = nil
+	user.UpdatedAt = app.Now().UTC()
+	if err := app.Users.Update(r.Context(), user); err != nil {
+		slog.ErrorContext(r.Context(), "Error disabling two-factor authentication", "user", user.Handle, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Disabled two-factor authentication", "user", user.Handle)
+	app.audit(r, models.AuditEntry{Action: models.AuditTwoFactorOff, Target: userTarget(user), Before: "enabled", After: "disabled"})
+
+	http.Redirect(w, r, "/admin/2fa", http.StatusSeeOther)
+}
+
+// confirmSecondFactor checks the code sent with changes to two-factor
+// authentication, so a session left open is not enough to make them.
+func (app *Application) confirmSecondFactor(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	user := currentUser(r)
+	if !user.TwoFactorEnabled() {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return nil, false
+	}
+	if !app.checkSecondFactor(r.Context(), user, r.PostFormValue("code")) {
+		page := app.twoFactorPage(r, "")
+		page.Error = "Invalid code"
+		app.renderTwoFactor(w, r, page, http.StatusBadRequest)
+		return nil, false
+	}
+	return user, true
+}
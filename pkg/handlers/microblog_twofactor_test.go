@@ -0,0 +1,189 @@
+package handlers_test
+
+import (
+	"io"
+	"microblog/pkg/auth"
+	"microblog/pkg/models"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const totpSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// clock is a fake clock for the application, moved on by tests.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) code(t *testing.T) string {
+	t.Helper()
+	code, err := auth.TOTPCode(totpSecret, c.now)
+	require.NoError(t, err)
+	return code
+}
+
+// signIn submits the login form for handle and keeps the session cookie it
+// gets, which may be pending.
+func (b *browser) signIn(handle string) *http.Response {
+	b.t.Helper()
+	resp := b.do(http.MethodPost, "/login", url.Values{"handle": {handle}, "password": {handle + "-password"}, "next": {"/admin/media"}}, nil)
+	require.Equal(b.t, http.StatusSeeOther, resp.StatusCode)
+	b.cookie = sessionCookie(resp)
+	require.NotNil(b.t, b.cookie)
+	return resp
+}
+
+func twoFactorUser(t *testing.T) (*models.User, string) {
+	t.Helper()
+	ann := testUser(t, "ann", models.RoleAuthor)
+	codes, hashes, err := auth.NewRecoveryCodes()
+	require.NoError(t, err)
+	ann.TOTPSecret = totpSecret
+	ann.RecoveryCodes = hashes
+	return ann, codes[0]
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	t.Parallel()
+
+	ann, recoveryCode := twoFactorUser(t)
+	app, b := newSessionsServer(t, ann)
+	c := &clock{now: time.Unix(1700000000, 0)}
+	app.Now = c.Now
+
+	resp := b.signIn("ann")
+	assert.Equal(t, "/login/2fa?next=%2Fadmin%2Fmedia", resp.Header.Get("Location"))
+	resp = b.do(http.MethodGet, "/admin/post/new", nil, nil)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode, "the password alone does not sign in")
+	assert.Contains(t, resp.Header.Get("Location"), "/login?")
+
+	resp = b.do(http.MethodPost, "/login/2fa", url.Values{"code": {"000000"}, "next": {"/admin/media"}}, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	b.cookie = sessionCookie(resp)
+	require.NotNil(t, b.cookie, "a new pending session replaces the one tried")
+
+	code := c.code(t)
+	resp = b.do(http.MethodPost, "/login/2fa", url.Values{"code": {code}, "next": {"/admin/media"}}, nil)
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/admin/media", resp.Header.Get("Location"))
+	b.cookie = sessionCookie(resp)
+	resp = b.do(http.MethodGet, "/admin/post/new", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	b.signIn("ann")
+	resp = b.do(http.MethodPost, "/login/2fa", url.Values{"code": {code}}, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "codes cannot be replayed")
+	b.cookie = sessionCookie(resp)
+
+	resp = b.do(http.MethodPost, "/login/2fa", url.Values{"code": {recoveryCode}}, nil)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode, "recovery codes stand in for the app")
+	b.signIn("ann")
+	resp = b.do(http.MethodPost, "/login/2fa", url.Values{"code": {recoveryCode}}, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "recovery codes work once")
+
+	resp = postAs(t, b.server, "ann", "/api/post/new", url.Values{"title": {"Hi"}, "content": {"hi"}})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "basic auth cannot carry a second factor")
+}
+
+func TestTwoFactorAttemptsAreLimited(t *testing.T) {
+	t.Parallel()
+
+	ann, _ := twoFactorUser(t)
+	app, b := newSessionsServer(t, ann)
+	c := &clock{now: time.Unix(1700000000, 0)}
+	app.Now = c.Now
+
+	b.signIn("ann")
+	for range 4 {
+		resp := b.do(http.MethodPost, "/login/2fa", url.Values{"code": {"000000"}}, nil)
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		b.cookie = sessionCookie(resp)
+	}
+	resp := b.do(http.MethodPost, "/login/2fa", url.Values{"code": {"000000"}}, nil)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Location"), "/login?")
+
+	resp = b.do(http.MethodPost, "/login/2fa", url.Values{"code": {c.code(t)}}, nil)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Location"), "/login?", "the password has to be entered again")
+}
+
+var secretInput = regexp.MustCompile(`name="secret" value="([A-Z2-7]+)"`)
+
+func TestEnrolTwoFactor(t *testing.T) {
+	t.Parallel()
+
+	editor := testUser(t, "eve", models.RoleEditor)
+	app, b := newSessionsServer(t, editor)
+	c := &clock{now: time.Unix(1700000000, 0)}
+	app.Now = c.Now
+	app.TwoFactorRoles = []models.Role{models.RoleEditor}
+
+	b.signIn("eve")
+	resp := b.do(http.MethodGet, "/admin/post/new", nil, nil)
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/admin/2fa", resp.Header.Get("Location"), "editors have to enrol first")
+	resp = postAs(t, b.server, "eve", "/api/post/new", url.Values{"title": {"Hi"}, "content": {"hi"}})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	token := b.csrfToken("/admin/2fa")
+	resp = b.do(http.MethodGet, "/admin/2fa", nil, nil)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "otpauth://totp/Ashouri:eve?")
+	match := secretInput.FindSubmatch(body)
+	require.NotNil(t, match)
+	secret := string(match[1])
+
+	resp = b.do(http.MethodPost, "/admin/2fa/enable", url.Values{"csrf_token": {token}, "secret": {secret}, "code": {"000000"}}, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	code, err := auth.TOTPCode(secret, c.now)
+	require.NoError(t, err)
+	resp = b.do(http.MethodPost, "/admin/2fa/enable", url.Values{"csrf_token": {token}, "secret": {secret}, "code": {code}}, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Regexp(t, `<li>[a-z2-7]{4}-[a-z2-7]{4}</li>`, string(body))
+
+	resp = b.do(http.MethodGet, "/admin/post/new", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	c.now = c.now.Add(time.Minute)
+	code, err = auth.TOTPCode(secret, c.now)
+	require.NoError(t, err)
+	resp = b.do(http.MethodPost, "/admin/2fa/disable", url.Values{"csrf_token": {token}, "code": {code}}, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "editors cannot turn it off")
+}
+
+func TestDisableTwoFactor(t *testing.T) {
+	t.Parallel()
+
+	ann, _ := twoFactorUser(t)
+	app, b := newSessionsServer(t, ann)
+	c := &clock{now: time.Unix(1700000000, 0)}
+	app.Now = c.Now
+
+	b.signIn("ann")
+	resp := b.do(http.MethodPost, "/login/2fa", url.Values{"code": {c.code(t)}}, nil)
+	b.cookie = sessionCookie(resp)
+	token := b.csrfToken("/admin/2fa")
+
+	c.now = c.now.Add(30 * time.Second)
+	resp = b.do(http.MethodPost, "/admin/2fa/disable", url.Values{"csrf_token": {token}, "code": {"000000"}}, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = b.do(http.MethodPost, "/admin/2fa/disable", url.Values{"csrf_token": {token}, "code": {c.code(t)}}, nil)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+
+	resp = postAs(t, b.server, "ann", "/api/post/new", url.Values{"title": {"Hi"}, "content": {"hi"}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "basic auth works again")
+}
@@ -0,0 +1,504 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"microblog/pkg/auth"
+	"microblog/pkg/models"
+	"microblog/pkg/ratelimit"
+	"microblog/pkg/repository"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// inviteTTL is how long an invite link can be used to choose a password.
+const inviteTTL = 7 * 24 * time.Hour
+
+var validHandle = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+type userKey struct{}
+
+func withUser(ctx context.Context, user *models.User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// currentUser returns the signed in user, which authorize guarantees for
+// the handlers it wraps.
+func currentUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(userKey{}).(*models.User)
+	return user
+}
+
+// authorize lets requests through from an active user whose role has
+// permission. Browsers sign in with a session, whose CSRF token has to be
+// sent along with every state-changing request. Scripts use an API token,
+// whose scopes have to cover permission as well, or basic auth. Requests
+// without valid credentials are asked for them and users without the
+// permission are refused.
+func (app *Application) authorize(permission models.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var user *models.User
+		if token, ok := bearerToken(r); ok {
+			if wait := app.attemptWait(r, ""); wait > 0 {
+				ratelimit.TooManyRequests(w, wait)
+				return
+			}
+			var apiToken *models.APIToken
+			if user, apiToken = app.tokenUser(r, token); user == nil {
+				app.failedAttempt(r, "")
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if ok, wait := app.Limits.Credential.Allow("token:" + apiToken.ID.String()); !ok {
+				ratelimit.TooManyRequests(w, wait)
+				return
+			}
+			if !apiToken.Allows(permission) {
+				slog.WarnContext(ctx, "API token scope denied", "user", user.Handle, "token", apiToken.Name, "permission", permission)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			ctx = withVia(ctx, "token:"+apiToken.Name)
+		} else if sessionUser, session := app.sessionUser(r); session != nil {
+			user = sessionUser
+			if !safeMethod(r.Method) && !validCSRF(r, session) {
+				slog.WarnContext(ctx, "Invalid CSRF token", "user", user.Handle)
+				http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+				return
+			}
+			if permission != models.PermManageTwoFactor && app.requiresTwoFactor(user) && !user.TwoFactorEnabled() {
+				twoFactorRequired(w, r)
+				return
+			}
+			ctx = withVia(withSession(ctx, session), "session")
+		} else {
+			var wait time.Duration
+			if user, wait = app.authenticate(r); wait > 0 {
+				ratelimit.TooManyRequests(w, wait)
+				return
+			}
+			if user == nil {
+				unauthorized(w, r)
+				return
+			}
+			// browsers may send remembered basic auth credentials along
+			// with requests made by other sites
+			if !safeMethod(r.Method) && !sameOrigin(r) {
+				http.Error(w, "Cross-origin request refused", http.StatusForbidden)
+				return
+			}
+			ctx = withVia(ctx, "basic")
+		}
+		if !user.Can(permission) {
+			slog.WarnContext(ctx, "Permission denied", "user", user.Handle, "permission", permission)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withUser(ctx, user)))
+	})
+}
+
+// authenticate checks the basic auth credentials of r against the user
+// store. It returns how long to wait instead when the client may not try
+// now.
+func (app *Application) authenticate(r *http.Request) (*models.User, time.Duration) {
+	handle, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, 0
+	}
+	user, wait := app.signIn(r, handle, password)
+	if user != nil && (user.TwoFactorEnabled() || app.requiresTwoFactor(user)) {
+		// basic auth has no room for a second factor, scripts of these
+		// users need an API token
+		slog.WarnContext(r.Context(), "Basic auth refused for two-factor user", "user", handle)
+		return nil, 0
+	}
+	return user, wait
+}
+
+// checkPassword returns the active user with handle when password is
+// theirs.
+func (app *Application) checkPassword(ctx context.Context, handle, password string) (*models.User, bool) {
+	user, err := app.Users.GetByHandle(ctx, handle)
+	if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+		slog.ErrorContext(ctx, "Error getting user", "handle", handle, "err", err)
+	}
+	if user == nil {
+		// checked anyway so unknown handles take as long as known ones
+		auth.CheckPassword("", password)
+		return nil, false
+	}
+	if !auth.CheckPassword(user.PasswordHash, password) || !user.Active() {
+		return nil, false
+	}
+	return user, true
+}
+
+// EnsureAdmin creates an admin with handle and password when there are no
+// users yet, so a fresh install can be signed in to. It does nothing once
+// any user exists.
+func (app *Application) EnsureAdmin(ctx context.Context, handle, password string) error {
+	users, err := app.Users.List(ctx)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return nil
+	}
+	if handle == "" || password == "" {
+		return errors.New("there are no users, set auth.username and auth.password to create the first admin")
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	err = app.Users.Create(ctx, &models.User{
+		ID:           uuid.New(),
+		Handle:       handle,
+		PasswordHash: hash,
+		Role:         models.RoleAdmin,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	})
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Created first admin", "handle", handle)
+	return nil
+}
+
+type usersPage struct {
+	Users     []*models.User
+	Roles     []models.Role
+	Me        *models.User
+	CSRFToken string
+}
+
+func (app *Application) UsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := app.Users.List(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing users", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tpl, err := parseTemplates(r, "templates/users.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error parsing users.gohtml template", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = tpl.Execute(w, usersPage{Users: users, Roles: models.Roles, Me: currentUser(r), CSRFToken: csrfToken(r)})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error executing users.gohtml template", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+type inviteResponse struct {
+	User      *models.User `json:"user"`
+	InviteURL string       `json:"invite_url"`
+}
+
+// InviteUserHandler creates a user without a password and returns a link
+// for them to choose one. The link is only shown here, so it has to be
+// passed on to the user.
+func (app *Application) InviteUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	handle := r.FormValue("handle")
+	if !validHandle.MatchString(handle) {
+		http.Error(w, "Handle must be up to 64 lowercase letters, digits or dashes", http.StatusBadRequest)
+		return
+	}
+	role := models.Role(r.FormValue("role"))
+	if !role.Valid() {
+		http.Error(w, "Unknown role", http.StatusBadRequest)
+		return
+	}
+
+	token, inviteHash, err := auth.NewToken()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error creating invite token", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	user := &models.User{
+		ID:            uuid.New(),
+		Handle:        handle,
+		Name:          r.FormValue("name"),
+		Role:          role,
+		InviteHash:    inviteHash,
+		InviteExpires: now.Add(inviteTTL),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	err = app.Users.Create(r.Context(), user)
+	if errors.Is(err, repository.ErrHandleTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error creating user", "handle", handle, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Invited user", "handle", handle, "role", role, "by", currentUser(r).Handle)
+	app.audit(r, models.AuditEntry{Action: models.AuditUserInvite, Target: userTarget(user), After: userSummary(user)})
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(inviteResponse{User: user, InviteURL: app.absoluteURL(r, "/invite/"+token)})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding invite", "handle", handle, "err", err)
+	}
+}
+
+// UpdateUserHandler changes the name, role or disabled state of a user.
+// Admins cannot change their own role or disable themselves, so there is
+// always an admin left.
+func (app *Application) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := app.userFromForm(w, r)
+	if !ok {
+		return
+	}
+
+	role := models.Role(r.FormValue("role"))
+	if !role.Valid() {
+		http.Error(w, "Unknown role", http.StatusBadRequest)
+		return
+	}
+	disabled, _ := strconv.ParseBool(r.FormValue("disabled"))
+	if user.ID == currentUser(r).ID && (role != user.Role || disabled) {
+		http.Error(w, "You cannot change your own role or disable yourself", http.StatusBadRequest)
+		return
+	}
+
+	before := userSummary(user)
+	user.Name = r.FormValue("name")
+	user.Role = role
+	user.Disabled = disabled
+	user.UpdatedAt = time.Now().UTC()
+	if err := app.Users.Update(r.Context(), user); err != nil {
+		slog.ErrorContext(r.Context(), "Error updating user", "handle", user.Handle, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Updated user", "handle", user.Handle, "role", role, "disabled", disabled, "by", currentUser(r).Handle)
+	app.audit(r, models.AuditEntry{Action: models.AuditUserUpdate, Target: userTarget(user), Before: before, After: userSummary(user)})
+
+	// posts show the name of their author
+	app.Cache.Invalidate()
+	fmt.Fprintf(w, "User updated successfully!")
+}
+
+// DeleteUserHandler removes a user. Their posts are kept without an author.
+func (app *Application) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	if id == currentUser(r).ID {
+		http.Error(w, "You cannot delete yourself", http.StatusBadRequest)
+		return
+	}
+
+	deleted := models.AuditEntry{Action: models.AuditUserDelete, Target: "user:" + id.String()}
+	if user, err := app.Users.GetByID(r.Context(), id); err == nil {
+		deleted.Target, deleted.Before = userTarget(user), userSummary(user)
+	}
+	if err := app.Users.Delete(r.Context(), id); err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting user", "id", id, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Deleted user", "id", id, "by", currentUser(r).Handle)
+	app.audit(r, deleted)
+
+	app.Cache.Invalidate()
+	fmt.Fprintf(w, "User deleted successfully!")
+}
+
+func (app *Application) userFromForm(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	id, err := uuid.Parse(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return nil, false
+	}
+	user, err := app.Users.GetByID(r.Context(), id)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error getting user", "id", id, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
+
+type invitePage struct {
+	User  *models.User
+	Error string
+}
+
+// AcceptInviteHandler shows the form to choose a password for an invite
+// link, and sets it when the form is submitted.
+func (app *Application) AcceptInviteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := app.Users.GetByInvite(r.Context(), auth.HashToken(r.PathValue("token")))
+	if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+		slog.ErrorContext(r.Context(), "Error getting invited user", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil || time.Now().After(user.InviteExpires) {
+		http.Error(w, "This invite link is invalid or has expired", http.StatusNotFound)
+		return
+	}
+
+	page := invitePage{User: user}
+	if r.Method == http.MethodPost {
+		password := r.FormValue("password")
+		if err := auth.ValidatePassword(password); err != nil {
+			page.Error = err.Error()
+		} else if password != r.FormValue("confirm") {
+			page.Error = "Passwords do not match"
+		} else {
+			app.acceptInvite(w, r, user, password)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	tpl, err := parseTemplates(r, "templates/invite.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error parsing invite.gohtml template", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = tpl.Execute(w, page)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error executing invite.gohtml template", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (app *Application) acceptInvite(w http.ResponseWriter, r *http.Request, user *models.User, password string) {
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error hashing password", "handle", user.Handle, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	user.PasswordHash = hash
+	user.InviteHash = ""
+	user.InviteExpires = time.Time{}
+	user.UpdatedAt = time.Now().UTC()
+	if err := app.Users.Update(r.Context(), user); err != nil {
+		slog.ErrorContext(r.Context(), "Error accepting invite", "handle", user.Handle, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Accepted invite", "handle", user.Handle)
+	app.auditLogin(r, user, "invite")
+
+	if err := app.startSession(w, r, user); err != nil {
+		slog.ErrorContext(r.Context(), "Error starting session", "handle", user.Handle, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, afterLogin, http.StatusSeeOther)
+}
+
+// AuthorHandler lists the posts of a user.
+func (app *Application) AuthorHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.Users.GetByHandle(r.Context(), r.PathValue("handle"))
+	if errors.Is(err, repository.ErrUserNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error getting author", "handle", r.PathValue("handle"), "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	blogPosts, err := app.PostStore.GetByAuthor(r.Context(), user.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error getting posts by author", "handle", user.Handle, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tpl, err := parseTemplates(r, "templates/home.gohtml", "templates/meta.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error parsing home.gohtml template", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = tpl.Execute(w, app.authorPage(r, user, app.Renderer.Posts(r.Context(), blogPosts)))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error executing home.gohtml template", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// MayEmbed reports whether the author of blogPost may embed iframes, for
+// the trusted policy of the renderer. Disabled users may not.
+func (app *Application) MayEmbed(ctx context.Context, blogPost *models.BlogPost) bool {
+	if blogPost.AuthorID == uuid.Nil {
+		return false
+	}
+	author, err := app.Users.GetByID(ctx, blogPost.AuthorID)
+	if err != nil {
+		if !errors.Is(err, repository.ErrUserNotFound) {
+			slog.ErrorContext(ctx, "Error getting author", "id", blogPost.AuthorID, "err", err)
+		}
+		return false
+	}
+	return !author.Disabled && author.Role.Can(models.PermEmbed)
+}
+
+func authorPath(handle string) string {
+	return "/author/" + url.PathEscape(handle)
+}
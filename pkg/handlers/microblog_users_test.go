@@ -0,0 +1,306 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"microblog/pkg/auth"
+	"microblog/pkg/cache"
+	"microblog/pkg/handlers"
+	"microblog/pkg/models"
+	"microblog/pkg/render"
+	"microblog/pkg/repository"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testUser(t *testing.T, handle string, role models.Role) *models.User {
+	t.Helper()
+	hash, err := auth.HashPassword(handle + "-password")
+	require.NoError(t, err)
+	return &models.User{ID: uuid.New(), Handle: handle, Name: strings.ToUpper(handle), PasswordHash: hash, Role: role}
+}
+
+func newUsersServer(t *testing.T, users repository.UserStore, store repository.PostStore) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	handlers.RegisterRoutes(mux, handlers.NewApplication(users, store, cache.New([]*models.BlogPost{}, &sync.Mutex{})))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// postAs submits form to path with the basic auth credentials of handle,
+// whose password is "<handle>-password" or foo for the admin foo.
+func postAs(t *testing.T, server *httptest.Server, handle, path string, form url.Values) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	password := handle + "-password"
+	if handle == "foo" {
+		password = "foo"
+	}
+	req.SetBasicAuth(handle, password)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestRolesAreEnforced(t *testing.T) {
+	t.Parallel()
+
+	viewer := testUser(t, "viewer", models.RoleViewer)
+	author := testUser(t, "author", models.RoleAuthor)
+	editor := testUser(t, "editor", models.RoleEditor)
+	other := &models.BlogPost{ID: uuid.New(), Name: "other", Title: "Other", Content: "other", AuthorID: uuid.New()}
+	store := &repository.MemoryPostStore{BlogPosts: []*models.BlogPost{other}}
+	server := newUsersServer(t, testUsers(t, viewer, author, editor), store)
+
+	post := url.Values{"title": {"Mine"}, "content": {"mine"}}
+	resp := postAs(t, server, "viewer", "/api/post/new", post)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "viewers cannot publish")
+
+	resp = postAs(t, server, "author", "/api/post/new", post)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var created models.BlogPost
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	assert.Equal(t, author.ID, created.AuthorID)
+	assert.Equal(t, "author", created.AuthorHandle)
+
+	edit := func(id uuid.UUID) url.Values {
+		return url.Values{"id": {id.String()}, "title": {"Edited"}, "content": {"edited"}}
+	}
+	resp = postAs(t, server, "author", "/api/post/edit", edit(created.ID))
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "authors can edit their own posts")
+	resp = postAs(t, server, "author", "/api/post/edit", edit(other.ID))
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "authors cannot edit the posts of others")
+	resp = postAs(t, server, "author", "/api/post/delete/"+other.ID.String(), nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "Other", other.Title)
+
+	resp = postAs(t, server, "editor", "/api/post/edit", edit(other.ID))
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "editors can edit any post")
+	resp = postAs(t, server, "editor", "/api/user/invite", url.Values{"handle": {"new"}, "role": {"author"}})
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "only admins manage users")
+
+	resp = postAs(t, server, "author", "/api/post/edit", edit(uuid.New()))
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestDisabledUserCannotSignIn(t *testing.T) {
+	t.Parallel()
+
+	author := testUser(t, "author", models.RoleAuthor)
+	author.Disabled = true
+	server := newUsersServer(t, testUsers(t, author), &repository.MemoryPostStore{})
+
+	resp := postAs(t, server, "author", "/api/post/new", url.Values{"title": {"Title"}, "content": {"content"}})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestInviteUser(t *testing.T) {
+	t.Parallel()
+
+	users := testUsers(t)
+	server := newUsersServer(t, users, &repository.MemoryPostStore{})
+
+	resp := postAs(t, server, "foo", "/api/user/invite", url.Values{"handle": {"jane"}, "name": {"Jane"}, "role": {"editor"}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var invite struct {
+		User      models.User `json:"user"`
+		InviteURL string      `json:"invite_url"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&invite))
+	assert.Empty(t, invite.User.PasswordHash)
+	require.True(t, strings.HasPrefix(invite.InviteURL, server.URL+"/invite/"), invite.InviteURL)
+
+	resp = postAs(t, server, "foo", "/api/user/invite", url.Values{"handle": {"jane"}, "role": {"author"}})
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// the invite link works without signing in
+	resp, err := http.Get(invite.InviteURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "Welcome, Jane")
+
+	resp, err = http.PostForm(invite.InviteURL, url.Values{"password": {"short"}, "confirm": {"short"}})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err = client.PostForm(invite.InviteURL, url.Values{"password": {"jane-password"}, "confirm": {"jane-password"}})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.NotNil(t, sessionCookie(resp), "accepting an invite signs in")
+
+	resp = postAs(t, server, "jane", "/api/post/new", url.Values{"title": {"Hello"}, "content": {"hello"}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "the invited user can sign in")
+
+	resp, err = http.Get(invite.InviteURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "invite links work once")
+}
+
+func TestInviteExpires(t *testing.T) {
+	t.Parallel()
+
+	token, hash, err := auth.NewToken()
+	require.NoError(t, err)
+	invited := &models.User{ID: uuid.New(), Handle: "late", Role: models.RoleAuthor, InviteHash: hash, InviteExpires: time.Now().Add(-time.Minute)}
+	server := newUsersServer(t, testUsers(t, invited), &repository.MemoryPostStore{})
+
+	resp, err := http.Get(server.URL + "/invite/" + token)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestManageUsers(t *testing.T) {
+	t.Parallel()
+
+	author := testUser(t, "author", models.RoleAuthor)
+	users := testUsers(t, author)
+	server := newUsersServer(t, users, &repository.MemoryPostStore{})
+	admin, err := users.GetByHandle(context.Background(), "foo")
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/admin/users", nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("foo", "foo")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `action="/api/user/delete/`+author.ID.String()+`"`)
+	assert.NotContains(t, string(body), `action="/api/user/delete/`+admin.ID.String()+`"`, "admins cannot delete themselves")
+
+	resp = postAs(t, server, "foo", "/api/user/edit", url.Values{"id": {author.ID.String()}, "name": {"Ann"}, "role": {"editor"}, "disabled": {"true"}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	updated, err := users.GetByID(context.Background(), author.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Ann", updated.Name)
+	assert.Equal(t, models.RoleEditor, updated.Role)
+	assert.True(t, updated.Disabled)
+
+	resp = postAs(t, server, "foo", "/api/user/edit", url.Values{"id": {admin.ID.String()}, "role": {"viewer"}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "admins cannot demote themselves")
+	resp = postAs(t, server, "foo", "/api/user/delete/"+admin.ID.String(), nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = postAs(t, server, "foo", "/api/user/delete/"+author.ID.String(), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = users.GetByID(context.Background(), author.ID)
+	assert.ErrorIs(t, err, repository.ErrUserNotFound)
+}
+
+func TestAuthorPage(t *testing.T) {
+	t.Parallel()
+
+	author := testUser(t, "ann", models.RoleAuthor)
+	store := &repository.MemoryPostStore{BlogPosts: []*models.BlogPost{
+		{ID: uuid.New(), Name: "first", Title: "First", Content: "one", AuthorID: author.ID, AuthorHandle: "ann", AuthorName: "ANN"},
+		{ID: uuid.New(), Name: "untitled", Title: "Someone else's", Content: "two"},
+	}}
+	server := newUsersServer(t, testUsers(t, author), store)
+
+	resp, err := http.Get(server.URL + "/author/ann")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "<title>ANN - Ashouri</title>")
+	assert.Contains(t, string(body), "<h2>Posts by ANN</h2>")
+	assert.Contains(t, string(body), `<a href="/post/first">First</a>`)
+	assert.NotContains(t, string(body), "Someone else")
+
+	resp, err = http.Get(server.URL + "/post/first")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `By <a href="/author/ann">ANN</a>`)
+	assert.Contains(t, string(body), `"author":{"@type":"Person","name":"ANN","url":"`+server.URL+`/author/ann"}`)
+
+	resp, err = http.Get(server.URL + "/author/nobody")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestOnlyAdminsAndEditorsMayEmbed(t *testing.T) {
+	t.Parallel()
+
+	editor := testUser(t, "ed", models.RoleEditor)
+	author := testUser(t, "ann", models.RoleAuthor)
+	disabled := testUser(t, "dan", models.RoleEditor)
+	disabled.Disabled = true
+	users := testUsers(t, editor, author, disabled)
+	admin, err := users.GetByHandle(context.Background(), "foo")
+	require.NoError(t, err)
+	app := handlers.NewApplication(users, &repository.MemoryPostStore{}, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+	renderer := render.New(render.Policy{IframeHosts: []string{"www.youtube-nocookie.com"}, Trusted: app.MayEmbed})
+
+	embed := `<iframe src="https://www.youtube-nocookie.com/embed/abc"></iframe>`
+	for _, tc := range []struct {
+		name     string
+		authorID uuid.UUID
+		embeds   bool
+	}{
+		{name: "admin", authorID: admin.ID, embeds: true},
+		{name: "editor", authorID: editor.ID, embeds: true},
+		{name: "author", authorID: author.ID},
+		{name: "disabled editor", authorID: disabled.ID},
+		{name: "deleted user", authorID: uuid.New()},
+		{name: "no author", authorID: uuid.Nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			blogPost := &models.BlogPost{Title: "embed", Content: embed, AuthorID: tc.authorID}
+			renderer.Prepare(context.Background(), blogPost)
+			if tc.embeds {
+				assert.Contains(t, string(blogPost.ContentHTML), `<iframe src="https://www.youtube-nocookie.com/embed/abc"`)
+			} else {
+				assert.NotContains(t, string(blogPost.ContentHTML), "<iframe")
+			}
+		})
+	}
+}
+
+func TestEnsureAdmin(t *testing.T) {
+	t.Parallel()
+
+	users := repository.NewMemoryUserStore()
+	app := handlers.NewApplication(users, &repository.MemoryPostStore{}, cache.New([]*models.BlogPost{}, &sync.Mutex{}))
+
+	assert.ErrorContains(t, app.EnsureAdmin(context.Background(), "", ""), "there are no users")
+
+	require.NoError(t, app.EnsureAdmin(context.Background(), "admin", "admin-password"))
+	admin, err := users.GetByHandle(context.Background(), "admin")
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleAdmin, admin.Role)
+	assert.True(t, auth.CheckPassword(admin.PasswordHash, "admin-password"))
+
+	require.NoError(t, app.EnsureAdmin(context.Background(), "other", "other-password"))
+	list, err := users.List(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, list, 1, "nothing is created once a user exists")
+}
@@ -0,0 +1,86 @@
+package indexnow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultEndpoint shares submissions with every search engine taking part
+// in IndexNow.
+const DefaultEndpoint = "https://api.indexnow.org/indexnow"
+
+// KeyPath is where the site serves the key, proving the submissions come
+// from the owner of the host.
+const KeyPath = "/indexnow.txt"
+
+// Notifier tells search engines about new and changed URLs so they are
+// crawled without waiting for the sitemap to be read.
+type Notifier struct {
+	Endpoint string
+	Key      string
+	Client   *http.Client
+}
+
+func New(endpoint, key string) *Notifier {
+	return &Notifier{
+		Endpoint: endpoint,
+		Key:      key,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type submission struct {
+	Host        string   `json:"host"`
+	Key         string   `json:"key"`
+	KeyLocation string   `json:"keyLocation"`
+	URLList     []string `json:"urlList"`
+}
+
+// Notify submits absolute URLs, which must all be on the same host.
+func (n *Notifier) Notify(ctx context.Context, urls ...string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+
+	u, err := url.Parse(urls[0])
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		return errors.New("indexnow: URLs must be absolute")
+	}
+
+	body, err := json.Marshal(submission{
+		Host:        u.Host,
+		Key:         n.Key,
+		KeyLocation: u.Scheme + "://" + u.Host + KeyPath,
+		URLList:     urls,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 202 means the key is yet to be validated, which is still accepted
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("indexnow: %s responded %s", n.Endpoint, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package indexnow_test
+
+import (
+	"context"
+	"encoding/json"
+	"microblog/pkg/indexnow"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotify(t *testing.T) {
+	var got *http.Request
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+	}))
+	defer server.Close()
+
+	notifier := indexnow.New(server.URL+"/indexnow", "0123456789abcdef")
+	err := notifier.Notify(context.Background(), "https://example.com/post/hello", "https://example.com/")
+	require.NoError(t, err)
+
+	require.NotNil(t, got)
+	assert.Equal(t, http.MethodPost, got.Method)
+	assert.Equal(t, "/indexnow", got.URL.Path)
+	assert.Equal(t, "application/json; charset=utf-8", got.Header.Get("Content-Type"))
+	assert.Equal(t, map[string]any{
+		"host":        "example.com",
+		"key":         "0123456789abcdef",
+		"keyLocation": "https://example.com/indexnow.txt",
+		"urlList":     []any{"https://example.com/post/hello", "https://example.com/"},
+	}, body)
+}
+
+func TestNotifyStatus(t *testing.T) {
+	for status, ok := range map[int]bool{
+		http.StatusOK:                  true,
+		http.StatusAccepted:            true,
+		http.StatusBadRequest:          false,
+		http.StatusForbidden:           false,
+		http.StatusUnprocessableEntity: false,
+		http.StatusTooManyRequests:     false,
+	} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			err := indexnow.New(server.URL, "key").Notify(context.Background(), "https://example.com/")
+			if ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, http.StatusText(status))
+			}
+		})
+	}
+}
+
+func TestNotifyWithoutRequest(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	notifier := indexnow.New(server.URL, "key")
+	assert.NoError(t, notifier.Notify(context.Background()))
+	assert.Error(t, notifier.Notify(context.Background(), "/post/hello"), "URLs must be absolute")
+	assert.Zero(t, requests)
+}
@@ -0,0 +1,128 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"microblog/pkg/logging"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func records(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var record map[string]any
+		require.NoError(t, dec.Decode(&record))
+		out = append(out, record)
+	}
+	return out
+}
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, slog.LevelInfo, "json")
+	require.NoError(t, err)
+
+	handler := logging.Middleware(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.InfoContext(r.Context(), "handling")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/post/foo?token=secret", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	id := rec.Header().Get(logging.RequestIDHeader)
+	require.NotEmpty(t, id)
+
+	logged := records(t, &buf)
+	require.Len(t, logged, 2)
+	assert.Equal(t, "handling", logged[0]["msg"])
+	assert.Equal(t, id, logged[0]["request_id"])
+
+	access := logged[1]
+	assert.Equal(t, "request", access["msg"])
+	assert.Equal(t, id, access["request_id"])
+	assert.Equal(t, "GET", access["method"])
+	assert.Equal(t, "/post/foo", access["path"])
+	assert.Equal(t, float64(http.StatusTeapot), access["status"])
+	assert.Equal(t, float64(len("short and stout")), access["bytes"])
+	assert.Contains(t, access, "latency")
+	assert.NotContains(t, buf.String(), "secret")
+}
+
+func TestMiddlewareRequestIDFromProxy(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, slog.LevelInfo, "json")
+	require.NoError(t, err)
+
+	var seen string
+	handler := logging.Middleware(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestID(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(logging.RequestIDHeader, "edge-1234")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, "edge-1234", seen)
+	assert.Equal(t, "edge-1234", rec.Header().Get(logging.RequestIDHeader))
+
+	// IDs which could forge log lines are replaced
+	req.Header.Set(logging.RequestIDHeader, "bad\nid")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.NotEqual(t, "bad\nid", seen)
+	assert.Equal(t, seen, rec.Header().Get(logging.RequestIDHeader))
+}
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, slog.LevelWarn, "text")
+	require.NoError(t, err)
+	logger.Info("hidden")
+	logger.Warn("shown")
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "msg=shown")
+
+	_, err = logging.New(&buf, slog.LevelInfo, "xml")
+	assert.EqualError(t, err, `unknown log format "xml", use json or text`)
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := logging.ParseLevel("debug")
+	require.NoError(t, err)
+	assert.Equal(t, slog.LevelDebug, level)
+
+	_, err = logging.ParseLevel("loud")
+	assert.EqualError(t, err, `unknown log level "loud", use debug, info, warn or error`)
+}
+
+func TestNewTraceID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, slog.LevelInfo, "json")
+	require.NoError(t, err)
+
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x4b, 0xf9},
+		SpanID:  trace.SpanID{0x01},
+	}))
+	logger.InfoContext(ctx, "traced")
+	logger.Info("untraced")
+
+	got := records(t, &buf)
+	require.Len(t, got, 2)
+	assert.Equal(t, "4bf90000000000000000000000000000", got[0]["trace_id"])
+	assert.Equal(t, "0100000000000000", got[0]["span_id"])
+	assert.NotContains(t, got[1], "trace_id")
+}
@@ -0,0 +1,130 @@
+package media
+
+import (
+	"fmt"
+	"log/slog"
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Library wraps a MediaStore with the image pipeline: uploads have their
+// metadata stripped and images get resized variants.
+type Library struct {
+	repository.MediaStore
+
+	// generating holds a lock for each image whose variants are being
+	// generated, so each is only resized once without holding up the others.
+	mu         sync.Mutex
+	generating map[string]*variantsLock
+}
+
+type variantsLock struct {
+	sync.Mutex
+	waiting int
+}
+
+func New(store repository.MediaStore) *Library {
+	return &Library{MediaStore: store}
+}
+
+// Upload stores media with its metadata stripped and generates variants
+// when it is an image. Failing to generate variants is logged rather than
+// returned, they are tried again when the image is next rendered.
+func (l *Library) Upload(media *models.Media, data []byte) (*models.Media, error) {
+	data, err := StripMetadata(data, media.ContentType)
+	if err != nil {
+		return nil, err
+	}
+
+	media, err = l.Create(media, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := l.Variants(media.Hash); err != nil {
+		slog.Error("Error generating variants", "hash", media.Hash, "err", err)
+	}
+	return media, nil
+}
+
+// Variants returns the resized variants of an image, generating them on
+// first use for media uploaded before variants existed.
+func (l *Library) Variants(hash string) ([]*models.Media, error) {
+	variants, err := l.MediaStore.Variants(hash)
+	if err != nil || len(variants) > 0 {
+		return variants, err
+	}
+
+	unlock := l.lock(hash)
+	defer unlock()
+
+	// generated while waiting for the lock
+	variants, err = l.MediaStore.Variants(hash)
+	if err != nil || len(variants) > 0 {
+		return variants, err
+	}
+
+	original, data, err := l.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !resizable[original.ContentType] {
+		return nil, nil
+	}
+
+	resized, err := resize(data)
+	if err != nil {
+		return nil, fmt.Errorf("resizing %s: %w", hash, err)
+	}
+
+	base := strings.TrimSuffix(original.Filename, path.Ext(original.Filename))
+	for _, v := range resized {
+		ext := ".jpg"
+		if v.contentType == "image/webp" {
+			ext = ".webp"
+		}
+
+		created, err := l.Create(&models.Media{
+			Filename:    fmt.Sprintf("%s-%dw%s", base, v.width, ext),
+			ContentType: v.contentType,
+			CreatedAt:   original.CreatedAt,
+			Original:    original.Hash,
+			Width:       v.width,
+			Height:      v.height,
+		}, v.data)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, created)
+	}
+	return variants, nil
+}
+
+// lock locks the variants of the image with hash, returning the function
+// which unlocks them.
+func (l *Library) lock(hash string) func() {
+	l.mu.Lock()
+	if l.generating == nil {
+		l.generating = map[string]*variantsLock{}
+	}
+	lock := l.generating[hash]
+	if lock == nil {
+		lock = &variantsLock{}
+		l.generating[hash] = lock
+	}
+	lock.waiting++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		if lock.waiting--; lock.waiting == 0 {
+			delete(l.generating, hash)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,150 @@
+package media_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"microblog/pkg/media"
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadGeneratesVariants(t *testing.T) {
+	library := newLibrary(t)
+
+	uploaded, err := library.Upload(&models.Media{Filename: "photo.png", ContentType: "image/png", CreatedAt: time.Now().UTC()}, testPNG(t, 1000, 500))
+	require.NoError(t, err)
+
+	variants, err := library.Variants(uploaded.Hash)
+	require.NoError(t, err)
+
+	widths := map[string][]int{}
+	for _, v := range variants {
+		assert.Equal(t, uploaded.Hash, v.Original)
+		assert.Equal(t, v.Width/2, v.Height)
+		widths[v.ContentType] = append(widths[v.ContentType], v.Width)
+	}
+	// never scaled up past the original width
+	assert.ElementsMatch(t, []int{400, 800, 1000}, widths["image/jpeg"])
+	assert.ElementsMatch(t, []int{400, 800, 1000}, widths["image/webp"])
+
+	listed, err := library.List()
+	require.NoError(t, err)
+	assert.Len(t, listed, 1)
+}
+
+func TestVariantsGeneratedOnFirstRequest(t *testing.T) {
+	store, err := repository.NewFileMediaStore(t.TempDir())
+	require.NoError(t, err)
+
+	// stored directly, as media uploaded before variants existed was
+	original, err := store.Create(&models.Media{Filename: "old.png", ContentType: "image/png"}, testPNG(t, 300, 300))
+	require.NoError(t, err)
+
+	library := media.New(store)
+	variants, err := library.Variants(original.Hash)
+	require.NoError(t, err)
+	require.Len(t, variants, 2)
+	assert.Equal(t, "old-300w.jpg", variants[0].Filename)
+	assert.Equal(t, "old-300w.webp", variants[1].Filename)
+
+	again, err := library.Variants(original.Hash)
+	require.NoError(t, err)
+	assert.Len(t, again, 2)
+}
+
+func TestNoVariantsForGIF(t *testing.T) {
+	library := newLibrary(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White}), nil))
+
+	uploaded, err := library.Upload(&models.Media{Filename: "a.gif", ContentType: "image/gif"}, buf.Bytes())
+	require.NoError(t, err)
+
+	variants, err := library.Variants(uploaded.Hash)
+	require.NoError(t, err)
+	assert.Empty(t, variants)
+}
+
+// slowStore holds up reading the original with hash until release is
+// closed, counting the reads.
+type slowStore struct {
+	repository.MediaStore
+	hash    string
+	release chan struct{}
+	gets    atomic.Int32
+}
+
+func (s *slowStore) Get(hash string) (*models.Media, []byte, error) {
+	if hash == s.hash {
+		s.gets.Add(1)
+		<-s.release
+	}
+	return s.MediaStore.Get(hash)
+}
+
+func TestVariantsOfOneImageDoNotHoldUpOthers(t *testing.T) {
+	files, err := repository.NewFileMediaStore(t.TempDir())
+	require.NoError(t, err)
+	slow, err := files.Create(&models.Media{Filename: "slow.png", ContentType: "image/png"}, testPNG(t, 300, 300))
+	require.NoError(t, err)
+	fast, err := files.Create(&models.Media{Filename: "fast.png", ContentType: "image/png"}, testPNG(t, 200, 200))
+	require.NoError(t, err)
+
+	store := &slowStore{MediaStore: files, hash: slow.Hash, release: make(chan struct{})}
+	library := media.New(store)
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			variants, err := library.Variants(slow.Hash)
+			assert.NoError(t, err)
+			assert.Len(t, variants, 2)
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		variants, err := library.Variants(fast.Hash)
+		assert.NoError(t, err)
+		assert.Len(t, variants, 2)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("variants of another image were held up")
+	}
+
+	close(store.release)
+	wg.Wait()
+	assert.Equal(t, int32(1), store.gets.Load(), "the image is only resized once")
+}
+
+func newLibrary(t *testing.T) *media.Library {
+	t.Helper()
+
+	store, err := repository.NewFileMediaStore(t.TempDir())
+	require.NoError(t, err)
+	return media.New(store)
+}
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))))
+	return buf.Bytes()
+}
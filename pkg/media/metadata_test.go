@@ -0,0 +1,144 @@
+package media_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"microblog/pkg/media"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStripMetadataJPEG(t *testing.T) {
+	src := withEXIF(t, testJPEG(t, 8, 4), 1)
+
+	got, err := media.StripMetadata(src, "image/jpeg")
+	require.NoError(t, err)
+
+	assert.NotContains(t, string(got), "Exif")
+	assert.NotContains(t, string(got), "GPSSECRET")
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(got))
+	require.NoError(t, err)
+	assert.Equal(t, 8, cfg.Width)
+}
+
+func TestStripMetadataJPEGAppliesOrientation(t *testing.T) {
+	// orientation 6 is stored sideways and displayed rotated 90° clockwise
+	src := withEXIF(t, testJPEG(t, 8, 4), 6)
+
+	got, err := media.StripMetadata(src, "image/jpeg")
+	require.NoError(t, err)
+
+	assert.NotContains(t, string(got), "Exif")
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(got))
+	require.NoError(t, err)
+	assert.Equal(t, 4, cfg.Width)
+	assert.Equal(t, 8, cfg.Height)
+}
+
+func TestStripMetadataPNG(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2))))
+	src := buf.Bytes()
+
+	// insert a tEXt chunk after IHDR
+	text := pngChunk("tEXt", []byte("Comment\x00GPSSECRET"))
+	src = append(append(append([]byte{}, src[:33]...), text...), src[33:]...)
+
+	got, err := media.StripMetadata(src, "image/png")
+	require.NoError(t, err)
+
+	assert.NotContains(t, string(got), "GPSSECRET")
+	_, err = png.Decode(bytes.NewReader(got))
+	require.NoError(t, err)
+}
+
+func TestStripMetadataWebP(t *testing.T) {
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x08 | 0x04
+	src := riff(
+		riffChunk("VP8X", vp8x),
+		riffChunk("VP8L", []byte{1, 2, 3}),
+		riffChunk("EXIF", []byte("GPSSECRET")),
+		riffChunk("XMP ", []byte("<x/>")),
+	)
+
+	got, err := media.StripMetadata(src, "image/webp")
+	require.NoError(t, err)
+
+	assert.Equal(t, riff(riffChunk("VP8X", make([]byte, 10)), riffChunk("VP8L", []byte{1, 2, 3})), got)
+}
+
+func TestStripMetadataInvalidImageError(t *testing.T) {
+	for _, contentType := range []string{"image/jpeg", "image/png", "image/webp"} {
+		_, err := media.StripMetadata([]byte("not an image"), contentType)
+		assert.ErrorIs(t, err, media.ErrInvalidImage, contentType)
+	}
+}
+
+func testJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 30), uint8(y * 60), 0, 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+	return buf.Bytes()
+}
+
+// withEXIF inserts an APP1 segment holding an orientation tag and some
+// stand-in GPS data straight after the start of image marker.
+func withEXIF(t *testing.T, jpg []byte, orientation uint16) []byte {
+	t.Helper()
+
+	tiff := []byte("II*\x00")
+	tiff = binary.LittleEndian.AppendUint32(tiff, 8)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	tiff = append(tiff, "GPSSECRET"...)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xff, 0xe1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	return append(append(append([]byte{}, jpg[:2]...), segment...), jpg[2:]...)
+}
+
+func pngChunk(kind string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, kind...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func riffChunk(kind string, data []byte) []byte {
+	chunk := binary.LittleEndian.AppendUint32([]byte(kind), uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func riff(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	return append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body))), body...)
+}
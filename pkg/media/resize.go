@@ -0,0 +1,95 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// VariantWidths are the widths responsive variants are generated at. Images
+// narrower than a width are not scaled up; the original width is used as the
+// largest variant instead.
+var VariantWidths = []int{400, 800, 1200, 1600}
+
+// resizable are the content types variants are generated for. GIFs are left
+// alone as scaling would drop any animation.
+var resizable = map[string]bool{"image/jpeg": true, "image/png": true, "image/webp": true}
+
+// maxPixels bounds the size of images which are decoded, as a small file can
+// declare enormous dimensions.
+const maxPixels = 50_000_000
+
+var ErrImageTooLarge = errors.New("image dimensions are too large")
+
+type variant struct {
+	width, height int
+	contentType   string
+	data          []byte
+}
+
+// resize scales an image to each variant width, encoding every size as both
+// JPEG and WebP.
+func resize(data []byte) ([]variant, error) {
+	src, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+
+	var widths []int
+	for _, w := range VariantWidths {
+		if w < b.Dx() {
+			widths = append(widths, w)
+		}
+	}
+	if largest := min(b.Dx(), VariantWidths[len(VariantWidths)-1]); len(widths) == 0 || widths[len(widths)-1] != largest {
+		widths = append(widths, largest)
+	}
+
+	var variants []variant
+	for _, w := range widths {
+		h := max(1, b.Dy()*w/b.Dx())
+		scaled := image.NewNRGBA(image.Rect(0, 0, w, h))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), src, b, draw.Src, nil)
+
+		// JPEG has no alpha, so transparency is flattened onto white
+		opaque := image.NewRGBA(scaled.Bounds())
+		draw.Draw(opaque, opaque.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(opaque, opaque.Bounds(), scaled, image.Point{}, draw.Over)
+
+		var jpg bytes.Buffer
+		if err := jpeg.Encode(&jpg, opaque, &jpeg.Options{Quality: 80}); err != nil {
+			return nil, err
+		}
+		var webp bytes.Buffer
+		if err := nativewebp.Encode(&webp, scaled, nil); err != nil {
+			return nil, err
+		}
+
+		variants = append(variants,
+			variant{width: w, height: h, contentType: "image/jpeg", data: jpg.Bytes()},
+			variant{width: w, height: h, contentType: "image/webp", data: webp.Bytes()},
+		)
+	}
+	return variants, nil
+}
+
+func decode(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
@@ -0,0 +1,114 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"microblog/pkg/cache"
+	"microblog/pkg/metrics"
+	"microblog/pkg/models"
+	"microblog/pkg/render"
+	"microblog/pkg/repository"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMiddleware(t *testing.T) {
+	m := metrics.New()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/post/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("name") == "missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("post"))
+	})
+	handler := m.Middleware(mux)
+
+	for _, path := range []string{"/post/first", "/post/second", "/post/missing"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	out := scrape(t, m)
+	assert.Contains(t, out, `microblog_http_requests_total{code="200",method="GET",route="/post/{name}"} 2`)
+	assert.Contains(t, out, `microblog_http_requests_total{code="404",method="GET",route="/post/{name}"} 1`)
+	assert.Contains(t, out, `microblog_http_request_duration_seconds_count{method="GET",route="/post/{name}"} 3`)
+	assert.Contains(t, out, "go_goroutines")
+}
+
+type failingStore struct {
+	repository.MemoryPostStore
+}
+
+func (s *failingStore) GetByName(ctx context.Context, name string) (*models.BlogPost, error) {
+	return nil, errors.New("connection reset")
+}
+
+func TestInstrumentPostStore(t *testing.T) {
+	m := metrics.New()
+	store := m.InstrumentPostStore(&failingStore{})
+
+	require.NoError(t, store.Create(context.Background(), &models.BlogPost{ID: uuid.New()}))
+	_, err := store.GetByName(context.Background(), "foo")
+	require.Error(t, err)
+
+	out := scrape(t, m)
+	assert.Contains(t, out, `microblog_post_store_operation_duration_seconds_count{operation="create"} 1`)
+	assert.Contains(t, out, `microblog_post_store_operation_duration_seconds_count{operation="get_by_name"} 1`)
+	assert.Contains(t, out, `microblog_post_store_operation_errors_total{operation="get_by_name"} 1`)
+	assert.NotContains(t, out, `microblog_post_store_operation_errors_total{operation="create"}`)
+
+	_, ok := store.(repository.Checker)
+	assert.False(t, ok, "a store without checks does not gain them")
+}
+
+func TestRegisterCacheAndDB(t *testing.T) {
+	m := metrics.New()
+
+	c := cache.New([]*models.BlogPost{{Name: "foo"}, {Name: "bar"}}, &sync.Mutex{})
+	c.Hit()
+	c.Hit()
+	c.Miss()
+	m.RegisterCache(c)
+
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	m.RegisterDB(db, "blog")
+
+	out := scrape(t, m)
+	assert.Contains(t, out, "microblog_cache_hits_total 2")
+	assert.Contains(t, out, "microblog_cache_misses_total 1")
+	assert.Contains(t, out, "microblog_cache_posts 2")
+	assert.Contains(t, out, `go_sql_open_connections{db_name="blog"}`)
+}
+
+func TestObserveRender(t *testing.T) {
+	m := metrics.New()
+	renderer := render.New(render.Policy{}, render.WithTimings(m.ObserveRender))
+
+	renderer.Prepare(context.Background(), &models.BlogPost{Title: "*title*", Content: "some **content**"})
+
+	out := scrape(t, m)
+	for _, stage := range []string{"content", "title", "excerpt"} {
+		assert.Contains(t, out, `microblog_markdown_render_duration_seconds_count{stage="`+stage+`"} 1`)
+	}
+}
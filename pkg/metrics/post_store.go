@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"context"
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// postStore times the operations of a PostStore and counts their errors.
+type postStore struct {
+	repository.PostStore
+	m *Metrics
+}
+
+// InstrumentPostStore wraps store so its operations are recorded. Readiness
+// checks are passed through when store has them.
+func (m *Metrics) InstrumentPostStore(store repository.PostStore) repository.PostStore {
+	s := &postStore{PostStore: store, m: m}
+	if checker, ok := store.(repository.Checker); ok {
+		return &checkedPostStore{postStore: s, Checker: checker}
+	}
+	return s
+}
+
+type checkedPostStore struct {
+	*postStore
+	repository.Checker
+}
+
+// observe takes a pointer to the named error result of the caller, so it is
+// read once the operation has returned.
+func (s *postStore) observe(operation string, start time.Time, err *error) {
+	s.m.storeDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		s.m.storeErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+func (s *postStore) Create(ctx context.Context, blogPost *models.BlogPost) (err error) {
+	defer s.observe("create", time.Now(), &err)
+	return s.PostStore.Create(ctx, blogPost)
+}
+
+func (s *postStore) GetAll(ctx context.Context) (blogPosts []*models.BlogPost, err error) {
+	defer s.observe("get_all", time.Now(), &err)
+	return s.PostStore.GetAll(ctx)
+}
+
+func (s *postStore) GetByID(ctx context.Context, id uuid.UUID) (blogPost *models.BlogPost, err error) {
+	defer s.observe("get_by_id", time.Now(), &err)
+	return s.PostStore.GetByID(ctx, id)
+}
+
+func (s *postStore) GetByName(ctx context.Context, name string) (blogPost *models.BlogPost, err error) {
+	defer s.observe("get_by_name", time.Now(), &err)
+	return s.PostStore.GetByName(ctx, name)
+}
+
+func (s *postStore) FetchLast10BlogPosts(ctx context.Context) (blogPosts []*models.BlogPost, err error) {
+	defer s.observe("fetch_last_10", time.Now(), &err)
+	return s.PostStore.FetchLast10BlogPosts(ctx)
+}
+
+func (s *postStore) GetByAuthor(ctx context.Context, authorID uuid.UUID) (blogPosts []*models.BlogPost, err error) {
+	defer s.observe("get_by_author", time.Now(), &err)
+	return s.PostStore.GetByAuthor(ctx, authorID)
+}
+
+func (s *postStore) Delete(ctx context.Context, id uuid.UUID) (err error) {
+	defer s.observe("delete", time.Now(), &err)
+	return s.PostStore.Delete(ctx, id)
+}
+
+func (s *postStore) Update(ctx context.Context, blogPost *models.BlogPost) (err error) {
+	defer s.observe("update", time.Now(), &err)
+	return s.PostStore.Update(ctx, blogPost)
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditAction is a kind of change recorded in the audit log.
+type AuditAction string
+
+const (
+	AuditPostCreate    AuditAction = "post.create"
+	AuditPostUpdate    AuditAction = "post.update"
+	AuditPostDelete    AuditAction = "post.delete"
+	AuditMediaUpload   AuditAction = "media.upload"
+	AuditCacheRebuild  AuditAction = "cache.rebuild"
+	AuditLogin         AuditAction = "login"
+	AuditLoginFailed   AuditAction = "login.failed"
+	AuditLogout        AuditAction = "logout"
+	AuditUserInvite    AuditAction = "user.invite"
+	AuditUserUpdate    AuditAction = "user.update"
+	AuditUserDelete    AuditAction = "user.delete"
+	AuditTokenCreate   AuditAction = "token.create"
+	AuditTokenRevoke   AuditAction = "token.revoke"
+	AuditTokenUse      AuditAction = "token.use"
+	AuditTwoFactorOn   AuditAction = "2fa.enable"
+	AuditTwoFactorOff  AuditAction = "2fa.disable"
+	AuditRecoveryCodes AuditAction = "2fa.recovery_codes"
+)
+
+// AuditActions lists every action, to filter the audit log by.
+var AuditActions = []AuditAction{
+	AuditPostCreate, AuditPostUpdate, AuditPostDelete, AuditMediaUpload, AuditCacheRebuild,
+	AuditLogin, AuditLoginFailed, AuditLogout,
+	AuditUserInvite, AuditUserUpdate, AuditUserDelete,
+	AuditTokenCreate, AuditTokenRevoke, AuditTokenUse,
+	AuditTwoFactorOn, AuditTwoFactorOff, AuditRecoveryCodes,
+}
+
+// AuditEntry records who did what to which target, and how it looked
+// before and after. Entries are never changed once written.
+type AuditEntry struct {
+	ID   int64
+	Time time.Time
+	// ActorID is nil for actions without a signed in user, such as failed
+	// sign ins. ActorHandle is kept as it was, so entries outlive the user.
+	ActorID     uuid.UUID
+	ActorHandle string
+	// Via is how the actor signed in: session, basic or the name of an API
+	// token as token:<name>.
+	Via    string
+	Action AuditAction
+	// Target names what was acted on, such as post:<name> or user:<handle>.
+	Target    string
+	IP        string
+	RequestID string
+	// Before and After summarise the target either side of the change.
+	Before string
+	After  string
+}
+
+// AuditFilter narrows down the audit log. Zero fields match everything.
+type AuditFilter struct {
+	ActorHandle string
+	Action      AuditAction
+	Target      string
+	Since       time.Time
+	Until       time.Time
+	// BeforeID pages through the log, returning entries older than it.
+	BeforeID int64
+	Limit    int
+}
+
+// Matches reports whether entry passes the filter, leaving out the limit.
+func (f AuditFilter) Matches(entry *AuditEntry) bool {
+	return (f.ActorHandle == "" || entry.ActorHandle == f.ActorHandle) &&
+		(f.Action == "" || entry.Action == f.Action) &&
+		(f.Target == "" || entry.Target == f.Target) &&
+		(f.Since.IsZero() || !entry.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || entry.Time.Before(f.Until)) &&
+		(f.BeforeID == 0 || entry.ID < f.BeforeID)
+}
@@ -1,23 +1,87 @@
 package models
 
 import (
+	"html/template"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type BlogPost struct {
-	ID            uuid.UUID
-	Title         string
-	TitleNonHTML  string
-	Content       string
+	ID      uuid.UUID
+	Title   string
+	Content string
+	// Summary is optional markdown shown on listings in place of an
+	// automatically generated excerpt.
+	Summary string
+	// CoverImage is an optional image URL shown above the post and used
+	// when the post is shared. Description is an optional plain text
+	// summary for search results and link previews.
+	CoverImage    string
+	Description   string
 	Name          string
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	FormattedDate string
+
+	// AuthorID is the user who wrote the post, or uuid.Nil for posts from
+	// before there were users. AuthorHandle and AuthorName are read along
+	// with the post.
+	AuthorID     uuid.UUID
+	AuthorHandle string
+	AuthorName   string
+
+	// Rendered, sanitised output of Title and Content. These are produced by
+	// the render package and may be persisted alongside the markdown source.
+	TitleHTML   template.HTML
+	ContentHTML template.HTML
+	Excerpt     template.HTML
+	// RenderVersion identifies the renderer and the policy which produced the
+	// HTML, which is rendered again by any other.
+	RenderVersion string
+
+	// WordCount and ReadingTime (in minutes) are derived from ContentHTML
+	// whenever a post is rendered.
+	WordCount   int
+	ReadingTime int
 }
 
 func NewBlogPost() *BlogPost {
 	blogpost := &BlogPost{}
 	return blogpost
 }
+
+// Media is an uploaded file. Content is stored once and addressed by the
+// hex encoded SHA-256 hash of its bytes.
+type Media struct {
+	Hash        string
+	Filename    string
+	ContentType string
+	Size        int64
+	CreatedAt   time.Time
+
+	// Width and Height are set on resized variants of an image, which
+	// reference the hash of the uploaded image as Original.
+	Original string
+	Width    int
+	Height   int
+}
+
+// URL is the public path the media is served from.
+func (m *Media) URL() string {
+	return "/media/" + m.Hash + "/" + url.PathEscape(m.Filename)
+}
+
+func (m *Media) IsImage() bool {
+	return strings.HasPrefix(m.ContentType, "image/")
+}
+
+// Markdown links to the media, embedding it when it is an image.
+func (m *Media) Markdown() string {
+	if m.IsImage() {
+		return "![" + m.Filename + "](" + m.URL() + ")"
+	}
+	return "[" + m.Filename + "](" + m.URL() + ")"
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scope limits what an API token may do on behalf of its user.
+type Scope string
+
+const (
+	ScopePostsRead  Scope = "posts:read"
+	ScopePostsWrite Scope = "posts:write"
+	ScopeMediaWrite Scope = "media:write"
+	// ScopeAdmin covers every other scope as well as rebuilding the cache,
+	// managing users and reading the audit log.
+	ScopeAdmin Scope = "admin"
+)
+
+// Scopes lists every scope.
+var Scopes = []Scope{ScopePostsRead, ScopePostsWrite, ScopeMediaWrite, ScopeAdmin}
+
+// permissionScopes is the scope a token needs for each permission.
+// Permissions without one, such as managing tokens, cannot be used with a
+// token at all.
+var permissionScopes = map[Permission]Scope{
+	PermViewAdmin:    ScopePostsRead,
+	PermWritePosts:   ScopePostsWrite,
+	PermEditAllPosts: ScopePostsWrite,
+	PermUploadMedia:  ScopeMediaWrite,
+	PermRebuildCache: ScopeAdmin,
+	PermManageUsers:  ScopeAdmin,
+	PermViewAudit:    ScopeAdmin,
+}
+
+func (s Scope) Valid() bool {
+	for _, scope := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// covers reports whether a token with scope s may act with scope other.
+func (s Scope) covers(other Scope) bool {
+	return s == other || s == ScopeAdmin || (s == ScopePostsWrite && other == ScopePostsRead)
+}
+
+// APIToken lets scripts call the API as a user, limited to the token's
+// scopes. The token itself is only shown when it is created, the server
+// keeps a hash of it.
+type APIToken struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	// Name says what the token is used for.
+	Name   string
+	Hash   string `json:"-"`
+	Scopes []Scope
+	// ExpiresAt is zero for tokens which do not expire.
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+	CreatedAt  time.Time
+}
+
+func (t *APIToken) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
+}
+
+// Allows reports whether the token's scopes cover permission. The user of
+// the token needs the permission as well.
+func (t *APIToken) Allows(p Permission) bool {
+	needed, ok := permissionScopes[p]
+	if !ok {
+		return false
+	}
+	for _, scope := range t.Scopes {
+		if scope.covers(needed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Role decides what a user may do in the admin area.
+type Role string
+
+const (
+	// RoleAdmin can do everything, including managing users.
+	RoleAdmin Role = "admin"
+	// RoleEditor can publish, edit and delete any post.
+	RoleEditor Role = "editor"
+	// RoleAuthor can publish posts and edit or delete their own.
+	RoleAuthor Role = "author"
+	// RoleViewer can browse the admin area without changing anything.
+	RoleViewer Role = "viewer"
+)
+
+// Roles lists every role, most privileged first.
+var Roles = []Role{RoleAdmin, RoleEditor, RoleAuthor, RoleViewer}
+
+// Permission is an action guarded by a role.
+type Permission string
+
+const (
+	PermViewAdmin    Permission = "admin:view"
+	PermWritePosts   Permission = "posts:write"
+	PermEditAllPosts Permission = "posts:edit_all"
+	// PermEmbed is embedding iframes from the configured hosts in posts.
+	PermEmbed        Permission = "posts:embed"
+	PermUploadMedia  Permission = "media:write"
+	PermRebuildCache Permission = "cache:rebuild"
+	PermManageUsers  Permission = "users:manage"
+	// PermManageTokens is creating and revoking one's own API tokens.
+	PermManageTokens Permission = "tokens:manage"
+	// PermManageTwoFactor is enrolling in two-factor authentication. It is
+	// allowed before enrolling when a role requires it.
+	PermManageTwoFactor Permission = "2fa:manage"
+	// PermViewAudit is reading and exporting the audit log.
+	PermViewAudit Permission = "audit:view"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin:  {PermViewAdmin, PermWritePosts, PermEditAllPosts, PermEmbed, PermUploadMedia, PermRebuildCache, PermManageUsers, PermManageTokens, PermManageTwoFactor, PermViewAudit},
+	RoleEditor: {PermViewAdmin, PermWritePosts, PermEditAllPosts, PermEmbed, PermUploadMedia, PermRebuildCache, PermManageTokens, PermManageTwoFactor},
+	RoleAuthor: {PermViewAdmin, PermWritePosts, PermUploadMedia, PermManageTokens, PermManageTwoFactor},
+	RoleViewer: {PermViewAdmin, PermManageTokens, PermManageTwoFactor},
+}
+
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+func (r Role) Can(p Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// User is an account which can sign in to the admin area. A user who has
+// been invited but has not yet chosen a password has an InviteHash instead
+// of a PasswordHash, and one who signs in with single sign-on has an
+// OIDCSubject instead.
+type User struct {
+	ID uuid.UUID
+	// Handle is the unique name used to sign in and in author page URLs.
+	Handle string
+	// Name is shown as the author of the user's posts.
+	Name         string
+	PasswordHash string `json:"-"`
+	Role         Role
+	Disabled     bool
+
+	// InviteHash is the hex encoded SHA-256 hash of the token in the invite
+	// link, which stops working at InviteExpires.
+	InviteHash    string `json:"-"`
+	InviteExpires time.Time
+
+	// TOTPSecret is set once the user has enrolled an authenticator app,
+	// whose codes are then asked for after the password. TOTPLastStep is
+	// the period of the last code used, so codes cannot be replayed.
+	TOTPSecret   string `json:"-"`
+	TOTPLastStep int64  `json:"-"`
+	// RecoveryCodes are hashes of the unused codes which can stand in for
+	// the authenticator.
+	RecoveryCodes []string `json:"-"`
+
+	// OIDCSubject is the ID of the user at the OpenID Connect provider, for
+	// users created by signing in with it.
+	OIDCSubject string `json:"-"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Active reports whether the user may sign in.
+func (u *User) Active() bool {
+	return !u.Disabled && (u.PasswordHash != "" || u.OIDCSubject != "")
+}
+
+func (u *User) TwoFactorEnabled() bool {
+	return u.TOTPSecret != ""
+}
+
+func (u *User) Can(p Permission) bool {
+	return u.Active() && u.Role.Can(p)
+}
+
+// CanEdit reports whether the user may edit or delete blogPost.
+func (u *User) CanEdit(blogPost *BlogPost) bool {
+	if u.Can(PermEditAllPosts) {
+		return true
+	}
+	return u.Can(PermWritePosts) && blogPost.AuthorID == u.ID
+}
+
+// DisplayName is the name to credit the user with, falling back to their
+// handle.
+func (u *User) DisplayName() string {
+	if u.Name != "" {
+		return u.Name
+	}
+	return u.Handle
+}
+
+// Session is a signed in browser. The session ID itself is only held in
+// the browser's cookie, the server keeps a hash of it.
+type Session struct {
+	IDHash string
+	UserID uuid.UUID
+	// CSRFToken has to be sent with every state-changing request made with
+	// the session.
+	CSRFToken string
+	// Pending sessions have passed the password but not yet the second
+	// factor, which has been tried Attempts times.
+	Pending   bool
+	Attempts  int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+func (s *Session) Expired(now time.Time) bool {
+	return !now.Before(s.ExpiresAt)
+}
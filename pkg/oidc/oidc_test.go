@@ -0,0 +1,154 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/base64"
+	"microblog/pkg/oidc"
+	"microblog/pkg/oidc/oidctest"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURL = "https://blog.example.com/login/oidc/callback"
+
+func newProvider(t *testing.T) (*oidc.Provider, *oidctest.Provider) {
+	idp := oidctest.New("microblog", "s3cret")
+	t.Cleanup(idp.Close)
+	idp.Claims["sub"] = "user-1"
+	idp.Claims["email"] = "jane@example.com"
+	idp.Claims["groups"] = []string{"writers", "staff"}
+	return oidc.New(idp.URL, "microblog", "s3cret", redirectURL, []string{"email", "profile"}), idp
+}
+
+// signIn goes through the provider's authorization endpoint and returns
+// the code it sends back, checking the state on the way.
+func signIn(t *testing.T, p *oidc.Provider, nonce, verifier string) string {
+	authURL, err := p.AuthCodeURL(context.Background(), "state-1", nonce, verifier)
+	require.NoError(t, err)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	back, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, redirectURL, back.Scheme+"://"+back.Host+back.Path)
+	assert.Equal(t, "state-1", back.Query().Get("state"))
+	return back.Query().Get("code")
+}
+
+func TestExchange(t *testing.T) {
+	p, idp := newProvider(t)
+	verifier, err := oidc.NewVerifier()
+	require.NoError(t, err)
+
+	code := signIn(t, p, "nonce-1", verifier)
+	claims, err := p.Exchange(context.Background(), code, verifier, "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims.Subject)
+	assert.Equal(t, "jane@example.com", claims.Email)
+	assert.Equal(t, []string{"writers", "staff"}, claims.Strings("groups"))
+	assert.Nil(t, claims.Strings("roles"))
+
+	// codes are used once
+	_, err = p.Exchange(context.Background(), code, verifier, "nonce-1")
+	assert.Error(t, err)
+
+	// a second sign in uses the cached discovery document and keys
+	code = signIn(t, p, "nonce-2", verifier)
+	_, err = p.Exchange(context.Background(), code, verifier, "nonce-2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, idp.RequestCount("/.well-known/openid-configuration"))
+	assert.Equal(t, 1, idp.RequestCount("/jwks"))
+}
+
+func TestExchangeRefused(t *testing.T) {
+	p, _ := newProvider(t)
+	verifier, err := oidc.NewVerifier()
+	require.NoError(t, err)
+
+	other, err := oidc.NewVerifier()
+	require.NoError(t, err)
+	code := signIn(t, p, "nonce-1", verifier)
+	_, err = p.Exchange(context.Background(), code, other, "nonce-1")
+	assert.ErrorContains(t, err, "invalid_grant")
+
+	code = signIn(t, p, "nonce-1", verifier)
+	_, err = p.Exchange(context.Background(), code, verifier, "nonce-2")
+	assert.ErrorContains(t, err, "nonce")
+
+	p.ClientSecret = "wrong"
+	code = signIn(t, p, "nonce-1", verifier)
+	_, err = p.Exchange(context.Background(), code, verifier, "nonce-1")
+	assert.ErrorContains(t, err, "invalid_client")
+}
+
+func TestVerify(t *testing.T) {
+	p, idp := newProvider(t)
+	key, kid := idp.Key()
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{
+			"iss":   idp.URL,
+			"aud":   "microblog",
+			"sub":   "user-1",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": "nonce-1",
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	_, err := p.Verify(context.Background(), oidctest.Sign(key, kid, claims(nil)), "nonce-1")
+	require.NoError(t, err)
+
+	tests := map[string]map[string]any{
+		"issuer":   {"iss": "https://evil.example.com"},
+		"audience": {"aud": []string{"other"}},
+		"expired":  {"exp": time.Now().Add(-time.Hour).Unix()},
+		"nonce":    {"nonce": "nonce-2"},
+		"subject":  {"sub": ""},
+	}
+	for name, overrides := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := p.Verify(context.Background(), oidctest.Sign(key, kid, claims(overrides)), "nonce-1")
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("unsigned", func(t *testing.T) {
+		parts := strings.Split(oidctest.Sign(key, kid, claims(nil)), ".")
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"` + kid + `"}`))
+		_, err := p.Verify(context.Background(), header+"."+parts[1]+".", "nonce-1")
+		assert.Error(t, err)
+	})
+}
+
+func TestKeyRotation(t *testing.T) {
+	p, idp := newProvider(t)
+	now := time.Now()
+	p.Now = func() time.Time { return now }
+	verifier, err := oidc.NewVerifier()
+	require.NoError(t, err)
+
+	_, err = p.Exchange(context.Background(), signIn(t, p, "n", verifier), verifier, "n")
+	require.NoError(t, err)
+
+	// the new key is fetched once the keys are a minute old
+	idp.RotateKey()
+	_, err = p.Exchange(context.Background(), signIn(t, p, "n", verifier), verifier, "n")
+	assert.ErrorContains(t, err, "unknown ID token key")
+	now = now.Add(2 * time.Minute)
+	_, err = p.Exchange(context.Background(), signIn(t, p, "n", verifier), verifier, "n")
+	require.NoError(t, err)
+	assert.Equal(t, 2, idp.RequestCount("/jwks"))
+}
@@ -0,0 +1,278 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Claims are the claims of a verified ID token.
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	raw               map[string]any
+}
+
+// Strings returns the claim named name as a list of strings, such as the
+// groups of the user. A claim holding a single string is returned as a list
+// of one.
+func (c *Claims) Strings(name string) []string {
+	switch v := c.raw[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var values []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the signature of the ID token idToken against the keys of
+// the provider, that it was issued by the provider for this client and has
+// not expired, and that it carries nonce.
+func (p *Provider) Verify(ctx context.Context, idToken, nonce string) (*Claims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: malformed ID token")
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("oidc: ID token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("oidc: ID token signature: %w", err)
+	}
+	key, err := p.key(ctx, h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(h.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, fmt.Errorf("oidc: ID token claims: %w", err)
+	}
+	var c struct {
+		Issuer            string          `json:"iss"`
+		Subject           string          `json:"sub"`
+		Audience          audience        `json:"aud"`
+		AuthorizedParty   string          `json:"azp"`
+		Expiry            json.Number     `json:"exp"`
+		Nonce             string          `json:"nonce"`
+		Email             string          `json:"email"`
+		EmailVerified     json.RawMessage `json:"email_verified"`
+		Name              string          `json:"name"`
+		PreferredUsername string          `json:"preferred_username"`
+	}
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, fmt.Errorf("oidc: ID token claims: %w", err)
+	}
+
+	if strings.TrimSuffix(c.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("oidc: ID token issued by %q", c.Issuer)
+	}
+	if !c.Audience.contains(p.ClientID) {
+		return nil, errors.New("oidc: ID token not issued for this client")
+	}
+	if len(c.Audience) > 1 && c.AuthorizedParty != p.ClientID {
+		return nil, errors.New("oidc: ID token authorized for another client")
+	}
+	exp, err := c.Expiry.Float64()
+	if err != nil {
+		return nil, errors.New("oidc: ID token has no expiry")
+	}
+	if p.Now().Add(-clockSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("oidc: ID token expired")
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("oidc: ID token nonce does not match")
+	}
+	if c.Subject == "" {
+		return nil, errors.New("oidc: ID token has no subject")
+	}
+
+	return &Claims{
+		Subject: c.Subject,
+		Email:   c.Email,
+		// some providers send the flag as a string
+		EmailVerified:     string(c.EmailVerified) == "true" || string(c.EmailVerified) == `"true"`,
+		Name:              c.Name,
+		PreferredUsername: c.PreferredUsername,
+		raw:               raw,
+	}, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// audience is the aud claim, which is either a string or a list of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*a = l
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	return slices.Contains(a, clientID)
+}
+
+func verifySignature(alg string, key any, signed string, signature []byte) error {
+	sum := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("oidc: ID token key is not an RSA key")
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], signature); err != nil {
+			return errors.New("oidc: invalid ID token signature")
+		}
+	case "ES256":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return errors.New("oidc: ID token key is not an EC key")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(k, sum[:], r, s) {
+			return errors.New("oidc: invalid ID token signature")
+		}
+	default:
+		// none and the HMAC algorithms are refused along with everything
+		// else
+		return fmt.Errorf("oidc: unsupported ID token algorithm %q", alg)
+	}
+	return nil
+}
+
+// key returns the signing key with the ID kid. The keys are fetched again
+// when they are old, or when kid is unknown and they were not just fetched.
+func (p *Provider) key(ctx context.Context, kid string) (any, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	age := p.Now().Sub(p.keysAt)
+	key, ok := p.keys[kid]
+	if p.keys == nil || age >= cacheTTL || (!ok && age >= refreshInterval) {
+		keys, err := p.fetchKeys(ctx, d.JWKSURI)
+		if err != nil {
+			return nil, err
+		}
+		p.keys = keys
+		p.keysAt = p.Now()
+		key, ok = keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("oidc: unknown ID token key %q", kid)
+	}
+	return key, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (p *Provider) fetchKeys(ctx context.Context, uri string) (map[string]any, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, uri, &set); err != nil {
+		return nil, fmt.Errorf("oidc: keys: %w", err)
+	}
+
+	keys := map[string]any{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err := base64.RawURLEncoding.DecodeString(k.N)
+			if err != nil {
+				continue
+			}
+			e, err := base64.RawURLEncoding.DecodeString(k.E)
+			if err != nil || len(e) > 4 {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// forgetAfter is how long failures are remembered after the last one, so
+// locks keep growing for clients who come back once they end.
+const forgetAfter = 24 * time.Hour
+
+type failures struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+// Lockout locks keys, such as a handle or a client IP, after Threshold
+// failures in a row. The first lock lasts Base and every further failure
+// doubles it, up to Max. A nil Lockout never locks.
+type Lockout struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+	Now       func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*failures
+	swept   time.Time
+}
+
+func NewLockout(threshold int, base, max time.Duration) *Lockout {
+	return &Lockout{Threshold: threshold, Base: base, Max: max, Now: time.Now, entries: map[string]*failures{}}
+}
+
+// Locked returns how much longer key is locked for, or zero.
+func (l *Lockout) Locked(key string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	now := l.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if f, ok := l.entries[key]; ok && now.Before(f.until) {
+		return f.until.Sub(now)
+	}
+	return 0
+}
+
+// Fail counts a failure against key and returns how long key is now locked
+// for, or zero while it is under the threshold.
+func (l *Lockout) Fail(key string) time.Duration {
+	if l == nil || l.Threshold <= 0 {
+		return 0
+	}
+	now := l.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) >= sweepInterval {
+		for k, f := range l.entries {
+			if now.Sub(f.last) >= forgetAfter {
+				delete(l.entries, k)
+			}
+		}
+		l.swept = now
+	}
+
+	f, ok := l.entries[key]
+	if !ok || now.Sub(f.last) >= forgetAfter {
+		f = &failures{}
+		l.entries[key] = f
+	}
+	f.count++
+	f.last = now
+	if f.count < l.Threshold {
+		return 0
+	}
+	lock := l.Base
+	for i := l.Threshold; i < f.count && lock < l.Max; i++ {
+		lock *= 2
+	}
+	lock = min(lock, l.Max)
+	f.until = now.Add(lock)
+	return lock
+}
+
+// Reset forgets the failures of key, once it has succeeded.
+func (l *Lockout) Reset(key string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
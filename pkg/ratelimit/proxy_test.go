@@ -0,0 +1,48 @@
+package ratelimit_test
+
+import (
+	"microblog/pkg/ratelimit"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ratelimit.ParsePrefixes([]string{"10.0.0.0/8", "192.0.2.10"})
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		name       string
+		remote     string
+		forwarded  []string
+		expectedIP string
+	}{
+		{"direct", "198.51.100.7:4000", nil, "198.51.100.7"},
+		{"untrusted peers cannot spoof", "198.51.100.7:4000", []string{"203.0.113.1"}, "198.51.100.7"},
+		{"trusted proxy", "10.1.2.3:4000", []string{"203.0.113.1"}, "203.0.113.1"},
+		{"client sent its own header", "10.1.2.3:4000", []string{"203.0.113.66, 203.0.113.1"}, "203.0.113.1"},
+		{"chain of trusted proxies", "10.1.2.3:4000", []string{"203.0.113.1, 192.0.2.10", "10.9.9.9"}, "203.0.113.1"},
+		{"malformed hop", "10.1.2.3:4000", []string{"203.0.113.1, bogus"}, "10.1.2.3"},
+		{"only proxies", "10.1.2.3:4000", []string{"10.0.0.1"}, "10.0.0.1"},
+		{"no header", "10.1.2.3:4000", nil, "10.1.2.3"},
+		{"mapped IPv4", "[::ffff:10.1.2.3]:4000", []string{"2001:db8::1"}, "2001:db8::1"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = test.remote
+			for _, v := range test.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			assert.Equal(t, test.expectedIP, ratelimit.ClientIP(r, trusted))
+		})
+	}
+}
+
+func TestParsePrefixesError(t *testing.T) {
+	_, err := ratelimit.ParsePrefixes([]string{"10.0.0.0/33"})
+	assert.ErrorContains(t, err, "10.0.0.0/33")
+	_, err = ratelimit.ParsePrefixes([]string{"proxy.internal"})
+	assert.ErrorContains(t, err, "proxy.internal")
+}
@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets which have filled up again are
+// forgotten, so clients seen once do not stay in memory.
+const sweepInterval = time.Minute
+
+// Policy allows Burst requests at once, refilled at Rate a minute. A zero
+// Rate does not limit at all.
+type Policy struct {
+	Rate  float64
+	Burst int
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// Limiter keeps a token bucket for every key, such as a client IP. A nil
+// Limiter allows everything.
+type Limiter struct {
+	Policy Policy
+	Now    func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func New(policy Policy) *Limiter {
+	return &Limiter{Policy: policy, Now: time.Now, buckets: map[string]*bucket{}}
+}
+
+// Allow takes a token from the bucket of key. When the bucket is empty it
+// returns false and how long until the next token.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil || l.Policy.Rate <= 0 {
+		return true, 0
+	}
+	perSecond := l.Policy.Rate / 60
+	burst := float64(max(l.Policy.Burst, 1))
+	now := l.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) >= sweepInterval {
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.at).Seconds()*perSecond >= burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, at: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(burst, b.tokens+now.Sub(b.at).Seconds()*perSecond)
+	b.at = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// TooManyRequests refuses a request, telling the client to come back after
+// wait.
+func TooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	SetRetryAfter(w, wait)
+	http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+}
+
+// SetRetryAfter sets the Retry-After header to wait, in whole seconds
+// rounded up.
+func SetRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := max(int64(math.Ceil(wait.Seconds())), 1)
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+}
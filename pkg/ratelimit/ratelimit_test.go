@@ -0,0 +1,96 @@
+package ratelimit_test
+
+import (
+	"microblog/pkg/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+func TestLimiter(t *testing.T) {
+	c := &clock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	limiter := ratelimit.New(ratelimit.Policy{Rate: 30, Burst: 3})
+	limiter.Now = c.Now
+
+	for range 3 {
+		ok, _ := limiter.Allow("192.0.2.1")
+		require.True(t, ok)
+	}
+	ok, wait := limiter.Allow("192.0.2.1")
+	assert.False(t, ok)
+	assert.Equal(t, 2*time.Second, wait, "a token comes every two seconds")
+	ok, _ = limiter.Allow("192.0.2.2")
+	assert.True(t, ok, "every key has its own bucket")
+
+	c.now = c.now.Add(2 * time.Second)
+	ok, _ = limiter.Allow("192.0.2.1")
+	assert.True(t, ok)
+	ok, _ = limiter.Allow("192.0.2.1")
+	assert.False(t, ok)
+
+	// buckets never hold more than the burst
+	c.now = c.now.Add(time.Hour)
+	for range 3 {
+		ok, _ := limiter.Allow("192.0.2.1")
+		require.True(t, ok)
+	}
+	ok, _ = limiter.Allow("192.0.2.1")
+	assert.False(t, ok)
+}
+
+func TestLimiterOff(t *testing.T) {
+	var limiter *ratelimit.Limiter
+	ok, _ := limiter.Allow("192.0.2.1")
+	assert.True(t, ok)
+
+	limiter = ratelimit.New(ratelimit.Policy{})
+	for range 100 {
+		ok, _ := limiter.Allow("192.0.2.1")
+		require.True(t, ok)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	c := &clock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	lockout := ratelimit.NewLockout(3, time.Minute, 5*time.Minute)
+	lockout.Now = c.Now
+
+	assert.Zero(t, lockout.Fail("user:ann"))
+	assert.Zero(t, lockout.Fail("user:ann"))
+	assert.Zero(t, lockout.Locked("user:ann"))
+	assert.Equal(t, time.Minute, lockout.Fail("user:ann"))
+	c.now = c.now.Add(20 * time.Second)
+	assert.Equal(t, 40*time.Second, lockout.Locked("user:ann"))
+	assert.Zero(t, lockout.Locked("user:bob"))
+
+	// locks double with every further failure, up to the max
+	c.now = c.now.Add(time.Minute)
+	assert.Zero(t, lockout.Locked("user:ann"))
+	assert.Equal(t, 2*time.Minute, lockout.Fail("user:ann"))
+	assert.Equal(t, 4*time.Minute, lockout.Fail("user:ann"))
+	assert.Equal(t, 5*time.Minute, lockout.Fail("user:ann"))
+
+	lockout.Reset("user:ann")
+	assert.Zero(t, lockout.Locked("user:ann"))
+	assert.Zero(t, lockout.Fail("user:ann"))
+
+	// failures are forgotten after a day without any
+	lockout.Fail("user:ann")
+	c.now = c.now.Add(25 * time.Hour)
+	assert.Zero(t, lockout.Fail("user:ann"))
+}
+
+func TestTooManyRequests(t *testing.T) {
+	w := httptest.NewRecorder()
+	ratelimit.TooManyRequests(w, 1500*time.Millisecond)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+}
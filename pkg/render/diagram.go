@@ -0,0 +1,127 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// diagramTimeout bounds a single run of an external diagram tool.
+const diagramTimeout = 10 * time.Second
+
+// maxCachedDiagrams caps the diagram cache; it is cleared when full.
+const maxCachedDiagrams = 512
+
+// diagramTools maps fenced code languages to the local binary which turns
+// them into SVG. Languages whose binary is not installed fall back to
+// showing the diagram source.
+var diagramTools = map[string]func(ctx context.Context, source string) ([]byte, error){
+	"dot":      renderGraphviz,
+	"graphviz": renderGraphviz,
+	"mermaid":  renderMermaid,
+}
+
+// diagrammer renders diagram code blocks and caches the output by a hash of
+// the source, so each revision of a diagram is only rendered once.
+type diagrammer struct {
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]string
+}
+
+func newDiagrammer() *diagrammer {
+	return &diagrammer{cache: map[[sha256.Size]byte]string{}}
+}
+
+func isDiagram(language string) bool {
+	_, ok := diagramTools[language]
+	return ok
+}
+
+func (d *diagrammer) render(language, source string) string {
+	key := sha256.Sum256([]byte(language + "\x00" + source))
+
+	d.mu.Lock()
+	out, ok := d.cache[key]
+	d.mu.Unlock()
+	if ok {
+		return out
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), diagramTimeout)
+	defer cancel()
+
+	svg, err := diagramTools[language](ctx, source)
+	if err != nil {
+		if !errors.Is(err, exec.ErrNotFound) {
+			slog.Error("Error rendering diagram", "language", language, "err", err)
+		}
+		return fmt.Sprintf("<pre class=\"diagram\"><code class=\"language-%s\">%s</code></pre>\n", language, html.EscapeString(source))
+	}
+
+	// served as an image so any script in the SVG never runs
+	out = fmt.Sprintf("<figure class=\"diagram\"><img src=\"data:image/svg+xml;base64,%s\" alt=\"%s diagram\"/></figure>\n",
+		base64.StdEncoding.EncodeToString(svg), language)
+
+	d.mu.Lock()
+	if len(d.cache) >= maxCachedDiagrams {
+		clear(d.cache)
+	}
+	d.cache[key] = out
+	d.mu.Unlock()
+
+	return out
+}
+
+func renderGraphviz(ctx context.Context, source string) ([]byte, error) {
+	path, err := exec.LookPath("dot")
+	if err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, "-Tsvg")
+	cmd.Stdin = bytes.NewBufferString(source)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
+
+func renderMermaid(ctx context.Context, source string) ([]byte, error) {
+	path, err := exec.LookPath("mmdc")
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "microblog-mermaid")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "diagram.mmd")
+	output := filepath.Join(dir, "diagram.svg")
+	if err := os.WriteFile(input, []byte(source), 0o600); err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, "--quiet", "-i", input, "-o", output)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return os.ReadFile(output)
+}
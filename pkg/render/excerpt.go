@@ -0,0 +1,145 @@
+package render
+
+import (
+	"html/template"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// MoreMarker, on a line of its own, splits a post into the excerpt shown
+	// on listings and the remainder only shown on the post page.
+	MoreMarker = "<!--more-->"
+
+	// excerptLength is the number of visible characters kept by automatic
+	// excerpts.
+	excerptLength = 300
+
+	ellipsis = "…"
+)
+
+// voidElements never have a closing tag so are not tracked as open.
+var voidElements = map[string]bool{
+	"area": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// Truncate shortens an HTML fragment to at most limit visible characters.
+// It cuts on a word boundary where possible, never splits a multi-byte
+// character and closes any elements left open by the cut. Fragments which
+// already fit are returned unchanged.
+func Truncate(fragment template.HTML, limit int) template.HTML {
+	z := html.NewTokenizer(strings.NewReader(string(fragment)))
+
+	var b strings.Builder
+	var open []string
+	count := 0
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return fragment
+
+		case html.TextToken:
+			text := []rune(string(z.Text()))
+			if count+len(text) <= limit {
+				b.WriteString(html.EscapeString(string(text)))
+				count += len(text)
+				continue
+			}
+
+			cut := text[:limit-count]
+			// back off to the last word boundary unless that would leave
+			// the whole excerpt empty
+			if !unicode.IsSpace(text[limit-count]) {
+				i := lastSpace(cut)
+				switch {
+				case i >= 0:
+					cut = cut[:i]
+				case count > 0:
+					cut = nil
+				}
+			}
+
+			b.WriteString(html.EscapeString(strings.TrimRightFunc(string(cut), unicode.IsSpace)))
+			b.WriteString(ellipsis)
+			for i := len(open) - 1; i >= 0; i-- {
+				b.WriteString("</" + open[i] + ">")
+			}
+			return template.HTML(b.String())
+
+		case html.StartTagToken:
+			tok := z.Token()
+			b.WriteString(tok.String())
+			if !voidElements[tok.Data] {
+				open = append(open, tok.Data)
+			}
+
+		case html.EndTagToken:
+			tok := z.Token()
+			b.WriteString(tok.String())
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == tok.Data {
+					open = open[:i]
+					break
+				}
+			}
+
+		case html.SelfClosingTagToken:
+			b.WriteString(z.Token().String())
+		}
+	}
+}
+
+func lastSpace(runes []rune) int {
+	for i := len(runes) - 1; i >= 0; i-- {
+		if unicode.IsSpace(runes[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// PlainText returns the visible text of an HTML fragment with whitespace
+// collapsed, for use in meta descriptions. Text longer than limit characters
+// is cut on a word boundary.
+func PlainText(fragment template.HTML, limit int) string {
+	z := html.NewTokenizer(strings.NewReader(string(fragment)))
+
+	var words []string
+	skipping := 0
+	for done := false; !done; {
+		switch z.Next() {
+		case html.ErrorToken:
+			done = true
+		case html.StartTagToken:
+			if name, _ := z.TagName(); uncountedElements[string(name)] {
+				skipping++
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); uncountedElements[string(name)] && skipping > 0 {
+				skipping--
+			}
+		case html.TextToken:
+			if skipping == 0 {
+				words = append(words, strings.Fields(string(z.Text()))...)
+			}
+		}
+	}
+
+	text := []rune(strings.Join(words, " "))
+	if len(text) <= limit {
+		return string(text)
+	}
+
+	cut := text[:limit]
+	if !unicode.IsSpace(text[limit]) {
+		if i := lastSpace(cut); i > 0 {
+			cut = cut[:i]
+		}
+	}
+	return strings.TrimRightFunc(string(cut), unicode.IsSpace) + ellipsis
+}
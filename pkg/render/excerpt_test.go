@@ -0,0 +1,104 @@
+package render_test
+
+import (
+	"html/template"
+	"microblog/pkg/render"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name     string
+		fragment template.HTML
+		limit    int
+		want     template.HTML
+	}{
+		{
+			name:     "fits",
+			fragment: "<p>short</p>",
+			limit:    10,
+			want:     "<p>short</p>",
+		},
+		{
+			name:     "cuts on word boundary",
+			fragment: "<p>the quick brown fox</p>",
+			limit:    12,
+			want:     "<p>the quick…</p>",
+		},
+		{
+			name:     "closes open tags",
+			fragment: "<p>one <strong>two <em>three four</em></strong> five</p>",
+			limit:    14,
+			want:     "<p>one <strong>two <em>three…</em></strong></p>",
+		},
+		{
+			name:     "does not split tags",
+			fragment: `<p>see <a href="https://example.com/very/long">the link</a> here</p>`,
+			limit:    8,
+			want:     `<p>see <a href="https://example.com/very/long">the…</a></p>`,
+		},
+		{
+			name:     "does not split multi-byte characters",
+			fragment: "<p>ééééééééé</p>",
+			limit:    4,
+			want:     "<p>éééé…</p>",
+		},
+		{
+			name:     "drops a word that starts at the cut",
+			fragment: "<p>one</p><p>twothree</p>",
+			limit:    5,
+			want:     "<p>one</p><p>…</p>",
+		},
+		{
+			name:     "keeps entities escaped",
+			fragment: "<p>a &lt;b&gt; c d</p>",
+			limit:    6,
+			want:     "<p>a &lt;b&gt;…</p>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := render.Truncate(tt.fragment, tt.limit)
+			assert.Equal(t, tt.want, got)
+			assert.True(t, utf8.ValidString(string(got)))
+		})
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		name     string
+		fragment template.HTML
+		limit    int
+		want     string
+	}{
+		{
+			name:     "strips markup",
+			fragment: "<p>the <em>quick</em>\n<code>brown</code> fox</p>",
+			limit:    100,
+			want:     "the quick brown fox",
+		},
+		{
+			name:     "cuts on word boundary",
+			fragment: "<p>the quick brown fox</p>",
+			limit:    12,
+			want:     "the quick…",
+		},
+		{
+			name:     "skips table of contents",
+			fragment: `<nav class="toc"><a href="#a">A</a></nav><p>body</p>`,
+			limit:    100,
+			want:     "body",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, render.PlainText(tt.fragment, tt.limit))
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+const (
+	lightStyle = "github"
+	darkStyle  = "github-dark"
+)
+
+// highlighter renders fenced code blocks with chroma, emitting class names
+// rather than inline styles so the colours come from HighlightCSS.
+//
+// The info string selects the lexer and may carry line ranges to highlight:
+//
+//	```go {3-5,8}
+//
+// Diagram languages are handed to the diagrammer instead.
+type highlighter struct {
+	diagrams *diagrammer
+}
+
+func (h *highlighter) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, h.renderFencedCodeBlock)
+}
+
+func (h *highlighter) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.FencedCodeBlock)
+
+	var info string
+	if n.Info != nil {
+		info = string(n.Info.Text(source))
+	}
+	language, ranges := parseInfo(info)
+
+	var code bytes.Buffer
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		code.Write(line.Value(source))
+	}
+
+	if isDiagram(language) {
+		_, _ = w.WriteString(h.diagrams.render(language, code.String()))
+		return ast.WalkSkipChildren, nil
+	}
+
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code.String())
+	if err != nil {
+		return ast.WalkStop, err
+	}
+
+	formatter := chromahtml.New(
+		chromahtml.WithClasses(true),
+		chromahtml.WithLineNumbers(lines.Len() > 1),
+		chromahtml.HighlightLines(ranges),
+		chromahtml.WithPreWrapper(preWrapper(language)),
+	)
+	if err := formatter.Format(w, styles.Get(lightStyle), iterator); err != nil {
+		return ast.WalkStop, err
+	}
+	_ = w.WriteByte('\n')
+
+	return ast.WalkSkipChildren, nil
+}
+
+// preWrapper keeps the language-* class goldmark puts on code elements so
+// highlighted blocks can still be targeted by language.
+type preWrapper string
+
+func (p preWrapper) Start(code bool, styleAttr string) string {
+	if !code {
+		return "<pre" + styleAttr + ">"
+	}
+	if p == "" {
+		return "<pre" + styleAttr + "><code>"
+	}
+	return fmt.Sprintf(`<pre%s><code class="language-%s">`, styleAttr, html.EscapeString(string(p)))
+}
+
+func (p preWrapper) End(code bool) string {
+	if code {
+		return "</code></pre>"
+	}
+	return "</pre>"
+}
+
+var rangesRe = regexp.MustCompile(`\{([\d,\s-]*)\}`)
+
+// parseInfo splits a fenced code info string such as "go {3-5,8}" into the
+// language and the line ranges to highlight.
+func parseInfo(info string) (string, [][2]int) {
+	var ranges [][2]int
+	if m := rangesRe.FindStringSubmatch(info); m != nil {
+		info = strings.Replace(info, m[0], "", 1)
+		for _, part := range strings.Split(m[1], ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			startStr, endStr, isRange := strings.Cut(part, "-")
+			start, err := strconv.Atoi(strings.TrimSpace(startStr))
+			if err != nil {
+				continue
+			}
+			end := start
+			if isRange {
+				if end, err = strconv.Atoi(strings.TrimSpace(endStr)); err != nil {
+					continue
+				}
+			}
+			if end < start {
+				start, end = end, start
+			}
+			ranges = append(ranges, [2]int{start, end})
+		}
+	}
+
+	fields := strings.Fields(info)
+	if len(fields) == 0 {
+		return "", ranges
+	}
+	return fields[0], ranges
+}
+
+// highlightClasses matches the class attributes chroma emits, so the
+// sanitiser can keep them without allowing arbitrary classes.
+func highlightClasses() *regexp.Regexp {
+	names := []string{}
+	for _, name := range chroma.StandardTypes {
+		if name != "" {
+			names = append(names, regexp.QuoteMeta(name))
+		}
+	}
+	sort.Strings(names)
+	class := strings.Join(names, "|")
+	return regexp.MustCompile(fmt.Sprintf(`^(%s)( (%s))*$`, class, class))
+}
+
+var (
+	highlightCSS     []byte
+	highlightCSSOnce sync.Once
+)
+
+// HighlightCSS returns the stylesheet for highlighted code blocks, with the
+// light theme by default and the dark theme under prefers-color-scheme.
+func HighlightCSS() []byte {
+	highlightCSSOnce.Do(func() {
+		formatter := chromahtml.New(chromahtml.WithClasses(true))
+
+		var buf bytes.Buffer
+		if err := formatter.WriteCSS(&buf, styles.Get(lightStyle)); err != nil {
+			panic(err)
+		}
+
+		buf.WriteString("@media (prefers-color-scheme: dark) {\n")
+		if err := formatter.WriteCSS(&buf, styles.Get(darkStyle)); err != nil {
+			panic(err)
+		}
+		buf.WriteString("}\n")
+
+		highlightCSS = buf.Bytes()
+	})
+	return highlightCSS
+}
@@ -0,0 +1,105 @@
+package render
+
+import (
+	"fmt"
+	"html"
+	"log/slog"
+	"microblog/pkg/models"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// ImageVariants looks up the resized variants of uploaded images.
+type ImageVariants interface {
+	Variants(hash string) ([]*models.Media, error)
+}
+
+// imageSizes tells the browser how wide images are laid out: the whole
+// viewport on small screens and the post column otherwise.
+const imageSizes = "(max-width: 800px) 100vw, 760px"
+
+var mediaImage = regexp.MustCompile(`^/media/([0-9a-f]{64})/`)
+
+// imageRenderer turns images pointing at our media routes into responsive
+// images offering every variant. Other images render as usual.
+type imageRenderer struct {
+	images ImageVariants
+}
+
+func (r *imageRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindImage, r.renderImage)
+}
+
+func (r *imageRenderer) renderImage(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Image)
+
+	alt := html.EscapeString(string(n.Text(source)))
+	var title string
+	if n.Title != nil {
+		title = ` title="` + html.EscapeString(string(n.Title)) + `"`
+	}
+
+	if m := mediaImage.FindSubmatch(n.Destination); m != nil {
+		variants, err := r.images.Variants(string(m[1]))
+		if err != nil {
+			slog.Error("Error getting variants", "hash", m[1], "err", err)
+		}
+		if picture := responsiveImage(variants, alt, title); picture != "" {
+			_, _ = w.WriteString(picture)
+			return ast.WalkSkipChildren, nil
+		}
+	}
+
+	fmt.Fprintf(w, `<img src="%s" alt="%s"%s />`, util.EscapeHTML(util.URLEscape(n.Destination, true)), alt, title)
+	return ast.WalkSkipChildren, nil
+}
+
+// responsiveImage builds a <picture> from the variants of an image. WebP is
+// only offered when every WebP variant is smaller than its JPEG, as the
+// encoder is lossless and loses out to JPEG on most photos.
+func responsiveImage(variants []*models.Media, alt, title string) string {
+	byType := map[string][]*models.Media{}
+	for _, v := range variants {
+		byType[v.ContentType] = append(byType[v.ContentType], v)
+	}
+	jpegs, webps := byType["image/jpeg"], byType["image/webp"]
+	if len(jpegs) == 0 {
+		return ""
+	}
+	for _, set := range [][]*models.Media{jpegs, webps} {
+		sort.Slice(set, func(i, j int) bool { return set[i].Width < set[j].Width })
+	}
+
+	useWebP := len(webps) == len(jpegs)
+	for i := 0; useWebP && i < len(webps); i++ {
+		useWebP = webps[i].Width == jpegs[i].Width && webps[i].Size < jpegs[i].Size
+	}
+
+	largest := jpegs[len(jpegs)-1]
+
+	var b strings.Builder
+	b.WriteString("<picture>")
+	if useWebP {
+		fmt.Fprintf(&b, `<source type="image/webp" srcset="%s" sizes="%s" />`, srcset(webps), imageSizes)
+	}
+	fmt.Fprintf(&b, `<img src="%s" srcset="%s" sizes="%s" width="%d" height="%d" alt="%s"%s loading="lazy" decoding="async" />`,
+		largest.URL(), srcset(jpegs), imageSizes, largest.Width, largest.Height, alt, title)
+	b.WriteString("</picture>")
+	return b.String()
+}
+
+func srcset(variants []*models.Media) string {
+	candidates := make([]string, len(variants))
+	for i, v := range variants {
+		candidates[i] = fmt.Sprintf("%s %dw", v.URL(), v.Width)
+	}
+	return strings.Join(candidates, ", ")
+}
@@ -0,0 +1,93 @@
+package render_test
+
+import (
+	"errors"
+	"microblog/pkg/models"
+	"microblog/pkg/render"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	photoHash = strings.Repeat("a", 64)
+	photoURL  = "/media/" + photoHash + "/photo.jpg"
+)
+
+type fakeVariants map[string][]*models.Media
+
+func (f fakeVariants) Variants(hash string) ([]*models.Media, error) {
+	if hash == strings.Repeat("e", 64) {
+		return nil, errors.New("boom")
+	}
+	return f[hash], nil
+}
+
+func variant(width, height int, contentType string, size int64) *models.Media {
+	ext := map[string]string{"image/jpeg": "jpg", "image/webp": "webp"}[contentType]
+	// a valid hash which tells the width and type apart in assertions
+	hash := strings.Repeat(string(rune('0'+width/400)), 63) + map[string]string{"jpg": "c", "webp": "b"}[ext]
+	return &models.Media{
+		Hash:        hash,
+		Filename:    "photo-" + ext,
+		ContentType: contentType,
+		Size:        size,
+		Original:    photoHash,
+		Width:       width,
+		Height:      height,
+	}
+}
+
+func TestResponsiveImages(t *testing.T) {
+	r := render.New(render.Policy{}, render.WithImages(fakeVariants{photoHash: {
+		variant(800, 600, "image/jpeg", 2000),
+		variant(400, 300, "image/jpeg", 1000),
+		variant(400, 300, "image/webp", 900),
+		variant(800, 600, "image/webp", 1800),
+	}}))
+
+	got, err := r.Content(`![A photo](` + photoURL + ` "Title")`)
+	require.NoError(t, err)
+
+	assertSafe(t, string(got))
+	assert.Contains(t, got, `<picture><source type="image/webp" srcset="/media/111111111111111111111111111111111111111111111111111111111111111b/photo-webp 400w, /media/222222222222222222222222222222222222222222222222222222222222222b/photo-webp 800w" sizes="(max-width: 800px) 100vw, 760px"/>`)
+	assert.Contains(t, got, `<img src="/media/222222222222222222222222222222222222222222222222222222222222222c/photo-jpg"`)
+	assert.Contains(t, got, `srcset="/media/111111111111111111111111111111111111111111111111111111111111111c/photo-jpg 400w, /media/222222222222222222222222222222222222222222222222222222222222222c/photo-jpg 800w"`)
+	assert.Contains(t, got, `width="800" height="600" alt="A photo" title="Title" loading="lazy" decoding="async"/></picture>`)
+}
+
+func TestResponsiveImagesSkipLargerWebP(t *testing.T) {
+	r := render.New(render.Policy{}, render.WithImages(fakeVariants{photoHash: {
+		variant(400, 300, "image/jpeg", 1000),
+		variant(400, 300, "image/webp", 5000),
+	}}))
+
+	got, err := r.Content(`![photo](` + photoURL + `)`)
+	require.NoError(t, err)
+
+	assert.Contains(t, got, `<picture><img src=`)
+	assert.NotContains(t, got, "image/webp")
+}
+
+func TestImagesWithoutVariantsRenderAsUsual(t *testing.T) {
+	r := render.New(render.Policy{}, render.WithImages(fakeVariants{}))
+
+	for _, src := range []string{"https://example.com/a.png", photoURL, "/media/" + strings.Repeat("e", 64) + "/x.png"} {
+		got, err := r.Content(`![alt](` + src + `)`)
+		require.NoError(t, err)
+
+		assert.Contains(t, got, `<img src="`+src+`" alt="alt"/>`)
+		assert.NotContains(t, got, "<picture>")
+	}
+}
+
+func TestSrcsetOnlyAllowsMediaURLs(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Content(`<img src="/a.png" srcset="javascript:alert(1) 1x, https://evil.example/x.png 2x">`)
+	require.NoError(t, err)
+
+	assert.NotContains(t, got, "srcset")
+}
@@ -0,0 +1,446 @@
+package render
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// latexToMathML converts a LaTeX math expression into presentation MathML.
+// Only the subset commonly used in posts is supported: scripts, fractions,
+// roots, accents, greek letters, the usual operators and relations, and
+// \text-like commands. Unknown commands are rendered as an merror so the rest
+// of the expression still displays. The original source is kept as an
+// annotation for copy and paste.
+func latexToMathML(source string, display bool) string {
+	p := &mathParser{toks: tokenizeLatex(source), display: display}
+
+	var b strings.Builder
+	b.WriteString(`<math xmlns="http://www.w3.org/1998/Math/MathML"`)
+	if display {
+		b.WriteString(` display="block"`)
+	}
+	b.WriteString("><semantics><mrow>")
+	for p.pos < len(p.toks) {
+		// a stray closing brace would otherwise end the expression early
+		if p.toks[p.pos].kind == tokClose {
+			p.pos++
+			continue
+		}
+		b.WriteString(p.parseRow())
+	}
+	b.WriteString(`</mrow><annotation encoding="application/x-tex">`)
+	b.WriteString(html.EscapeString(source))
+	b.WriteString("</annotation></semantics></math>")
+	return b.String()
+}
+
+type latexTokenKind int
+
+const (
+	tokChar latexTokenKind = iota
+	tokNumber
+	tokCommand
+	tokText
+	tokOpen
+	tokClose
+	tokSup
+	tokSub
+)
+
+type latexToken struct {
+	kind latexTokenKind
+	val  string
+}
+
+// rawTextCommands take a brace group whose content is read verbatim,
+// keeping spaces which are otherwise insignificant in math mode.
+var rawTextCommands = map[string]bool{
+	"text": true, "textrm": true, "mbox": true, "operatorname": true,
+	"mathrm": true, "mathbf": true, "mathit": true, "mathbb": true, "mathcal": true,
+}
+
+func tokenizeLatex(source string) []latexToken {
+	runes := []rune(source)
+	var toks []latexToken
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '\\':
+			j := i + 1
+			for j < len(runes) && unicode.IsLetter(runes[j]) && runes[j] < unicode.MaxASCII {
+				j++
+			}
+			if j == i+1 && j < len(runes) {
+				// control symbol such as \, or \{
+				j++
+			}
+			name := string(runes[i+1 : j])
+			toks = append(toks, latexToken{kind: tokCommand, val: name})
+			i = j
+
+			if rawTextCommands[name] {
+				k := i
+				for k < len(runes) && unicode.IsSpace(runes[k]) {
+					k++
+				}
+				if k < len(runes) && runes[k] == '{' {
+					depth := 0
+					end := k
+					for ; end < len(runes); end++ {
+						if runes[end] == '{' {
+							depth++
+						} else if runes[end] == '}' {
+							depth--
+							if depth == 0 {
+								break
+							}
+						}
+					}
+					toks = append(toks, latexToken{kind: tokText, val: string(runes[k+1 : min(end, len(runes))])})
+					i = min(end+1, len(runes))
+				}
+			}
+
+		case r == '{':
+			toks = append(toks, latexToken{kind: tokOpen})
+			i++
+		case r == '}':
+			toks = append(toks, latexToken{kind: tokClose})
+			i++
+		case r == '^':
+			toks = append(toks, latexToken{kind: tokSup})
+			i++
+		case r == '_':
+			toks = append(toks, latexToken{kind: tokSub})
+			i++
+
+		case unicode.IsDigit(r):
+			j := i
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.' && j+1 < len(runes) && unicode.IsDigit(runes[j+1])) {
+				j++
+			}
+			toks = append(toks, latexToken{kind: tokNumber, val: string(runes[i:j])})
+			i = j
+
+		default:
+			toks = append(toks, latexToken{kind: tokChar, val: string(r)})
+			i++
+		}
+	}
+
+	return toks
+}
+
+type mathParser struct {
+	toks    []latexToken
+	pos     int
+	display bool
+}
+
+func (p *mathParser) peek() (latexToken, bool) {
+	if p.pos >= len(p.toks) {
+		return latexToken{}, false
+	}
+	return p.toks[p.pos], true
+}
+
+func (p *mathParser) next() (latexToken, bool) {
+	tok, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return tok, ok
+}
+
+// parseRow parses atoms until the end of input or a closing brace, which is
+// left for the caller to consume.
+func (p *mathParser) parseRow() string {
+	var b strings.Builder
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.kind == tokClose {
+			return b.String()
+		}
+		b.WriteString(p.parseScripted())
+	}
+}
+
+// parseGroup parses the content of a brace group, the opening brace having
+// already been consumed.
+func (p *mathParser) parseGroup() string {
+	row := p.parseRow()
+	if tok, ok := p.peek(); ok && tok.kind == tokClose {
+		p.pos++
+	}
+	return "<mrow>" + row + "</mrow>"
+}
+
+// parseArg parses a single argument: a brace group or one atom.
+func (p *mathParser) parseArg() string {
+	tok, ok := p.peek()
+	if !ok || tok.kind == tokClose {
+		return "<mrow></mrow>"
+	}
+	if tok.kind == tokOpen {
+		p.pos++
+		return p.parseGroup()
+	}
+	atom, _ := p.parseAtom()
+	return atom
+}
+
+func (p *mathParser) parseScripted() string {
+	base, largeOp := p.parseAtom()
+
+	var sub, sup string
+	for {
+		tok, ok := p.peek()
+		if !ok {
+			break
+		}
+		if tok.kind == tokSub && sub == "" {
+			p.pos++
+			sub = p.parseArg()
+		} else if tok.kind == tokSup && sup == "" {
+			p.pos++
+			sup = p.parseArg()
+		} else {
+			break
+		}
+	}
+
+	under, over, both := "msub", "msup", "msubsup"
+	if largeOp && p.display {
+		under, over, both = "munder", "mover", "munderover"
+	}
+
+	switch {
+	case sub != "" && sup != "":
+		return fmt.Sprintf("<%s>%s%s%s</%s>", both, base, sub, sup, both)
+	case sub != "":
+		return fmt.Sprintf("<%s>%s%s</%s>", under, base, sub, under)
+	case sup != "":
+		return fmt.Sprintf("<%s>%s%s</%s>", over, base, sup, over)
+	}
+	return base
+}
+
+// parseAtom parses one atom and reports whether it is a large operator whose
+// limits go above and below in display mode.
+func (p *mathParser) parseAtom() (string, bool) {
+	tok, ok := p.next()
+	if !ok {
+		return "", false
+	}
+
+	switch tok.kind {
+	case tokOpen:
+		return p.parseGroup(), false
+	case tokSub, tokSup:
+		// a script with no base, e.g. ^2 at the start of a group
+		p.pos--
+		return "<mrow></mrow>", false
+	case tokNumber:
+		return mn(tok.val), false
+	case tokText:
+		return mtext(tok.val), false
+	case tokChar:
+		return charAtom(tok.val), false
+	case tokCommand:
+		return p.command(tok.val)
+	}
+	return "", false
+}
+
+func charAtom(c string) string {
+	r := []rune(c)[0]
+	switch {
+	case unicode.IsLetter(r):
+		return mi(c)
+	case c == "-":
+		return mo("−")
+	case c == "'":
+		return mo("′")
+	case c == "*":
+		return mo("∗")
+	}
+	return mo(c)
+}
+
+var mathIdentifiers = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε",
+	"zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ",
+	"lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ", "pi": "π", "varpi": "ϖ", "rho": "ρ",
+	"varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ", "phi": "ϕ",
+	"varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"infty": "∞", "emptyset": "∅", "partial": "∂", "nabla": "∇", "ell": "ℓ", "hbar": "ℏ",
+}
+
+var mathUprightIdentifiers = map[string]string{
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+	"Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+}
+
+var mathOperators = map[string]string{
+	"times": "×", "cdot": "⋅", "div": "÷", "pm": "±", "mp": "∓", "ast": "∗", "circ": "∘",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠", "approx": "≈",
+	"equiv": "≡", "sim": "∼", "simeq": "≃", "cong": "≅", "propto": "∝", "ll": "≪", "gg": "≫",
+	"in": "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "subseteq": "⊆", "supset": "⊃",
+	"supseteq": "⊇", "cup": "∪", "cap": "∩", "setminus": "∖", "forall": "∀", "exists": "∃",
+	"neg": "¬", "lnot": "¬", "land": "∧", "wedge": "∧", "lor": "∨", "vee": "∨", "oplus": "⊕",
+	"otimes": "⊗", "to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←",
+	"Rightarrow": "⇒", "Leftarrow": "⇐", "leftrightarrow": "↔", "Leftrightarrow": "⇔",
+	"iff": "⟺", "implies": "⟹", "mapsto": "↦", "ldots": "…", "dots": "…", "cdots": "⋯",
+	"vdots": "⋮", "ddots": "⋱", "langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋",
+	"lceil": "⌈", "rceil": "⌉", "mid": "∣", "vert": "|", "Vert": "‖", "prime": "′",
+	"{": "{", "}": "}", "|": "‖", "%": "%", "$": "$", "#": "#", "&": "&", "_": "_",
+}
+
+var mathLargeOperators = map[string]string{
+	"sum": "∑", "prod": "∏", "coprod": "∐", "int": "∫", "iint": "∬", "iiint": "∭",
+	"oint": "∮", "bigcup": "⋃", "bigcap": "⋂", "bigoplus": "⨁", "bigotimes": "⨂",
+}
+
+// mathLimitFunctions are named functions which take limits like \sum.
+var mathLimitFunctions = map[string]bool{
+	"lim": true, "max": true, "min": true, "sup": true, "inf": true, "det": true, "gcd": true,
+	"liminf": true, "limsup": true, "argmax": true, "argmin": true,
+}
+
+var mathFunctions = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "cot": true, "sec": true, "csc": true,
+	"arcsin": true, "arccos": true, "arctan": true, "sinh": true, "cosh": true, "tanh": true,
+	"log": true, "ln": true, "lg": true, "exp": true, "deg": true, "arg": true, "dim": true,
+	"ker": true, "hom": true, "Pr": true,
+}
+
+var mathSpaces = map[string]string{
+	",": "0.1667em", ":": "0.2222em", ">": "0.2222em", ";": "0.2778em", " ": "0.25em",
+	"!": "-0.1667em", "quad": "1em", "qquad": "2em",
+}
+
+var mathAccents = map[string]string{
+	"hat": "^", "widehat": "^", "bar": "¯", "overline": "¯", "vec": "→", "tilde": "~",
+	"widetilde": "~", "dot": "˙", "ddot": "¨",
+}
+
+var mathVariants = map[string]string{
+	"mathrm": "normal", "mathbf": "bold", "mathit": "italic", "mathbb": "double-struck",
+	"mathcal": "script",
+}
+
+func (p *mathParser) command(name string) (string, bool) {
+	if s, ok := mathIdentifiers[name]; ok {
+		return mi(s), false
+	}
+	if s, ok := mathUprightIdentifiers[name]; ok {
+		return `<mi mathvariant="normal">` + s + "</mi>", false
+	}
+	if s, ok := mathOperators[name]; ok {
+		return mo(s), false
+	}
+	if s, ok := mathLargeOperators[name]; ok {
+		return mo(s), true
+	}
+	if mathLimitFunctions[name] {
+		return mi(name), true
+	}
+	if mathFunctions[name] {
+		return mi(name), false
+	}
+	if width, ok := mathSpaces[name]; ok {
+		return `<mspace width="` + width + `"/>`, false
+	}
+	if accent, ok := mathAccents[name]; ok {
+		return `<mover accent="true">` + p.parseArg() + mo(accent) + "</mover>", false
+	}
+
+	switch name {
+	case "\\":
+		return `<mspace linebreak="newline"/>`, false
+
+	case "frac", "dfrac", "tfrac":
+		num := p.parseArg()
+		den := p.parseArg()
+		return "<mfrac>" + num + den + "</mfrac>", false
+
+	case "sqrt":
+		if tok, ok := p.peek(); ok && tok.kind == tokChar && tok.val == "[" {
+			p.pos++
+			var index strings.Builder
+			for {
+				tok, ok := p.peek()
+				if !ok {
+					break
+				}
+				if tok.kind == tokChar && tok.val == "]" {
+					p.pos++
+					break
+				}
+				index.WriteString(p.parseScripted())
+			}
+			radicand := p.parseArg()
+			return "<mroot>" + radicand + "<mrow>" + index.String() + "</mrow></mroot>", false
+		}
+		return "<msqrt>" + p.parseArg() + "</msqrt>", false
+
+	case "left", "right", "big", "Big", "bigg", "Bigg", "bigl", "bigr", "Bigl", "Bigr":
+		tok, ok := p.next()
+		if !ok || tok.kind == tokChar && tok.val == "." {
+			return "", false
+		}
+		delim := tok.val
+		if tok.kind == tokCommand {
+			if s, ok := mathOperators[tok.val]; ok {
+				delim = s
+			}
+		}
+		return `<mo stretchy="true">` + html.EscapeString(delim) + "</mo>", false
+
+	case "text", "textrm", "mbox":
+		if tok, ok := p.peek(); ok && tok.kind == tokText {
+			p.pos++
+			return mtext(tok.val), false
+		}
+		return "", false
+
+	case "operatorname":
+		if tok, ok := p.peek(); ok && tok.kind == tokText {
+			p.pos++
+			return mi(tok.val), false
+		}
+		return "", false
+	}
+
+	if variant, ok := mathVariants[name]; ok {
+		if tok, ok := p.peek(); ok && tok.kind == tokText {
+			p.pos++
+			return `<mi mathvariant="` + variant + `">` + html.EscapeString(strings.TrimSpace(tok.val)) + "</mi>", false
+		}
+		return p.parseArg(), false
+	}
+
+	return "<merror><mtext>\\" + html.EscapeString(name) + "</mtext></merror>", false
+}
+
+func mi(s string) string {
+	return "<mi>" + html.EscapeString(s) + "</mi>"
+}
+
+func mn(s string) string {
+	return "<mn>" + html.EscapeString(s) + "</mn>"
+}
+
+func mo(s string) string {
+	return "<mo>" + html.EscapeString(s) + "</mo>"
+}
+
+func mtext(s string) string {
+	return "<mtext>" + html.EscapeString(s) + "</mtext>"
+}
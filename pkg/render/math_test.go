@@ -0,0 +1,115 @@
+package render_test
+
+import (
+	"context"
+	"microblog/pkg/models"
+	"microblog/pkg/render"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInlineMath(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Content(`Euler: $e^{i\pi} + 1 = 0$`)
+	require.NoError(t, err)
+
+	assert.Contains(t, got, `<math xmlns="http://www.w3.org/1998/Math/MathML">`)
+	assert.Contains(t, got, `<msup><mi>e</mi><mrow><mi>i</mi><mi>π</mi></mrow></msup>`)
+	assert.Contains(t, got, `<annotation encoding="application/x-tex">e^{i\pi} + 1 = 0</annotation>`)
+}
+
+func TestDisplayMath(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Content("$$\n\\frac{a}{b}\n$$\n\nand $$\\sqrt{x}$$ inline")
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, strings.Count(string(got), `<math xmlns="http://www.w3.org/1998/Math/MathML" display="block">`))
+	assert.Contains(t, got, `<mfrac><mrow><mi>a</mi></mrow><mrow><mi>b</mi></mrow></mfrac>`)
+	assert.Contains(t, got, `<msqrt><mrow><mi>x</mi></mrow></msqrt>`)
+}
+
+func TestDollarAmountsAreNotMath(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Content("It costs $5 and $10, or $ 3 $ at most.")
+	require.NoError(t, err)
+
+	assert.NotContains(t, got, "<math")
+	assert.Contains(t, got, "It costs $5 and $10, or $ 3 $ at most.")
+}
+
+func TestMathIsEscaped(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Content(`$x < y \text{<script>alert(1)</script>}$ and $\unknown$`)
+	require.NoError(t, err)
+
+	assertSafe(t, string(got))
+	assert.Contains(t, got, "<mo>&lt;</mo>")
+	assert.Contains(t, got, `<merror><mtext>\unknown</mtext></merror>`)
+}
+
+func TestMathSourceNotCounted(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	blogPost := &models.BlogPost{Title: "title", Content: `one two $\alpha + \beta$`}
+	r.Prepare(context.Background(), blogPost)
+
+	// the symbols are counted but not the LaTeX kept in the annotation
+	assert.Equal(t, 4, blogPost.WordCount)
+}
+
+func TestDiagramRenderedOncePerRevision(t *testing.T) {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho run >> " + calls + "\ncat > /dev/null\necho '<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>'\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "dot"), []byte(script), 0o755))
+	t.Setenv("PATH", dir)
+
+	r := render.New(render.Policy{})
+	source := "```dot\ndigraph { a -> b }\n```"
+
+	for i := 0; i < 2; i++ {
+		got, err := r.Content(source)
+		require.NoError(t, err)
+
+		assertSafe(t, string(got))
+		assert.Contains(t, got, `<figure class="diagram"><img src="data:image/svg+xml;base64,`)
+		assert.Contains(t, got, `alt="dot diagram"`)
+	}
+
+	out, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(out), "run"))
+}
+
+func TestDiagramFallsBackToSource(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	r := render.New(render.Policy{})
+
+	got, err := r.Content("```mermaid\ngraph TD; A-->B\n```")
+	require.NoError(t, err)
+
+	assert.Contains(t, got, `<pre class="diagram"><code class="language-mermaid">graph TD; A--&gt;B`)
+}
+
+func FuzzMath(f *testing.F) {
+	f.Add(`\frac{a}{b}^{2}_3`)
+	f.Add(`\left( \sqrt[n]{x} \right.`)
+	f.Add(`\text{unclosed`)
+	f.Add(`}}{{^_\\`)
+
+	r := render.New(render.Policy{})
+	f.Fuzz(func(t *testing.T, src string) {
+		got, err := r.Content("$$" + src + "$$\n\n$$\n" + src + "\n$$")
+		require.NoError(t, err)
+		assertSafe(t, string(got))
+	})
+}
@@ -0,0 +1,320 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"microblog/pkg/models"
+	"microblog/pkg/tracing"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	meta "github.com/yuin/goldmark-meta"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Renderer owns the goldmark configuration used to turn post markdown into
+// HTML, and the sanitiser stage every rendered fragment passes through. It is
+// safe for concurrent use.
+type Renderer struct {
+	md      goldmark.Markdown
+	version string
+	policy  Policy
+	strict  *bluemonday.Policy
+	trusted *bluemonday.Policy
+	images  ImageVariants
+	timings func(stage string, elapsed time.Duration)
+}
+
+// Option configures optional features of a Renderer.
+type Option func(*Renderer)
+
+// WithImages renders images served from the media library as responsive
+// images using the variants from images.
+func WithImages(images ImageVariants) Option {
+	return func(r *Renderer) {
+		r.images = images
+	}
+}
+
+// WithTimings reports how long each stage of preparing a post took to
+// render, the stage being "content", "title" or "excerpt".
+func WithTimings(observe func(stage string, elapsed time.Duration)) Option {
+	return func(r *Renderer) {
+		r.timings = observe
+	}
+}
+
+// pipelineVersion is part of the version of every Renderer. Bump it along
+// with any change to the goldmark configuration, node renderers or
+// sanitiser policies, so HTML persisted by older versions is rendered again.
+const pipelineVersion = 1
+
+func New(policy Policy, opts ...Option) *Renderer {
+	r := &Renderer{
+		policy:  policy,
+		strict:  strictPolicy(),
+		trusted: trustedPolicy(policy.IframeHosts),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.version = version(policy.IframeHosts, r.images != nil)
+
+	nodeRenderers := []util.PrioritizedValue{
+		util.Prioritized(&highlighter{diagrams: newDiagrammer()}, 200),
+		util.Prioritized(&tocRenderer{}, 200),
+	}
+	if r.images != nil {
+		nodeRenderers = append(nodeRenderers, util.Prioritized(&imageRenderer{images: r.images}, 200))
+	}
+
+	r.md = goldmark.New(
+		goldmark.WithExtensions(
+			extension.GFM,
+			extension.Footnote,
+			meta.Meta,
+			&mathExtension{},
+		),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+			parser.WithASTTransformers(
+				util.Prioritized(&headingTransformer{}, 100),
+			),
+		),
+		goldmark.WithRendererOptions(
+			html.WithHardWraps(),
+			html.WithXHTML(),
+			html.WithUnsafe(),
+			renderer.WithNodeRenderers(nodeRenderers...),
+		),
+	)
+	return r
+}
+
+// version identifies the output of a Renderer, which also depends on the
+// hosts iframes are allowed from and whether images are responsive.
+func version(iframeHosts []string, images bool) string {
+	h := sha256.New()
+	for _, host := range slices.Sorted(slices.Values(iframeHosts)) {
+		fmt.Fprintln(h, host)
+	}
+	fmt.Fprintln(h, images)
+	return fmt.Sprintf("%d-%x", pipelineVersion, h.Sum(nil)[:6])
+}
+
+// Version identifies the HTML r renders for posts with the trusted or the
+// strict policy, see BlogPost.RenderVersion. HTML is rendered again when the
+// author of a post gains or loses the trust of the policy.
+func (r *Renderer) Version(trusted bool) string {
+	if trusted {
+		return r.version + "t"
+	}
+	return r.version + "s"
+}
+
+// Content renders a full markdown document to sanitised HTML using the
+// strict policy.
+func (r *Renderer) Content(source string) (template.HTML, error) {
+	return r.content(source, r.strict)
+}
+
+// TrustedContent is Content with the trusted policy, which additionally
+// permits iframes from the approved hosts.
+func (r *Renderer) TrustedContent(source string) (template.HTML, error) {
+	return r.content(source, r.trusted)
+}
+
+func (r *Renderer) content(source string, policy *bluemonday.Policy, opts ...parser.ParseOption) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := r.md.Convert([]byte(source), &buf, opts...); err != nil {
+		return "", err
+	}
+	return template.HTML(policy.SanitizeBytes(buf.Bytes())), nil
+}
+
+// Title renders inline markdown (emphasis, code spans, links) without
+// wrapping the result in a paragraph, so it can be placed inside headings.
+func (r *Renderer) Title(source string) (template.HTML, error) {
+	src := []byte(source)
+	doc := r.md.Parser().Parse(text.NewReader(src))
+
+	for n := doc.FirstChild(); n != nil; {
+		next := n.NextSibling()
+		if p, ok := n.(*ast.Paragraph); ok {
+			tb := ast.NewTextBlock()
+			for c := p.FirstChild(); c != nil; {
+				nc := c.NextSibling()
+				tb.AppendChild(tb, c)
+				c = nc
+			}
+			doc.ReplaceChild(doc, p, tb)
+		}
+		n = next
+	}
+
+	var buf bytes.Buffer
+	if err := r.md.Renderer().Render(&buf, src, doc); err != nil {
+		return "", err
+	}
+	return template.HTML(r.strict.SanitizeBytes(buf.Bytes())), nil
+}
+
+// Excerpt renders the part of source shown on listings: everything before
+// MoreMarker when present, otherwise the whole document truncated to a few
+// hundred visible characters. The strict policy is used.
+func (r *Renderer) Excerpt(source string) (template.HTML, error) {
+	return r.excerpt(source, r.strict)
+}
+
+func (r *Renderer) excerpt(source string, policy *bluemonday.Policy) (template.HTML, error) {
+	ctx := parser.NewContext()
+	ctx.Set(skipTOC, true)
+
+	if at, found := r.moreMarker(source); found {
+		return r.content(source[:at], policy, parser.WithContext(ctx))
+	}
+
+	rendered, err := r.content(source, policy, parser.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	return Truncate(rendered, excerptLength), nil
+}
+
+// moreMarker finds the offset of the first MoreMarker standing as an HTML
+// block of its own, so markers inside code or inline text are left alone.
+func (r *Renderer) moreMarker(source string) (int, bool) {
+	src := []byte(source)
+	doc := r.md.Parser().Parse(text.NewReader(src))
+
+	at, found := 0, false
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		block, ok := n.(*ast.HTMLBlock)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		var buf bytes.Buffer
+		for i := 0; i < block.Lines().Len(); i++ {
+			line := block.Lines().At(i)
+			buf.Write(line.Value(src))
+		}
+		if strings.TrimSpace(buf.String()) != MoreMarker {
+			return ast.WalkContinue, nil
+		}
+		at, found = block.Lines().At(0).Start, true
+		return ast.WalkStop, nil
+	})
+	return at, found
+}
+
+// Prepare fills in the rendered fields of blogPost in place. Fields which
+// already hold output pre-rendered by the same version of the renderer are
+// left untouched, output of other versions is rendered again.
+func (r *Renderer) Prepare(ctx context.Context, blogPost *models.BlogPost) {
+	r.prepare(ctx, blogPost, r.policy.trusts(ctx, blogPost))
+}
+
+func (r *Renderer) prepare(ctx context.Context, blogPost *models.BlogPost, trusted bool) {
+	ctx, span := tracing.Start(ctx, "render.Prepare", attribute.String("post.id", blogPost.ID.String()))
+	defer span.End()
+
+	if version := r.Version(trusted); blogPost.RenderVersion != version {
+		blogPost.TitleHTML, blogPost.ContentHTML, blogPost.Excerpt = "", "", ""
+		blogPost.RenderVersion = version
+	}
+
+	policy := r.strict
+	if trusted {
+		policy = r.trusted
+	}
+
+	if blogPost.ContentHTML == "" {
+		done := r.stage(ctx, "content")
+		contentHTML, err := r.content(blogPost.Content, policy)
+		done(err)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error converting blog post content to HTML", "id", blogPost.ID, "err", err)
+		} else {
+			blogPost.ContentHTML = contentHTML
+		}
+	}
+
+	if blogPost.TitleHTML == "" {
+		done := r.stage(ctx, "title")
+		titleHTML, err := r.Title(blogPost.Title)
+		done(err)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error converting blog post title to HTML", "id", blogPost.ID, "err", err)
+		} else {
+			blogPost.TitleHTML = titleHTML
+		}
+	}
+
+	if blogPost.Excerpt == "" {
+		var excerpt template.HTML
+		var err error
+		done := r.stage(ctx, "excerpt")
+		if blogPost.Summary != "" {
+			excerpt, err = r.content(blogPost.Summary, policy)
+		} else {
+			excerpt, err = r.excerpt(blogPost.Content, policy)
+		}
+		done(err)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error converting blog post excerpt to HTML", "id", blogPost.ID, "err", err)
+		} else {
+			blogPost.Excerpt = excerpt
+		}
+	}
+
+	blogPost.WordCount = countWords(blogPost.ContentHTML)
+	blogPost.ReadingTime = readingTime(blogPost.WordCount)
+}
+
+// stage starts timing and tracing a stage of Prepare. The returned function
+// ends it with the error of the stage.
+func (r *Renderer) stage(ctx context.Context, name string) func(error) {
+	start := time.Now()
+	_, span := tracing.Start(ctx, "render."+name)
+	return func(err error) {
+		if r.timings != nil {
+			r.timings(name, time.Since(start))
+		}
+		tracing.End(span, err)
+	}
+}
+
+// Posts returns rendered copies of blogPosts, leaving the originals as they
+// came from the store. The policy is asked to trust each author once.
+func (r *Renderer) Posts(ctx context.Context, blogPosts []*models.BlogPost) []*models.BlogPost {
+	rendered := make([]*models.BlogPost, len(blogPosts))
+	trusted := map[uuid.UUID]bool{}
+
+	for i := range blogPosts {
+		post := *blogPosts[i]
+		trusts, ok := trusted[post.AuthorID]
+		if !ok {
+			trusts = r.policy.trusts(ctx, &post)
+			trusted[post.AuthorID] = trusts
+		}
+		r.prepare(ctx, &post, trusts)
+		rendered[i] = &post
+	}
+
+	return rendered
+}
@@ -0,0 +1,200 @@
+package render_test
+
+import (
+	"context"
+	"html/template"
+	"microblog/pkg/models"
+	"microblog/pkg/render"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContent(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Content("# Heading\n\nSome *text*")
+	require.NoError(t, err)
+
+	assert.Contains(t, got, `<h1 id="heading">Heading `)
+	assert.Contains(t, got, "<p>Some <em>text</em></p>")
+}
+
+func TestTitleIsNotWrappedInParagraph(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Title("Hello `world`")
+	require.NoError(t, err)
+
+	assert.Equal(t, template.HTML("Hello <code>world</code>"), got)
+}
+
+func TestExcerptStopsAtMoreMarker(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Excerpt("First paragraph.\n\n<!--more-->\n\nSecond paragraph.")
+	require.NoError(t, err)
+
+	assert.Equal(t, template.HTML("<p>First paragraph.</p>\n"), got)
+}
+
+func TestExcerptIgnoresMoreMarkerInCode(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Excerpt("Use `<!--more-->` to cut.\n\n```html\n<!--more-->\n```\n\nEnd.\n\n<!--more-->\n\nRest.")
+	require.NoError(t, err)
+
+	assert.Contains(t, string(got), "<code>&lt;!--more--&gt;</code> to cut.")
+	assert.Contains(t, string(got), "End.")
+	assert.NotContains(t, string(got), "Rest.")
+}
+
+func TestExcerptTruncatesLongContent(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Excerpt(strings.Repeat("word ", 200))
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasSuffix(string(got), "…</p>"))
+	assert.Less(t, len(got), 400)
+}
+
+func TestPrepareUsesSummary(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	blogPost := &models.BlogPost{Title: "title", Content: "content", Summary: "a *short* summary"}
+	r.Prepare(context.Background(), blogPost)
+
+	assert.Equal(t, template.HTML("<p>a <em>short</em> summary</p>\n"), blogPost.Excerpt)
+}
+
+func TestExcerptEmptyContent(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Excerpt("")
+	require.NoError(t, err)
+
+	assert.Empty(t, got)
+}
+
+func TestPrepareKeepsPreRenderedOutput(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	blogPost := &models.BlogPost{
+		Title:         "title",
+		Content:       "content",
+		ContentHTML:   template.HTML("<p>stored</p>"),
+		RenderVersion: r.Version(false),
+	}
+	r.Prepare(context.Background(), blogPost)
+
+	assert.Equal(t, template.HTML("<p>stored</p>"), blogPost.ContentHTML)
+	assert.Equal(t, template.HTML("title"), blogPost.TitleHTML)
+	assert.Equal(t, template.HTML("<p>content</p>\n"), blogPost.Excerpt)
+}
+
+func TestPrepareRendersStaleOutputAgain(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	for _, version := range []string{"", "0-old"} {
+		blogPost := &models.BlogPost{
+			Title:         "title",
+			Content:       "<script>alert(1)</script>content",
+			TitleHTML:     template.HTML("<b>stored</b>"),
+			ContentHTML:   template.HTML("<script>alert(1)</script><p>content</p>"),
+			Excerpt:       template.HTML("<script>alert(1)</script>"),
+			RenderVersion: version,
+		}
+		r.Prepare(context.Background(), blogPost)
+
+		assert.Equal(t, template.HTML("title"), blogPost.TitleHTML)
+		assert.NotContains(t, blogPost.ContentHTML, "<script>")
+		assert.NotContains(t, blogPost.Excerpt, "<script>")
+		assert.Equal(t, r.Version(false), blogPost.RenderVersion)
+	}
+
+	assert.NotEqual(t, r.Version(false), render.New(render.Policy{IframeHosts: []string{"www.youtube-nocookie.com"}}).Version(false),
+		"changes to the policy change the version")
+}
+
+func TestPrepareRendersAgainWhenTrustChanges(t *testing.T) {
+	trusted := true
+	r := render.New(render.Policy{
+		IframeHosts: []string{"www.youtube-nocookie.com"},
+		Trusted:     func(context.Context, *models.BlogPost) bool { return trusted },
+	})
+
+	blogPost := &models.BlogPost{Title: "embed", Content: `<iframe src="https://www.youtube-nocookie.com/embed/abc"></iframe>`}
+	r.Prepare(context.Background(), blogPost)
+	require.Contains(t, blogPost.ContentHTML, "<iframe")
+	assert.Equal(t, r.Version(true), blogPost.RenderVersion)
+
+	// the author lost the trust of the policy after the HTML was persisted
+	trusted = false
+	r.Prepare(context.Background(), blogPost)
+	assert.NotContains(t, blogPost.ContentHTML, "<iframe")
+	assert.Equal(t, r.Version(false), blogPost.RenderVersion)
+}
+
+func TestPostsAsksTrustOncePerAuthor(t *testing.T) {
+	asked := map[uuid.UUID]int{}
+	r := render.New(render.Policy{Trusted: func(_ context.Context, bp *models.BlogPost) bool {
+		asked[bp.AuthorID]++
+		return true
+	}})
+
+	ann, bob := uuid.New(), uuid.New()
+	r.Posts(context.Background(), []*models.BlogPost{
+		{Title: "one", AuthorID: ann},
+		{Title: "two", AuthorID: bob},
+		{Title: "three", AuthorID: ann},
+	})
+
+	assert.Equal(t, map[uuid.UUID]int{ann: 1, bob: 1}, asked)
+}
+
+func TestPostsDoesNotModifySource(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	source := &models.BlogPost{Title: "*title*", Content: "content"}
+	got := r.Posts(context.Background(), []*models.BlogPost{source})
+
+	require.Len(t, got, 1)
+	assert.Equal(t, template.HTML("<em>title</em>"), got[0].TitleHTML)
+	assert.Equal(t, template.HTML("<p>content</p>\n"), got[0].ContentHTML)
+	assert.Empty(t, source.TitleHTML)
+	assert.Empty(t, source.ContentHTML)
+}
+
+func TestContentHighlightsCode(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Content("```go {2}\npackage main\nfunc main() {}\n```")
+	require.NoError(t, err)
+
+	assert.Contains(t, got, `<pre class="chroma"><code class="language-go">`)
+	assert.Contains(t, got, `<span class="kn">package</span>`)
+	assert.Contains(t, got, `<span class="line hl"><span class="ln">2</span>`)
+	assert.NotContains(t, got, "style=")
+}
+
+func TestContentHighlightsUnknownLanguageAsPlainText(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Content("```notalanguage\n<b>x</b>\n```")
+	require.NoError(t, err)
+
+	assert.Contains(t, got, "&lt;b&gt;x&lt;/b&gt;")
+	assert.NotContains(t, got, `class="ln"`)
+}
+
+func TestHighlightCSS(t *testing.T) {
+	css := string(render.HighlightCSS())
+
+	assert.Contains(t, css, ".chroma .kn")
+	assert.Contains(t, css, ".chroma .hl")
+	assert.Contains(t, css, "@media (prefers-color-scheme: dark)")
+}
@@ -0,0 +1,106 @@
+package render
+
+import (
+	"context"
+	"encoding/base64"
+	"microblog/pkg/models"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Policy controls which raw HTML embedded in markdown survives sanitising.
+// Everything outside the allow-list is stripped.
+type Policy struct {
+	// IframeHosts lists the hosts trusted posts may embed iframes from,
+	// e.g. "www.youtube-nocookie.com". Only https sources are allowed.
+	IframeHosts []string
+	// Trusted reports whether a post's author may use the trusted policy.
+	// When nil no post is trusted. Renderer.Posts asks once for each author.
+	Trusted func(context.Context, *models.BlogPost) bool
+}
+
+func (p Policy) trusts(ctx context.Context, blogPost *models.BlogPost) bool {
+	return p.Trusted != nil && p.Trusted(ctx, blogPost)
+}
+
+// strictPolicy is the allow-list applied to every post: the markup goldmark
+// produces for GFM plus the handful of harmless inline tags authors use.
+func strictPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+
+	// fenced code blocks carry their language as a class, and highlighted
+	// blocks carry chroma's token classes
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	p.AllowAttrs("class").Matching(highlightClasses()).OnElements("pre", "code", "span")
+
+	// heading anchors, the table of contents and footnotes
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^(heading-anchor|footnote-ref|footnote-backref)$`)).OnElements("a")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^toc$`)).OnElements("nav")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^footnotes$`)).OnElements("div", "section")
+	p.AllowAttrs("role").Matching(regexp.MustCompile(`^doc-(noteref|backlink|endnotes|endnote)$`)).OnElements("a", "div", "section", "li")
+
+	// MathML produced from $...$ and $$...$$
+	p.AllowNoAttrs().OnElements("math", "semantics", "annotation", "mrow", "mi", "mn", "mo", "mtext",
+		"mspace", "msub", "msup", "msubsup", "mfrac", "msqrt", "mroot", "munder", "mover", "munderover", "merror")
+	p.AllowAttrs("xmlns").Matching(regexp.MustCompile(`^http://www\.w3\.org/1998/Math/MathML$`)).OnElements("math")
+	p.AllowAttrs("display").Matching(regexp.MustCompile(`^(block|inline)$`)).OnElements("math")
+	p.AllowAttrs("encoding").Matching(regexp.MustCompile(`^application/x-tex$`)).OnElements("annotation")
+	p.AllowAttrs("mathvariant").Matching(regexp.MustCompile(`^(normal|bold|italic|double-struck|script)$`)).OnElements("mi")
+	p.AllowAttrs("stretchy").Matching(regexp.MustCompile(`^true$`)).OnElements("mo")
+	p.AllowAttrs("accent").Matching(regexp.MustCompile(`^true$`)).OnElements("mover")
+	p.AllowAttrs("width").Matching(regexp.MustCompile(`^-?[0-9.]+em$`)).OnElements("mspace")
+	p.AllowAttrs("linebreak").Matching(regexp.MustCompile(`^newline$`)).OnElements("mspace")
+
+	// rendered diagrams are inlined as SVG images, unrendered ones as code
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^diagram$`)).OnElements("figure", "pre")
+	p.AllowURLSchemeWithCustomPolicy("data", func(u *url.URL) bool {
+		data, ok := strings.CutPrefix(u.Opaque, "image/svg+xml;base64,")
+		if !ok || u.RawQuery != "" || u.Fragment != "" {
+			return false
+		}
+		_, err := base64.StdEncoding.DecodeString(data)
+		return err == nil
+	})
+
+	// responsive images from the media library
+	mediaURL := `/media/[0-9a-f]{64}/[\w.-]+ \d+w`
+	p.AllowNoAttrs().OnElements("picture")
+	p.AllowAttrs("srcset").Matching(regexp.MustCompile(`^`+mediaURL+`(, `+mediaURL+`)*$`)).OnElements("img", "source")
+	p.AllowAttrs("sizes").Matching(regexp.MustCompile(`^[\w\s(),.:-]+$`)).OnElements("img", "source")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^image/(webp|jpeg|png)$`)).OnElements("source")
+	p.AllowAttrs("loading").Matching(regexp.MustCompile(`^(lazy|eager)$`)).OnElements("img")
+	p.AllowAttrs("decoding").Matching(regexp.MustCompile(`^(async|sync|auto)$`)).OnElements("img")
+
+	// GFM task lists
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^(|checked|disabled)$`)).OnElements("input")
+
+	return p
+}
+
+// trustedPolicy extends the strict policy with iframes from approved hosts.
+func trustedPolicy(iframeHosts []string) *bluemonday.Policy {
+	p := strictPolicy()
+	if len(iframeHosts) == 0 {
+		return p
+	}
+
+	quoted := make([]string, len(iframeHosts))
+	for i, host := range iframeHosts {
+		quoted[i] = regexp.QuoteMeta(strings.ToLower(host))
+	}
+	src := regexp.MustCompile(`^https://(` + strings.Join(quoted, "|") + `)/[^\s"'<>]*$`)
+
+	p.AllowAttrs("src").Matching(src).OnElements("iframe")
+	p.AllowAttrs("width", "height").Matching(bluemonday.Number).OnElements("iframe")
+	p.AllowAttrs("title").Matching(bluemonday.Paragraph).OnElements("iframe")
+	p.AllowAttrs("allowfullscreen").Matching(regexp.MustCompile(`^(|allowfullscreen|true)$`)).OnElements("iframe")
+	p.AllowAttrs("loading").Matching(regexp.MustCompile(`^(lazy|eager)$`)).OnElements("iframe")
+	p.AllowAttrs("allow").Matching(regexp.MustCompile(`^[a-z\-; ]*$`)).OnElements("iframe")
+	p.AllowAttrs("frameborder").Matching(bluemonday.Number).OnElements("iframe")
+
+	return p
+}
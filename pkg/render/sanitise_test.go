@@ -0,0 +1,156 @@
+package render_test
+
+import (
+	"context"
+	"microblog/pkg/models"
+	"microblog/pkg/render"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+)
+
+var trustedRenderer = render.New(render.Policy{
+	IframeHosts: []string{"www.youtube-nocookie.com"},
+	Trusted:     func(_ context.Context, bp *models.BlogPost) bool { return bp.Name == "trusted" },
+})
+
+func TestContentStripsScripts(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Content("hello <script>alert(1)</script> <img src=x onerror=alert(1)>")
+	require.NoError(t, err)
+
+	assert.NotContains(t, got, "<script")
+	assert.NotContains(t, got, "onerror")
+	assert.Contains(t, got, "hello")
+}
+
+func TestContentStripsJavascriptLinks(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Content("[click](javascript:alert(1))")
+	require.NoError(t, err)
+
+	assert.NotContains(t, got, "javascript:")
+}
+
+func TestTitleStripsHTML(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Title(`<img src=x onerror=alert(1)>Title`)
+	require.NoError(t, err)
+
+	assert.NotContains(t, got, "onerror")
+	assert.Contains(t, got, "Title")
+}
+
+func TestContentKeepsCodeLanguageAndTaskLists(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Content("```go\nfmt.Println()\n```\n\n- [x] done")
+	require.NoError(t, err)
+
+	assert.Contains(t, got, `class="language-go"`)
+	assert.Contains(t, got, `type="checkbox"`)
+}
+
+func TestIframePolicy(t *testing.T) {
+	approved := `<iframe src="https://www.youtube-nocookie.com/embed/abc" width="560" height="315"></iframe>`
+	unapproved := `<iframe src="https://evil.example.com/embed/abc"></iframe>`
+
+	tests := []struct {
+		name     string
+		post     *models.BlogPost
+		wantSrc  bool
+		wantEvil bool
+	}{
+		{
+			name:    "trusted author keeps approved host",
+			post:    &models.BlogPost{Name: "trusted", Content: approved + "\n\n" + unapproved},
+			wantSrc: true,
+		},
+		{
+			name: "untrusted author loses all iframes",
+			post: &models.BlogPost{Name: "untrusted", Content: approved + "\n\n" + unapproved},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trustedRenderer.Prepare(context.Background(), tt.post)
+
+			got := string(tt.post.ContentHTML)
+			assert.Equal(t, tt.wantSrc, strings.Contains(got, "https://www.youtube-nocookie.com/embed/abc"))
+			assert.NotContains(t, got, "evil.example.com")
+		})
+	}
+}
+
+func FuzzContent(f *testing.F) {
+	for _, seed := range []string{
+		"plain *markdown*",
+		"<script>alert(1)</script>",
+		"<a href=\"javascript:alert(1)\">x</a>",
+		"<img src=x onerror=alert(1)>",
+		"<svg><script>alert(1)</script></svg>",
+		"<iframe src=\"https://www.youtube-nocookie.com/embed/abc\"></iframe>",
+		"<iframe src=\"https://www.youtube-nocookie.com.evil.com/\"></iframe>",
+		"[x](javascript&#58;alert(1))",
+		"<div style=\"background:url(javascript:alert(1))\">x</div>",
+		"<<script>script>alert(1)<</script>/script>",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, source string) {
+		bp := &models.BlogPost{Name: "trusted", Title: source, Content: source}
+		trustedRenderer.Prepare(context.Background(), bp)
+
+		assertSafe(t, string(bp.ContentHTML))
+		assertSafe(t, string(bp.TitleHTML))
+		assertSafe(t, string(bp.Excerpt))
+	})
+}
+
+// assertSafe parses fragment the way a browser would and fails on any
+// element or attribute that can execute script.
+func assertSafe(t *testing.T, fragment string) {
+	t.Helper()
+
+	z := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		tok := z.Token()
+		switch tok.Data {
+		case "script", "style", "object", "embed", "svg", "form", "base", "meta", "link":
+			t.Fatalf("disallowed element <%s> in %q", tok.Data, fragment)
+		}
+
+		for _, attr := range tok.Attr {
+			key := strings.ToLower(attr.Key)
+			val := strings.ToLower(strings.TrimSpace(attr.Val))
+			if strings.HasPrefix(key, "on") || key == "style" {
+				t.Fatalf("disallowed attribute %s on <%s> in %q", attr.Key, tok.Data, fragment)
+			}
+			if strings.HasPrefix(val, "javascript:") || strings.HasPrefix(val, "vbscript:") {
+				t.Fatalf("disallowed URL %q in %q", attr.Val, fragment)
+			}
+			if strings.HasPrefix(val, "data:") && !strings.HasPrefix(val, "data:image/svg+xml;base64,") {
+				t.Fatalf("disallowed URL %q in %q", attr.Val, fragment)
+			}
+			if tok.Data == "iframe" && key == "src" && !strings.HasPrefix(val, "https://www.youtube-nocookie.com/") {
+				t.Fatalf("iframe from unapproved host %q in %q", attr.Val, fragment)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package render
+
+import (
+	"html/template"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// wordsPerMinute is the reading speed used for reading time estimates.
+const wordsPerMinute = 200
+
+// uncountedElements hold text which is not read as part of the post: the
+// table of contents and the LaTeX source kept alongside MathML.
+var uncountedElements = map[string]bool{"nav": true, "annotation": true}
+
+// countWords counts the words a reader sees in a rendered fragment.
+// Uncounted elements are skipped and only tokens containing a letter or digit
+// count, which leaves out heading anchors and footnote back links.
+func countWords(fragment template.HTML) int {
+	z := html.NewTokenizer(strings.NewReader(string(fragment)))
+
+	words := 0
+	skipping := 0
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return words
+		case html.StartTagToken:
+			if name, _ := z.TagName(); uncountedElements[string(name)] {
+				skipping++
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); uncountedElements[string(name)] && skipping > 0 {
+				skipping--
+			}
+		case html.TextToken:
+			if skipping > 0 {
+				continue
+			}
+			for _, field := range strings.Fields(string(z.Text())) {
+				if strings.IndexFunc(field, isWordRune) >= 0 {
+					words++
+				}
+			}
+		}
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// readingTime estimates the minutes needed to read words, rounding up so any
+// non-empty post takes at least a minute.
+func readingTime(words int) int {
+	return (words + wordsPerMinute - 1) / wordsPerMinute
+}
@@ -0,0 +1,182 @@
+package render
+
+import (
+	"bytes"
+	"strings"
+
+	meta "github.com/yuin/goldmark-meta"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"golang.org/x/net/html"
+)
+
+// TOCMarker is replaced with the table of contents when it appears on a line
+// of its own. Setting "toc: true" in the front matter places the table at the
+// top of the post instead.
+const TOCMarker = "[[toc]]"
+
+// maxTOCLevel is the deepest heading level listed in the table of contents.
+const maxTOCLevel = 4
+
+// skipTOC is set on the parser context when rendering excerpts, where a
+// table of contents would only get in the way.
+var skipTOC = parser.NewContextKey()
+
+var KindTableOfContents = ast.NewNodeKind("TableOfContents")
+
+type tocEntry struct {
+	level int
+	id    string
+	text  string
+}
+
+// TableOfContents is a block node listing the headings of a document.
+type TableOfContents struct {
+	ast.BaseBlock
+	entries []tocEntry
+}
+
+func (n *TableOfContents) Kind() ast.NodeKind {
+	return KindTableOfContents
+}
+
+func (n *TableOfContents) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// headingTransformer adds permalink anchors to headings with an id and
+// builds the table of contents when one is requested.
+type headingTransformer struct{}
+
+func (t *headingTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	var entries []tocEntry
+	var marker ast.Node
+
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch node := n.(type) {
+		case *ast.Heading:
+			id, ok := node.AttributeString("id")
+			if !ok {
+				return ast.WalkSkipChildren, nil
+			}
+			idBytes, ok := id.([]byte)
+			if !ok {
+				return ast.WalkSkipChildren, nil
+			}
+
+			if node.Level <= maxTOCLevel {
+				entries = append(entries, tocEntry{
+					level: node.Level,
+					id:    string(idBytes),
+					text:  string(node.Text(source)),
+				})
+			}
+
+			anchor := ast.NewLink()
+			anchor.Destination = append([]byte("#"), idBytes...)
+			anchor.SetAttributeString("class", []byte("heading-anchor"))
+			anchor.AppendChild(anchor, ast.NewString([]byte("#")))
+			node.AppendChild(node, ast.NewString([]byte(" ")))
+			node.AppendChild(node, anchor)
+			return ast.WalkSkipChildren, nil
+
+		case *ast.Paragraph:
+			if marker == nil && isTOCMarker(node, source) {
+				marker = node
+			}
+			return ast.WalkSkipChildren, nil
+		}
+
+		return ast.WalkContinue, nil
+	})
+
+	wanted := marker != nil || meta.Get(pc)["toc"] == true
+	if pc.Get(skipTOC) != nil || len(entries) == 0 || !wanted {
+		if marker != nil {
+			marker.Parent().RemoveChild(marker.Parent(), marker)
+		}
+		return
+	}
+
+	toc := &TableOfContents{entries: entries}
+	switch {
+	case marker != nil:
+		marker.Parent().ReplaceChild(marker.Parent(), marker, toc)
+	case doc.FirstChild() != nil:
+		doc.InsertBefore(doc, doc.FirstChild(), toc)
+	default:
+		doc.AppendChild(doc, toc)
+	}
+}
+
+func isTOCMarker(p *ast.Paragraph, source []byte) bool {
+	lines := p.Lines()
+	if lines.Len() != 1 {
+		return false
+	}
+	line := lines.At(0)
+	return string(bytes.TrimSpace(line.Value(source))) == TOCMarker
+}
+
+type tocRenderer struct{}
+
+func (r *tocRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindTableOfContents, r.render)
+}
+
+func (r *tocRenderer) render(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*TableOfContents)
+
+	minLevel := n.entries[0].level
+	for _, e := range n.entries {
+		minLevel = min(minLevel, e.level)
+	}
+
+	var b strings.Builder
+	b.WriteString("<nav class=\"toc\">\n<ul>\n")
+	depth := 0
+	for i, e := range n.entries {
+		d := e.level - minLevel
+		if i > 0 {
+			if d > depth {
+				// never skip a level, otherwise the list is invalid
+				d = depth + 1
+				b.WriteString("\n<ul>\n")
+			} else {
+				b.WriteString("</li>\n")
+				for ; depth > d; depth-- {
+					b.WriteString("</ul>\n</li>\n")
+				}
+			}
+		} else {
+			d = 0
+		}
+		depth = d
+
+		b.WriteString(`<li><a href="#`)
+		b.WriteString(html.EscapeString(e.id))
+		b.WriteString(`">`)
+		b.WriteString(html.EscapeString(e.text))
+		b.WriteString("</a>")
+	}
+	b.WriteString("</li>\n")
+	for ; depth > 0; depth-- {
+		b.WriteString("</ul>\n</li>\n")
+	}
+	b.WriteString("</ul>\n</nav>\n")
+
+	_, _ = w.WriteString(b.String())
+	return ast.WalkSkipChildren, nil
+}
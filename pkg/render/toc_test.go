@@ -0,0 +1,113 @@
+package render_test
+
+import (
+	"context"
+	"microblog/pkg/models"
+	"microblog/pkg/render"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const tocSource = `Intro
+
+## One
+
+### One point one
+
+## Two
+`
+
+func TestHeadingAnchors(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Content("## Hello World")
+	require.NoError(t, err)
+
+	assert.Contains(t, got, `<h2 id="hello-world">Hello World <a href="#hello-world" class="heading-anchor"`)
+}
+
+func TestTOCNotAddedByDefault(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Content(tocSource)
+	require.NoError(t, err)
+
+	assert.NotContains(t, got, `<nav class="toc">`)
+}
+
+func TestTOCMarker(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Content(strings.Replace(tocSource, "Intro", "Intro\n\n[[toc]]", 1))
+	require.NoError(t, err)
+
+	html := string(got)
+	nav := strings.Index(html, `<nav class="toc">`)
+	require.GreaterOrEqual(t, nav, 0)
+	assert.Greater(t, nav, strings.Index(html, "<p>Intro</p>"))
+	assert.NotContains(t, html, "[[toc]]")
+	assert.Contains(t, html, `<li><a href="#one" rel="nofollow">One</a>
+<ul>
+<li><a href="#one-point-one" rel="nofollow">One point one</a></li>
+</ul>
+</li>
+<li><a href="#two" rel="nofollow">Two</a></li>`)
+}
+
+func TestTOCFrontMatter(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Content("---\ntoc: true\n---\n" + tocSource)
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(string(got), `<nav class="toc">`))
+	assert.NotContains(t, got, "toc: true")
+}
+
+func TestTOCLeftOutOfExcerpt(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Excerpt("[[toc]]\n\n" + tocSource)
+	require.NoError(t, err)
+
+	assert.NotContains(t, got, "toc")
+	assert.Contains(t, got, "<p>Intro</p>")
+}
+
+func TestFootnotes(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	got, err := r.Content("Claim[^1]\n\n[^1]: Source.")
+	require.NoError(t, err)
+
+	assert.Contains(t, got, `<sup id="fnref:1"><a href="#fn:1" class="footnote-ref" role="doc-noteref"`)
+	assert.Contains(t, got, `<div class="footnotes" role="doc-endnotes">`)
+	assert.Contains(t, got, `<li id="fn:1" role="doc-endnote">`)
+}
+
+func TestReadingStats(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	blogPost := &models.BlogPost{
+		Title:   "title",
+		Content: "[[toc]]\n\n## Heading\n\n" + strings.Repeat("word ", 399) + "[^1]\n\n[^1]: note",
+	}
+	r.Prepare(context.Background(), blogPost)
+
+	// heading + 399 words + the footnote number + the footnote text
+	assert.Equal(t, 402, blogPost.WordCount)
+	assert.Equal(t, 3, blogPost.ReadingTime)
+}
+
+func TestReadingStatsEmptyPost(t *testing.T) {
+	r := render.New(render.Policy{})
+
+	blogPost := &models.BlogPost{Title: "title"}
+	r.Prepare(context.Background(), blogPost)
+
+	assert.Equal(t, 0, blogPost.WordCount)
+	assert.Equal(t, 0, blogPost.ReadingTime)
+}
@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"context"
+	"microblog/pkg/models"
+)
+
+// AuditStore keeps the audit log. It can only be appended to.
+type AuditStore interface {
+	// Append assigns the entry its ID.
+	Append(ctx context.Context, entry *models.AuditEntry) error
+	// List returns the entries passing filter, newest first.
+	List(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEntry, error)
+}
@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"microblog/pkg/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FileMediaStore keeps media on the local filesystem. Each file is written
+// to Dir/<first two characters of the hash>/<hash> with its metadata in a
+// JSON file alongside.
+type FileMediaStore struct {
+	Dir string
+	mu  sync.Mutex
+}
+
+func NewFileMediaStore(dir string) (*FileMediaStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileMediaStore{Dir: dir}, nil
+}
+
+func (s *FileMediaStore) Create(media *models.Media, data []byte) (*models.Media, error) {
+	media.Hash = hashMedia(data)
+	media.Size = int64(len(data))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.readMeta(media.Hash)
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.Is(err, ErrMediaNotFound) {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path(media.Hash)), 0o755); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(s.path(media.Hash), data); err != nil {
+		return nil, err
+	}
+
+	// metadata is written last so a partial upload is never listed
+	meta, err := json.Marshal(media)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(s.path(media.Hash)+".json", meta); err != nil {
+		return nil, err
+	}
+
+	return media, nil
+}
+
+func (s *FileMediaStore) Get(hash string) (*models.Media, []byte, error) {
+	media, err := s.readMeta(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := os.ReadFile(s.path(hash))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return media, data, nil
+}
+
+func (s *FileMediaStore) List() ([]*models.Media, error) {
+	return s.find(func(media *models.Media) bool { return media.Original == "" })
+}
+
+func (s *FileMediaStore) Variants(hash string) ([]*models.Media, error) {
+	return s.find(func(media *models.Media) bool { return media.Original == hash })
+}
+
+func (s *FileMediaStore) find(match func(*models.Media) bool) ([]*models.Media, error) {
+	mediaList := []*models.Media{}
+
+	err := filepath.WalkDir(s.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+
+		media, err := s.readMeta(strings.TrimSuffix(d.Name(), ".json"))
+		if err != nil {
+			return err
+		}
+		if match(media) {
+			mediaList = append(mediaList, media)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(mediaList, func(i, j int) bool {
+		return mediaList[i].CreatedAt.After(mediaList[j].CreatedAt)
+	})
+	return mediaList, nil
+}
+
+func (s *FileMediaStore) path(hash string) string {
+	return filepath.Join(s.Dir, hash[:2], hash)
+}
+
+func (s *FileMediaStore) readMeta(hash string) (*models.Media, error) {
+	if !mediaHash.MatchString(hash) {
+		return nil, ErrMediaNotFound
+	}
+
+	meta, err := os.ReadFile(s.path(hash) + ".json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	media := &models.Media{}
+	if err := json.Unmarshal(meta, media); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
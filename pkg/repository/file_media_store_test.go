@@ -0,0 +1,71 @@
+package repository_test
+
+import (
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileMediaStoreCreateAndGet(t *testing.T) {
+	store, err := repository.NewFileMediaStore(t.TempDir())
+	require.NoError(t, err)
+
+	media, err := store.Create(&models.Media{Filename: "a.png", ContentType: "image/png", CreatedAt: time.Now().UTC()}, []byte("png"))
+	require.NoError(t, err)
+	assert.Equal(t, "8f8cbb7dcf46e0bc7d53265749a6c17d116093a6ba95e442764060c76fd4a86c", media.Hash)
+	assert.Equal(t, int64(3), media.Size)
+
+	got, data, err := store.Get(media.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("png"), data)
+	assert.Equal(t, "a.png", got.Filename)
+	assert.Equal(t, "image/png", got.ContentType)
+}
+
+func TestFileMediaStoreDeduplicates(t *testing.T) {
+	store, err := repository.NewFileMediaStore(t.TempDir())
+	require.NoError(t, err)
+
+	first, err := store.Create(&models.Media{Filename: "first.png", CreatedAt: time.Now().UTC()}, []byte("same"))
+	require.NoError(t, err)
+	second, err := store.Create(&models.Media{Filename: "second.png", CreatedAt: time.Now().UTC()}, []byte("same"))
+	require.NoError(t, err)
+
+	assert.Equal(t, first.Hash, second.Hash)
+	assert.Equal(t, "first.png", second.Filename)
+
+	all, err := store.List()
+	require.NoError(t, err)
+	assert.Len(t, all, 1)
+}
+
+func TestFileMediaStoreListNewestFirst(t *testing.T) {
+	store, err := repository.NewFileMediaStore(t.TempDir())
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	_, err = store.Create(&models.Media{Filename: "old", CreatedAt: now.Add(-time.Hour)}, []byte("old"))
+	require.NoError(t, err)
+	_, err = store.Create(&models.Media{Filename: "new", CreatedAt: now}, []byte("new"))
+	require.NoError(t, err)
+
+	all, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, "new", all[0].Filename)
+	assert.Equal(t, "old", all[1].Filename)
+}
+
+func TestFileMediaStoreGetNotFoundError(t *testing.T) {
+	store, err := repository.NewFileMediaStore(t.TempDir())
+	require.NoError(t, err)
+
+	for _, hash := range []string{"../../etc/passwd", "", "0967115f2813a3541eaef77de9d9d5773f1c0c04314b0bbfe4ff3b3b1c55b5d5"} {
+		_, _, err := store.Get(hash)
+		assert.ErrorIs(t, err, repository.ErrMediaNotFound, hash)
+	}
+}
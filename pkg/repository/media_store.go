@@ -0,0 +1,31 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"microblog/pkg/models"
+	"regexp"
+)
+
+var ErrMediaNotFound = errors.New("media not found")
+
+var mediaHash = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// MediaStore keeps uploaded media addressed by the hash of its content.
+type MediaStore interface {
+	// Create stores data and fills in the hash and size of media. Content
+	// which is already stored is not written again and the existing record
+	// is returned instead.
+	Create(media *models.Media, data []byte) (*models.Media, error)
+	Get(hash string) (*models.Media, []byte, error)
+	// List returns all uploaded media, newest first, leaving out variants.
+	List() ([]*models.Media, error)
+	// Variants returns the media derived from the original with hash.
+	Variants(hash string) ([]*models.Media, error)
+}
+
+func hashMedia(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
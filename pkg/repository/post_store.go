@@ -1,17 +1,36 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"microblog/pkg/models"
 
 	"github.com/google/uuid"
 )
 
+var ErrPostNotFound = errors.New("post not found")
+
+// PostStore persists blog posts. The context of the request a call is made
+// for is passed through, so calls are cancelled along with the request.
+// Lookups of a missing post return an empty post and ErrPostNotFound.
 type PostStore interface {
-	Create(*models.BlogPost) error
-	GetAll() ([]*models.BlogPost, error)
-	GetByID(id uuid.UUID) (*models.BlogPost, error)
-	GetByName(name string) (*models.BlogPost, error)
-	FetchLast10BlogPosts() ([]*models.BlogPost, error)
-	Delete(id uuid.UUID) error
-	Update(*models.BlogPost) error
+	Create(ctx context.Context, blogPost *models.BlogPost) error
+	GetAll(ctx context.Context) ([]*models.BlogPost, error)
+	GetByID(ctx context.Context, id uuid.UUID) (*models.BlogPost, error)
+	GetByName(ctx context.Context, name string) (*models.BlogPost, error)
+	FetchLast10BlogPosts(ctx context.Context) ([]*models.BlogPost, error)
+	// GetByAuthor returns the posts of a user, newest first.
+	GetByAuthor(ctx context.Context, authorID uuid.UUID) ([]*models.BlogPost, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	Update(ctx context.Context, blogPost *models.BlogPost) error
+}
+
+// Checker is implemented by stores backed by a service which can become
+// unavailable, for readiness checks. Both checks should be cheap enough to run
+// on every probe.
+type Checker interface {
+	Ping(ctx context.Context) error
+	// CheckSchema reports an error when the schema is missing changes the
+	// store relies on.
+	CheckSchema(ctx context.Context) error
 }
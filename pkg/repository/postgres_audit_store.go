@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"microblog/pkg/models"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type PostgresAuditStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresAuditStore(db *sql.DB) *PostgresAuditStore {
+	return &PostgresAuditStore{DB: db}
+}
+
+const auditColumns = "audit_id, created_at, actor_id, actor_handle, audit_via, audit_action, audit_target, audit_ip, request_id, audit_before, audit_after"
+
+func (p *PostgresAuditStore) Append(ctx context.Context, entry *models.AuditEntry) error {
+	var actorID uuid.NullUUID
+	if entry.ActorID != uuid.Nil {
+		actorID = uuid.NullUUID{UUID: entry.ActorID, Valid: true}
+	}
+	return p.DB.QueryRowContext(ctx, "INSERT INTO audit_log (created_at, actor_id, actor_handle, audit_via, audit_action, audit_target, audit_ip, request_id, audit_before, audit_after) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING audit_id;",
+		entry.Time, actorID, entry.ActorHandle, entry.Via, entry.Action, entry.Target, entry.IP, entry.RequestID, entry.Before, entry.After).Scan(&entry.ID)
+}
+
+func (p *PostgresAuditStore) List(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEntry, error) {
+	var where []string
+	var args []any
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.ActorHandle != "" {
+		add("actor_handle = $%d", filter.ActorHandle)
+	}
+	if filter.Action != "" {
+		add("audit_action = $%d", filter.Action)
+	}
+	if filter.Target != "" {
+		add("audit_target = $%d", filter.Target)
+	}
+	if !filter.Since.IsZero() {
+		add("created_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("created_at < $%d", filter.Until)
+	}
+	if filter.BeforeID != 0 {
+		add("audit_id < $%d", filter.BeforeID)
+	}
+
+	query := "SELECT " + auditColumns + " FROM audit_log"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY audit_id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := p.DB.QueryContext(ctx, query+";", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*models.AuditEntry{}
+	for rows.Next() {
+		entry := &models.AuditEntry{}
+		var actorID uuid.NullUUID
+		err := rows.Scan(&entry.ID, &entry.Time, &actorID, &entry.ActorHandle, &entry.Via, &entry.Action, &entry.Target,
+			&entry.IP, &entry.RequestID, &entry.Before, &entry.After)
+		if err != nil {
+			return nil, err
+		}
+		entry.ActorID = actorID.UUID
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,75 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditStoreWithContainer(t *testing.T) {
+	store, cleanup := setupTestContainer(t)
+	defer cleanup()
+	audit := repository.NewPostgresAuditStore(store.DB)
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	created := &models.AuditEntry{Time: now.Add(-time.Hour), ActorID: uuid.New(), ActorHandle: "ann", Via: "session",
+		Action: models.AuditPostCreate, Target: "post:hello", IP: "192.0.2.1", RequestID: "req-1", After: `"Hello"`}
+	require.NoError(t, audit.Append(ctx, created))
+	failed := &models.AuditEntry{Time: now, ActorHandle: "bob", Action: models.AuditLoginFailed, Target: "user:bob", IP: "192.0.2.2"}
+	require.NoError(t, audit.Append(ctx, failed))
+	assert.Greater(t, failed.ID, created.ID)
+
+	all, err := audit.List(ctx, models.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, failed.ID, all[0].ID, "newest first")
+	assert.Equal(t, uuid.Nil, all[0].ActorID)
+	all[1].Time = all[1].Time.UTC()
+	assert.Equal(t, created, all[1])
+
+	for _, filter := range []models.AuditFilter{
+		{ActorHandle: "ann"},
+		{Action: models.AuditPostCreate},
+		{Target: "post:hello"},
+		{Until: now.Add(-time.Minute)},
+		{BeforeID: failed.ID},
+		{Limit: 1, Since: now.Add(-2 * time.Hour), ActorHandle: "ann"},
+	} {
+		entries, err := audit.List(ctx, filter)
+		require.NoError(t, err)
+		require.Len(t, entries, 1, "%+v", filter)
+		assert.Equal(t, created.ID, entries[0].ID)
+	}
+
+	// entries cannot be changed or removed
+	_, err = store.DB.ExecContext(ctx, "UPDATE audit_log SET actor_handle = 'mallory';")
+	assert.ErrorContains(t, err, "append-only")
+	_, err = store.DB.ExecContext(ctx, "DELETE FROM audit_log;")
+	assert.ErrorContains(t, err, "append-only")
+}
+
+func TestListAuditLogError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	audit := repository.NewPostgresAuditStore(db)
+	since := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`SELECT (.+) FROM audit_log WHERE actor_handle = \$1 AND audit_action = \$2 AND created_at >= \$3 ORDER BY audit_id DESC LIMIT \$4;`).
+		WithArgs("ann", models.AuditPostDelete, since, 50).
+		WillReturnError(errors.New("connection reset"))
+
+	_, err = audit.List(context.Background(), models.AuditFilter{ActorHandle: "ann", Action: models.AuditPostDelete, Since: since, Limit: 50})
+	assert.ErrorContains(t, err, "connection reset")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"microblog/pkg/models"
+)
+
+// PostgresMediaStore keeps media metadata in the media table and the content
+// in a Postgres large object.
+type PostgresMediaStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresMediaStore(db *sql.DB) *PostgresMediaStore {
+	return &PostgresMediaStore{DB: db}
+}
+
+func (p *PostgresMediaStore) Create(media *models.Media, data []byte) (*models.Media, error) {
+	media.Hash = hashMedia(data)
+	media.Size = int64(len(data))
+
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// rolling back on a conflict also drops the large object just created
+	var hash string
+	err = tx.QueryRow("INSERT INTO media VALUES ($1, $2, $3, $4, lo_from_bytea(0, $5), $6, $7, $8, $9) ON CONFLICT (media_hash) DO NOTHING RETURNING media_hash;",
+		media.Hash, media.Filename, media.ContentType, media.Size, data, media.CreatedAt, sql.NullString{String: media.Original, Valid: media.Original != ""}, media.Width, media.Height).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return scanMedia(p.DB.QueryRow("SELECT media_hash, media_filename, media_content_type, media_size, created_at, media_original, media_width, media_height FROM media WHERE media_hash = $1;", media.Hash))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+func (p *PostgresMediaStore) Get(hash string) (*models.Media, []byte, error) {
+	media, err := scanMedia(p.DB.QueryRow("SELECT media_hash, media_filename, media_content_type, media_size, created_at, media_original, media_width, media_height FROM media WHERE media_hash = $1;", hash))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var data []byte
+	err = p.DB.QueryRow("SELECT lo_get(media_oid) FROM media WHERE media_hash = $1;", hash).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return media, data, nil
+}
+
+func (p *PostgresMediaStore) List() ([]*models.Media, error) {
+	rows, err := p.DB.Query("SELECT media_hash, media_filename, media_content_type, media_size, created_at, media_original, media_width, media_height FROM media WHERE media_original IS NULL ORDER BY created_at DESC;")
+	if err != nil {
+		return nil, err
+	}
+	return scanMediaRows(rows)
+}
+
+func (p *PostgresMediaStore) Variants(hash string) ([]*models.Media, error) {
+	rows, err := p.DB.Query("SELECT media_hash, media_filename, media_content_type, media_size, created_at, media_original, media_width, media_height FROM media WHERE media_original = $1 ORDER BY media_width;", hash)
+	if err != nil {
+		return nil, err
+	}
+	return scanMediaRows(rows)
+}
+
+func scanMediaRows(rows *sql.Rows) ([]*models.Media, error) {
+	defer rows.Close()
+
+	mediaList := []*models.Media{}
+	for rows.Next() {
+		media, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		mediaList = append(mediaList, media)
+	}
+
+	return mediaList, rows.Err()
+}
+
+// scanMedia reads a media row without its content. The variant columns are
+// nullable as they were added after the table was first created.
+func scanMedia(row scanner) (*models.Media, error) {
+	media := &models.Media{}
+	var original sql.NullString
+	var width, height sql.NullInt64
+
+	err := row.Scan(&media.Hash, &media.Filename, &media.ContentType, &media.Size, &media.CreatedAt, &original, &width, &height)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	media.Original = original.String
+	media.Width = int(width.Int64)
+	media.Height = int(height.Int64)
+	return media, nil
+}
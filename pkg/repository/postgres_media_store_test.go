@@ -0,0 +1,38 @@
+package repository_test
+
+import (
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresMediaStoreWithContainer(t *testing.T) {
+	psStore, cleanup := setupTestContainer(t)
+	defer cleanup()
+
+	store := repository.NewPostgresMediaStore(psStore.DB)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	first, err := store.Create(&models.Media{Filename: "first.png", ContentType: "image/png", CreatedAt: now}, []byte("same"))
+	require.NoError(t, err)
+	second, err := store.Create(&models.Media{Filename: "second.png", ContentType: "image/png", CreatedAt: now}, []byte("same"))
+	require.NoError(t, err)
+	assert.Equal(t, first.Hash, second.Hash)
+	assert.Equal(t, "first.png", second.Filename)
+
+	got, data, err := store.Get(first.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("same"), data)
+	assert.Equal(t, int64(4), got.Size)
+
+	all, err := store.List()
+	require.NoError(t, err)
+	assert.Len(t, all, 1)
+
+	_, _, err = store.Get("missing")
+	assert.ErrorIs(t, err, repository.ErrMediaNotFound)
+}
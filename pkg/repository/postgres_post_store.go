@@ -1,9 +1,12 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"html/template"
+	"log/slog"
 	"microblog/pkg/models"
 	"os"
 
@@ -31,75 +34,115 @@ func New(psqlInfo, pathToSQL string) (*PostgresStore, error) {
 	if err != nil {
 		return nil, err
 	}
-	log.Print("successfully connected!")
+	slog.Info("successfully connected!")
 
 	_, err = db.Exec(string(query))
 	if err != nil {
 		return nil, err
 	}
-	log.Print("database successfully seeded!")
+	slog.Info("database successfully seeded!")
 
 	return &PostgresStore{DB: db}, nil
 }
 
-func (p *PostgresStore) GetAll() ([]*models.BlogPost, error) {
+func (p *PostgresStore) Ping(ctx context.Context) error {
+	return p.DB.PingContext(ctx)
+}
+
+// schemaChecks select the most recently added column of each table without
+// reading any rows, so they fail until every ALTER has been applied.
+var schemaChecks = []string{
+	"SELECT blog_render_version FROM blog LIMIT 0;",
+	"SELECT media_height FROM media LIMIT 0;",
+	"SELECT user_oidc_subject FROM users LIMIT 0;",
+	"SELECT session_attempts FROM sessions LIMIT 0;",
+	"SELECT token_scopes FROM api_tokens LIMIT 0;",
+	"SELECT audit_after FROM audit_log LIMIT 0;",
+}
+
+func (p *PostgresStore) CheckSchema(ctx context.Context) error {
+	for _, query := range schemaChecks {
+		rows, err := p.DB.QueryContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		rows.Close()
+	}
+	return nil
+}
+
+// Close closes the connection pool.
+func (p *PostgresStore) Close() error {
+	return p.DB.Close()
+}
+
+// selectPosts reads every blog column along with the handle and name of the
+// author.
+const selectPosts = "SELECT blog_id, blog_title, blog_post, blog_name, formatted_date, blog.created_at, blog.updated_at, blog_title_html, blog_post_html, blog_excerpt_html, blog_summary, blog_cover_image, blog_description, blog_author_id, user_handle, user_name, blog_render_version FROM blog LEFT JOIN users ON user_id = blog_author_id"
+
+func (p *PostgresStore) GetAll(ctx context.Context) ([]*models.BlogPost, error) {
+	return p.queryPosts(ctx, selectPosts+";")
+}
+
+func (p *PostgresStore) GetByAuthor(ctx context.Context, authorID uuid.UUID) ([]*models.BlogPost, error) {
+	return p.queryPosts(ctx, selectPosts+" WHERE blog_author_id = $1 ORDER BY blog.created_at DESC, blog_id DESC;", authorID)
+}
+
+func (p *PostgresStore) queryPosts(ctx context.Context, query string, args ...any) ([]*models.BlogPost, error) {
 
 	blogPosts := []*models.BlogPost{}
 
-	rows, err := p.DB.Query("SELECT * FROM blog;")
+	rows, err := p.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		bp := models.NewBlogPost()
-		err := rows.Scan(&bp.ID, &bp.Title, &bp.Content, &bp.Name, &bp.FormattedDate, &bp.CreatedAt, &bp.UpdatedAt)
+		bp, err := scanBlogPost(rows)
 		if err != nil {
 			return nil, err
 		}
 		blogPosts = append(blogPosts, bp)
 	}
 
-	return blogPosts, nil
+	return blogPosts, rows.Err()
 }
 
-func (p *PostgresStore) Create(blogpost *models.BlogPost) error {
+func (p *PostgresStore) Create(ctx context.Context, blogpost *models.BlogPost) error {
 
-	rows, err := p.DB.Query("insert into blog values ($1,$2,$3,$4,$5,$6,$7);", blogpost.ID, blogpost.Title, blogpost.Content, blogpost.Name, blogpost.FormattedDate, blogpost.CreatedAt, blogpost.UpdatedAt)
+	rows, err := p.DB.QueryContext(ctx, "insert into blog values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15);", blogpost.ID, blogpost.Title, blogpost.Content, blogpost.Name, blogpost.FormattedDate, blogpost.CreatedAt, blogpost.UpdatedAt, blogpost.TitleHTML, blogpost.ContentHTML, blogpost.Excerpt, blogpost.Summary, blogpost.CoverImage, blogpost.Description, uuid.NullUUID{UUID: blogpost.AuthorID, Valid: blogpost.AuthorID != uuid.Nil}, blogpost.RenderVersion)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(rows, err)
-	return nil
+	return rows.Close()
 }
 
-func (p *PostgresStore) Delete(id uuid.UUID) error {
+func (p *PostgresStore) Delete(ctx context.Context, id uuid.UUID) error {
 
-	rows, err := p.DB.Query("DELETE FROM blog WHERE blog_id = $1;", id)
+	rows, err := p.DB.QueryContext(ctx, "DELETE FROM blog WHERE blog_id = $1;", id)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(rows, err)
-	return nil
+	return rows.Close()
 }
 
-func (p *PostgresStore) Update(blogpost *models.BlogPost) error {
-	rows, err := p.DB.Query("UPDATE blog SET blog_title = $1, blog_post = $2, updated_at = $3 WHERE blog_id = $4;", blogpost.Title, blogpost.Content, blogpost.UpdatedAt, blogpost.ID)
+func (p *PostgresStore) Update(ctx context.Context, blogpost *models.BlogPost) error {
+	rows, err := p.DB.QueryContext(ctx, "UPDATE blog SET blog_title = $1, blog_post = $2, updated_at = $3, blog_title_html = $4, blog_post_html = $5, blog_excerpt_html = $6, blog_summary = $7, blog_cover_image = $8, blog_description = $9, blog_render_version = $10 WHERE blog_id = $11;", blogpost.Title, blogpost.Content, blogpost.UpdatedAt, blogpost.TitleHTML, blogpost.ContentHTML, blogpost.Excerpt, blogpost.Summary, blogpost.CoverImage, blogpost.Description, blogpost.RenderVersion, blogpost.ID)
 	if err != nil {
 		return err
 	}
-	fmt.Println(rows, err)
-	return nil
+	return rows.Close()
 }
 
-func (p *PostgresStore) GetByID(id uuid.UUID) (*models.BlogPost, error) {
-
-	bp := models.NewBlogPost()
+func (p *PostgresStore) GetByID(ctx context.Context, id uuid.UUID) (*models.BlogPost, error) {
 
-	err := p.DB.QueryRow("SELECT * FROM blog WHERE blog_id = $1;", id).
-		Scan(&bp.ID, &bp.Title, &bp.Content, &bp.Name, &bp.FormattedDate, &bp.CreatedAt, &bp.UpdatedAt)
+	bp, err := scanBlogPost(p.DB.QueryRowContext(ctx, selectPosts+" WHERE blog_id = $1;", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return &models.BlogPost{}, ErrPostNotFound
+	}
 	if err != nil {
 		return &models.BlogPost{}, err
 	}
@@ -107,16 +150,16 @@ func (p *PostgresStore) GetByID(id uuid.UUID) (*models.BlogPost, error) {
 	return bp, nil
 }
 
-func (p *PostgresStore) GetByName(name string) (*models.BlogPost, error) {
-
-	bp := models.NewBlogPost()
+func (p *PostgresStore) GetByName(ctx context.Context, name string) (*models.BlogPost, error) {
 
 	if name == "" {
 		return &models.BlogPost{}, fmt.Errorf("name is empty")
 	}
 
-	err := p.DB.QueryRow("SELECT * FROM blog WHERE blog_name = $1;", name).
-		Scan(&bp.ID, &bp.Title, &bp.Content, &bp.Name, &bp.FormattedDate, &bp.CreatedAt, &bp.UpdatedAt)
+	bp, err := scanBlogPost(p.DB.QueryRowContext(ctx, selectPosts+" WHERE blog_name = $1;", name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return &models.BlogPost{}, ErrPostNotFound
+	}
 	if err != nil {
 		return &models.BlogPost{}, err
 	}
@@ -124,38 +167,36 @@ func (p *PostgresStore) GetByName(name string) (*models.BlogPost, error) {
 	return bp, nil
 }
 
-func (p *PostgresStore) FetchLast10BlogPosts() ([]*models.BlogPost, error) {
-
-	blogPosts := []*models.BlogPost{}
-
-	rows, err := p.DB.Query("SELECT * FROM blog ORDER BY created_at DESC, blog_id DESC LIMIT 10;")
-	if err != nil {
-		return nil, err
-	}
-
-	for rows.Next() {
-		bp := models.NewBlogPost()
-		err := rows.Scan(&bp.ID, &bp.Title, &bp.Content, &bp.Name, &bp.FormattedDate, &bp.CreatedAt, &bp.UpdatedAt)
-		if err != nil {
-			return nil, err
-		}
-		blogPosts = append(blogPosts, bp)
-	}
+func (p *PostgresStore) FetchLast10BlogPosts(ctx context.Context) ([]*models.BlogPost, error) {
+	return p.queryPosts(ctx, selectPosts+" ORDER BY blog.created_at DESC, blog_id DESC LIMIT 10;")
+}
 
-	return blogPosts, nil
+type scanner interface {
+	Scan(dest ...any) error
 }
 
-func GeneratePSQL(host, port, password, user, dbName string) (psqlInfo string) {
+// scanBlogPost reads a row selected by selectPosts. The rendered HTML,
+// summary, cover image, description, author and render version columns are
+// nullable as they were added after the table was first created.
+func scanBlogPost(row scanner) (*models.BlogPost, error) {
+	bp := models.NewBlogPost()
+	var titleHTML, contentHTML, excerpt, summary, coverImage, description, authorHandle, authorName, renderVersion sql.NullString
+	var authorID uuid.NullUUID
 
-	if os.Getenv("LOCAL") == "local" {
-		psqlInfo = fmt.Sprintf("host=%s port=%s user=%s "+
-			"password=%s dbname=%s sslmode=disable",
-			host, port, user, password, dbName)
-	} else {
-		psqlInfo = fmt.Sprintf("host=%s port=%s user=%s "+
-			"password=%s dbname=%s sslmode=require options=databaseid%%3D%s",
-			host, port, user, password, dbName, os.Getenv("DB_ID"))
+	err := row.Scan(&bp.ID, &bp.Title, &bp.Content, &bp.Name, &bp.FormattedDate, &bp.CreatedAt, &bp.UpdatedAt, &titleHTML, &contentHTML, &excerpt, &summary, &coverImage, &description, &authorID, &authorHandle, &authorName, &renderVersion)
+	if err != nil {
+		return nil, err
 	}
 
-	return psqlInfo
+	bp.TitleHTML = template.HTML(titleHTML.String)
+	bp.ContentHTML = template.HTML(contentHTML.String)
+	bp.Excerpt = template.HTML(excerpt.String)
+	bp.Summary = summary.String
+	bp.CoverImage = coverImage.String
+	bp.Description = description.String
+	bp.AuthorID = authorID.UUID
+	bp.AuthorHandle = authorHandle.String
+	bp.AuthorName = authorName.String
+	bp.RenderVersion = renderVersion.String
+	return bp, nil
 }
@@ -39,10 +39,10 @@ func TestCreateWithContainer(t *testing.T) {
 	want.CreatedAt = nowTime
 	want.UpdatedAt = nowTime
 
-	err = store.Create(want)
+	err = store.Create(context.Background(), want)
 	require.NoError(t, err)
 
-	got, err := store.GetByID(want.ID)
+	got, err := store.GetByID(context.Background(), want.ID)
 	require.NoError(t, err)
 
 	got.UpdatedAt = got.UpdatedAt.UTC()
@@ -80,13 +80,13 @@ func TestGetAll(t *testing.T) {
 	var wantSlice []*models.BlogPost
 	wantSlice = append(wantSlice, want1, want2)
 
-	err = store.Create(want1)
+	err = store.Create(context.Background(), want1)
 	require.NoError(t, err)
 
-	err = store.Create(want2)
+	err = store.Create(context.Background(), want2)
 	require.NoError(t, err)
 
-	got, err := store.GetAll()
+	got, err := store.GetAll(context.Background())
 	require.NoError(t, err)
 
 	for i := range got {
@@ -109,17 +109,52 @@ func TestGetError(t *testing.T) {
 
 	invalidID := uuid.New()
 
-	mock.ExpectQuery("SELECT \\* FROM blog WHERE blog_id = (.+)").
+	mock.ExpectQuery("SELECT (.+) FROM blog LEFT JOIN users (.+) WHERE blog_id = (.+)").
 		WithArgs(invalidID).
-		WillReturnError(sql.ErrNoRows)
+		WillReturnError(sql.ErrConnDone)
 
-	result, err := store.GetByID(invalidID)
+	result, err := store.GetByID(context.Background(), invalidID)
 	assert.Empty(t, &result)
 	assert.Error(t, err)
-	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.ErrorIs(t, err, sql.ErrConnDone)
 	assert.NoError(t, mock.ExpectationsWereMet(), "There were unfulfilled expectations: %s", err)
 }
 
+func TestGetByIDNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := &repository.PostgresStore{DB: db}
+
+	id := uuid.New()
+	mock.ExpectQuery("SELECT (.+) FROM blog LEFT JOIN users (.+) WHERE blog_id = (.+)").
+		WithArgs(id).
+		WillReturnError(sql.ErrNoRows)
+
+	result, err := store.GetByID(context.Background(), id)
+	assert.ErrorIs(t, err, repository.ErrPostNotFound)
+	assert.Equal(t, uuid.Nil, result.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetByNameError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := &repository.PostgresStore{DB: db}
+
+	mock.ExpectQuery("SELECT (.+) FROM blog LEFT JOIN users (.+) WHERE blog_name = (.+)").
+		WithArgs("nope").
+		WillReturnError(sql.ErrNoRows)
+
+	result, err := store.GetByName(context.Background(), "nope")
+	assert.ErrorIs(t, err, repository.ErrPostNotFound)
+	assert.Equal(t, uuid.Nil, result.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestCreateError(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
@@ -134,7 +169,7 @@ func TestCreateError(t *testing.T) {
 	}
 
 	mock.ExpectQuery("insert into blog values (.+)").WillReturnError(sql.ErrTxDone)
-	err = store.Create(&blogpost)
+	err = store.Create(context.Background(), &blogpost)
 	assert.ErrorIs(t, err, sql.ErrTxDone)
 	assert.NoError(t, mock.ExpectationsWereMet(), "There were unfulfilled expectations: %s", err)
 
@@ -148,16 +183,40 @@ func TestGetAllError(t *testing.T) {
 
 	store := &repository.PostgresStore{DB: db}
 
-	mock.ExpectQuery("SELECT \\* FROM blog;").
+	mock.ExpectQuery("SELECT (.+) FROM blog LEFT JOIN users ON user_id = blog_author_id;").
 		WillReturnError(sql.ErrTxDone)
 
-	result, err := store.GetAll()
+	result, err := store.GetAll(context.Background())
 	assert.Nil(t, result)
 	assert.ErrorIs(t, err, sql.ErrTxDone)
 	assert.NoError(t, mock.ExpectationsWereMet(), "There were unfulfilled expectations: %s", err)
 
 }
 
+func TestCheckSchemaWithContainer(t *testing.T) {
+	store, cleanup := setupTestContainer(t)
+	defer cleanup()
+
+	require.NoError(t, store.Ping(context.Background()))
+	require.NoError(t, store.CheckSchema(context.Background()))
+}
+
+func TestCheckSchemaError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	defer db.Close()
+
+	store := &repository.PostgresStore{DB: db}
+
+	mock.ExpectQuery("SELECT blog_render_version FROM blog LIMIT 0;").
+		WillReturnError(sql.ErrNoRows)
+
+	err = store.CheckSchema(context.Background())
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func setupTestContainer(t *testing.T) (*repository.PostgresStore, func()) {
 	t.Helper()
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
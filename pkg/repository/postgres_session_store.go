@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"microblog/pkg/models"
+	"time"
+)
+
+type PostgresSessionStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresSessionStore(db *sql.DB) *PostgresSessionStore {
+	return &PostgresSessionStore{DB: db}
+}
+
+func (p *PostgresSessionStore) Create(ctx context.Context, session *models.Session) error {
+	_, err := p.DB.ExecContext(ctx, "INSERT INTO sessions (session_id_hash, user_id, session_csrf_token, session_pending, session_attempts, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7);",
+		session.IDHash, session.UserID, session.CSRFToken, session.Pending, session.Attempts, session.CreatedAt, session.ExpiresAt)
+	return err
+}
+
+func (p *PostgresSessionStore) Get(ctx context.Context, idHash string) (*models.Session, error) {
+	session := &models.Session{}
+	err := p.DB.QueryRowContext(ctx, "SELECT session_id_hash, user_id, session_csrf_token, session_pending, session_attempts, created_at, expires_at FROM sessions WHERE session_id_hash = $1;", idHash).
+		Scan(&session.IDHash, &session.UserID, &session.CSRFToken, &session.Pending, &session.Attempts, &session.CreatedAt, &session.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (p *PostgresSessionStore) Delete(ctx context.Context, idHash string) error {
+	_, err := p.DB.ExecContext(ctx, "DELETE FROM sessions WHERE session_id_hash = $1;", idHash)
+	return err
+}
+
+func (p *PostgresSessionStore) DeleteExpired(ctx context.Context, now time.Time) error {
+	_, err := p.DB.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at <= $1;", now)
+	return err
+}
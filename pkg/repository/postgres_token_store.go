@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"microblog/pkg/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type PostgresAPITokenStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresAPITokenStore(db *sql.DB) *PostgresAPITokenStore {
+	return &PostgresAPITokenStore{DB: db}
+}
+
+const tokenColumns = "token_id, user_id, token_name, token_hash, token_scopes, expires_at, last_used_at, created_at"
+
+func (p *PostgresAPITokenStore) Create(ctx context.Context, token *models.APIToken) error {
+	scopes := make([]string, len(token.Scopes))
+	for i, scope := range token.Scopes {
+		scopes[i] = string(scope)
+	}
+	_, err := p.DB.ExecContext(ctx, "INSERT INTO api_tokens ("+tokenColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8);",
+		token.ID, token.UserID, token.Name, token.Hash, pq.Array(scopes),
+		nullTime(token.ExpiresAt), nullTime(token.LastUsedAt), token.CreatedAt)
+	return err
+}
+
+func (p *PostgresAPITokenStore) GetByHash(ctx context.Context, hash string) (*models.APIToken, error) {
+	return scanToken(p.DB.QueryRowContext(ctx, "SELECT "+tokenColumns+" FROM api_tokens WHERE token_hash = $1;", hash))
+}
+
+func (p *PostgresAPITokenStore) List(ctx context.Context) ([]*models.APIToken, error) {
+	return p.queryTokens(ctx, "SELECT "+tokenColumns+" FROM api_tokens ORDER BY created_at DESC;")
+}
+
+func (p *PostgresAPITokenStore) ListByUser(ctx context.Context, userID uuid.UUID) ([]*models.APIToken, error) {
+	return p.queryTokens(ctx, "SELECT "+tokenColumns+" FROM api_tokens WHERE user_id = $1 ORDER BY created_at DESC;", userID)
+}
+
+func (p *PostgresAPITokenStore) queryTokens(ctx context.Context, query string, args ...any) ([]*models.APIToken, error) {
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*models.APIToken{}
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (p *PostgresAPITokenStore) Delete(ctx context.Context, id uuid.UUID) error {
+	_, err := p.DB.ExecContext(ctx, "DELETE FROM api_tokens WHERE token_id = $1;", id)
+	return err
+}
+
+func (p *PostgresAPITokenStore) Touch(ctx context.Context, id uuid.UUID, usedAt time.Time) error {
+	_, err := p.DB.ExecContext(ctx, "UPDATE api_tokens SET last_used_at = $1 WHERE token_id = $2;", usedAt, id)
+	return err
+}
+
+func scanToken(row scanner) (*models.APIToken, error) {
+	token := &models.APIToken{}
+	var scopes []string
+	var expiresAt, lastUsedAt sql.NullTime
+
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.Hash, pq.Array(&scopes), &expiresAt, &lastUsedAt, &token.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, scope := range scopes {
+		token.Scopes = append(token.Scopes, models.Scope(scope))
+	}
+	token.ExpiresAt = expiresAt.Time
+	token.LastUsedAt = lastUsedAt.Time
+	return token, nil
+}
@@ -0,0 +1,73 @@
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPITokenStoreWithContainer(t *testing.T) {
+	store, cleanup := setupTestContainer(t)
+	defer cleanup()
+	users := repository.NewPostgresUserStore(store.DB)
+	tokens := repository.NewPostgresAPITokenStore(store.DB)
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	ann := &models.User{ID: uuid.New(), Handle: "ann", PasswordHash: "hash", Role: models.RoleAuthor, CreatedAt: now, UpdatedAt: now}
+	require.NoError(t, users.Create(ctx, ann))
+
+	ci := &models.APIToken{ID: uuid.New(), UserID: ann.ID, Name: "ci", Hash: "4bf92f3577b34da6a3ce929d0e0e47364bf92f3577b34da6a3ce929d0e0e4736",
+		Scopes: []models.Scope{models.ScopePostsWrite, models.ScopeMediaWrite}, CreatedAt: now}
+	require.NoError(t, tokens.Create(ctx, ci))
+	old := &models.APIToken{ID: uuid.New(), UserID: ann.ID, Name: "old", Hash: "00f92f3577b34da6a3ce929d0e0e47364bf92f3577b34da6a3ce929d0e0e4736",
+		Scopes: []models.Scope{models.ScopePostsRead}, ExpiresAt: now.Add(-time.Hour), CreatedAt: now.Add(-time.Hour)}
+	require.NoError(t, tokens.Create(ctx, old))
+
+	got, err := tokens.GetByHash(ctx, ci.Hash)
+	require.NoError(t, err)
+	got.CreatedAt = got.CreatedAt.UTC()
+	assert.Equal(t, ci, got)
+
+	require.NoError(t, tokens.Touch(ctx, ci.ID, now.Add(time.Minute)))
+	list, err := tokens.ListByUser(ctx, ann.ID)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "ci", list[0].Name, "newest first")
+	assert.True(t, now.Add(time.Minute).Equal(list[0].LastUsedAt))
+	assert.True(t, list[1].Expired(now))
+
+	require.NoError(t, tokens.Delete(ctx, old.ID))
+	_, err = tokens.GetByHash(ctx, old.Hash)
+	assert.ErrorIs(t, err, repository.ErrTokenNotFound)
+
+	// tokens go with their user
+	require.NoError(t, users.Delete(ctx, ann.ID))
+	list, err = tokens.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestGetAPITokenNotFoundError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tokens := repository.NewPostgresAPITokenStore(db)
+
+	mock.ExpectQuery("SELECT (.+) FROM api_tokens WHERE token_hash = (.+)").
+		WithArgs("unknown").
+		WillReturnError(sql.ErrNoRows)
+
+	_, err = tokens.GetByHash(context.Background(), "unknown")
+	assert.ErrorIs(t, err, repository.ErrTokenNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
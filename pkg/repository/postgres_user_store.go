@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"microblog/pkg/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type PostgresUserStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresUserStore(db *sql.DB) *PostgresUserStore {
+	return &PostgresUserStore{DB: db}
+}
+
+const userColumns = "user_id, user_handle, user_name, user_password_hash, user_role, user_disabled, user_invite_hash, user_invite_expires, user_totp_secret, user_totp_last_step, user_recovery_codes, user_oidc_subject, created_at, updated_at"
+
+func (p *PostgresUserStore) Create(ctx context.Context, user *models.User) error {
+	_, err := p.DB.ExecContext(ctx, "INSERT INTO users ("+userColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14);",
+		user.ID, user.Handle, user.Name, user.PasswordHash, user.Role, user.Disabled,
+		nullString(user.InviteHash), nullTime(user.InviteExpires),
+		nullString(user.TOTPSecret), user.TOTPLastStep, recoveryCodes(user), nullString(user.OIDCSubject),
+		user.CreatedAt, user.UpdatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrHandleTaken
+	}
+	return err
+}
+
+func (p *PostgresUserStore) GetByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	return scanUser(p.DB.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE user_id = $1;", id))
+}
+
+func (p *PostgresUserStore) GetByHandle(ctx context.Context, handle string) (*models.User, error) {
+	return scanUser(p.DB.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE user_handle = $1;", handle))
+}
+
+func (p *PostgresUserStore) GetByInvite(ctx context.Context, inviteHash string) (*models.User, error) {
+	return scanUser(p.DB.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE user_invite_hash = $1;", inviteHash))
+}
+
+func (p *PostgresUserStore) GetBySubject(ctx context.Context, subject string) (*models.User, error) {
+	return scanUser(p.DB.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE user_oidc_subject = $1;", subject))
+}
+
+func (p *PostgresUserStore) List(ctx context.Context) ([]*models.User, error) {
+	rows, err := p.DB.QueryContext(ctx, "SELECT "+userColumns+" FROM users ORDER BY user_handle;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (p *PostgresUserStore) Update(ctx context.Context, user *models.User) error {
+	res, err := p.DB.ExecContext(ctx, "UPDATE users SET user_handle = $1, user_name = $2, user_password_hash = $3, user_role = $4, user_disabled = $5, user_invite_hash = $6, user_invite_expires = $7, user_totp_secret = $8, user_totp_last_step = $9, user_recovery_codes = $10, user_oidc_subject = $11, updated_at = $12 WHERE user_id = $13;",
+		user.Handle, user.Name, user.PasswordHash, user.Role, user.Disabled,
+		nullString(user.InviteHash), nullTime(user.InviteExpires),
+		nullString(user.TOTPSecret), user.TOTPLastStep, recoveryCodes(user), nullString(user.OIDCSubject),
+		user.UpdatedAt, user.ID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrHandleTaken
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (p *PostgresUserStore) Delete(ctx context.Context, id uuid.UUID) error {
+	_, err := p.DB.ExecContext(ctx, "DELETE FROM users WHERE user_id = $1;", id)
+	return err
+}
+
+func scanUser(row scanner) (*models.User, error) {
+	user := &models.User{}
+	var inviteHash, totpSecret, oidcSubject sql.NullString
+	var inviteExpires sql.NullTime
+
+	err := row.Scan(&user.ID, &user.Handle, &user.Name, &user.PasswordHash, &user.Role, &user.Disabled, &inviteHash, &inviteExpires,
+		&totpSecret, &user.TOTPLastStep, pq.Array(&user.RecoveryCodes), &oidcSubject, &user.CreatedAt, &user.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user.InviteHash = inviteHash.String
+	user.InviteExpires = inviteExpires.Time
+	user.TOTPSecret = totpSecret.String
+	user.OIDCSubject = oidcSubject.String
+	if len(user.RecoveryCodes) == 0 {
+		user.RecoveryCodes = nil
+	}
+	return user, nil
+}
+
+// recoveryCodes is never NULL, as the column is not.
+func recoveryCodes(user *models.User) any {
+	if user.RecoveryCodes == nil {
+		return pq.Array([]string{})
+	}
+	return pq.Array(user.RecoveryCodes)
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,130 @@
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserStoreWithContainer(t *testing.T) {
+	store, cleanup := setupTestContainer(t)
+	defer cleanup()
+	users := repository.NewPostgresUserStore(store.DB)
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	ann := &models.User{ID: uuid.New(), Handle: "ann", Name: "Ann", PasswordHash: "hash", Role: models.RoleAuthor, CreatedAt: now, UpdatedAt: now}
+	require.NoError(t, users.Create(ctx, ann))
+	invited := &models.User{ID: uuid.New(), Handle: "bob", Role: models.RoleViewer, InviteHash: "4bf92f3577b34da6a3ce929d0e0e47364bf92f3577b34da6a3ce929d0e0e4736", InviteExpires: now.Add(time.Hour), CreatedAt: now, UpdatedAt: now}
+	require.NoError(t, users.Create(ctx, invited))
+	assert.ErrorIs(t, users.Create(ctx, &models.User{ID: uuid.New(), Handle: "ann", Role: models.RoleViewer, CreatedAt: now, UpdatedAt: now}), repository.ErrHandleTaken)
+
+	got, err := users.GetByHandle(ctx, "ann")
+	require.NoError(t, err)
+	got.CreatedAt, got.UpdatedAt = got.CreatedAt.UTC(), got.UpdatedAt.UTC()
+	assert.Equal(t, ann, got)
+
+	got, err = users.GetByInvite(ctx, invited.InviteHash)
+	require.NoError(t, err)
+	assert.Equal(t, invited.ID, got.ID)
+	assert.True(t, invited.InviteExpires.Equal(got.InviteExpires))
+
+	got.InviteHash = ""
+	got.InviteExpires = time.Time{}
+	got.PasswordHash = "bob-hash"
+	got.TOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	got.TOTPLastStep = 56666666
+	got.RecoveryCodes = []string{"first-hash", "second-hash"}
+	require.NoError(t, users.Update(ctx, got))
+	_, err = users.GetByInvite(ctx, invited.InviteHash)
+	assert.ErrorIs(t, err, repository.ErrUserNotFound)
+
+	list, err := users.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "ann", list[0].Handle)
+	assert.Equal(t, "bob-hash", list[1].PasswordHash)
+	assert.True(t, list[1].TwoFactorEnabled())
+	assert.Equal(t, int64(56666666), list[1].TOTPLastStep)
+	assert.Equal(t, []string{"first-hash", "second-hash"}, list[1].RecoveryCodes)
+
+	carol := &models.User{ID: uuid.New(), Handle: "carol", Role: models.RoleEditor, OIDCSubject: "00u1a2b3c4", CreatedAt: now, UpdatedAt: now}
+	require.NoError(t, users.Create(ctx, carol))
+	got, err = users.GetBySubject(ctx, "00u1a2b3c4")
+	require.NoError(t, err)
+	assert.Equal(t, carol.ID, got.ID)
+	assert.True(t, got.Active())
+	require.NoError(t, users.Delete(ctx, carol.ID))
+
+	post := &models.BlogPost{ID: uuid.New(), Name: "by-ann", Title: "By Ann", Content: "content", CreatedAt: now, UpdatedAt: now, AuthorID: ann.ID}
+	require.NoError(t, store.Create(ctx, post))
+	byAnn, err := store.GetByAuthor(ctx, ann.ID)
+	require.NoError(t, err)
+	require.Len(t, byAnn, 1)
+	assert.Equal(t, "ann", byAnn[0].AuthorHandle)
+	assert.Equal(t, "Ann", byAnn[0].AuthorName)
+
+	// posts outlive their author
+	require.NoError(t, users.Delete(ctx, ann.ID))
+	_, err = users.GetByID(ctx, ann.ID)
+	assert.ErrorIs(t, err, repository.ErrUserNotFound)
+	kept, err := store.GetByID(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, uuid.Nil, kept.AuthorID)
+}
+
+func TestGetUserByHandleError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	users := repository.NewPostgresUserStore(db)
+
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE user_handle = (.+)").
+		WithArgs("nobody").
+		WillReturnError(sql.ErrNoRows)
+
+	_, err = users.GetByHandle(context.Background(), "nobody")
+	assert.ErrorIs(t, err, repository.ErrUserNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateUserHandleTakenError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	users := repository.NewPostgresUserStore(db)
+
+	mock.ExpectExec("INSERT INTO users (.+)").
+		WillReturnError(&pq.Error{Code: "23505"})
+
+	err = users.Create(context.Background(), &models.User{ID: uuid.New(), Handle: "ann", Role: models.RoleAuthor})
+	assert.ErrorIs(t, err, repository.ErrHandleTaken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetSessionNotFoundError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sessions := repository.NewPostgresSessionStore(db)
+
+	mock.ExpectQuery("SELECT (.+) FROM sessions WHERE session_id_hash = (.+)").
+		WithArgs("unknown").
+		WillReturnError(sql.ErrNoRows)
+
+	_, err = sessions.Get(context.Background(), "unknown")
+	assert.ErrorIs(t, err, repository.ErrSessionNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
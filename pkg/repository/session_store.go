@@ -0,0 +1,22 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"microblog/pkg/models"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionStore keeps the sessions of signed in browsers, looked up by the
+// hash of the session ID.
+type SessionStore interface {
+	Create(ctx context.Context, session *models.Session) error
+	// Get returns ErrSessionNotFound for unknown sessions. Expired sessions
+	// are returned until they are deleted.
+	Get(ctx context.Context, idHash string) (*models.Session, error)
+	Delete(ctx context.Context, idHash string) error
+	// DeleteExpired removes sessions which expired before now.
+	DeleteExpired(ctx context.Context, now time.Time) error
+}
@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"context"
+	"microblog/pkg/models"
+	"sync"
+)
+
+// MemoryAuditStore keeps the audit log in memory for tests and local
+// development.
+type MemoryAuditStore struct {
+	mu      sync.Mutex
+	entries []models.AuditEntry
+}
+
+func NewMemoryAuditStore() *MemoryAuditStore {
+	return &MemoryAuditStore{}
+}
+
+func (s *MemoryAuditStore) Append(ctx context.Context, entry *models.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = int64(len(s.entries)) + 1
+	s.entries = append(s.entries, *entry)
+	return nil
+}
+
+func (s *MemoryAuditStore) List(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := []*models.AuditEntry{}
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			break
+		}
+		if e := s.entries[i]; filter.Matches(&e) {
+			entries = append(entries, &e)
+		}
+	}
+	return entries, nil
+}
@@ -1,8 +1,8 @@
 package repository
 
 import (
+	"context"
 	"microblog/pkg/models"
-	"time"
 
 	"github.com/google/uuid"
 )
@@ -12,39 +12,49 @@ type MemoryPostStore struct {
 	AccessCounter int
 }
 
-func (s *MemoryPostStore) GetAll() ([]*models.BlogPost, error) {
+func (s *MemoryPostStore) GetAll(ctx context.Context) ([]*models.BlogPost, error) {
 	return s.BlogPosts, nil
 }
 
-func (s *MemoryPostStore) Create(blogpost *models.BlogPost) error {
+func (s *MemoryPostStore) Create(ctx context.Context, blogpost *models.BlogPost) error {
 	s.BlogPosts = append(s.BlogPosts, blogpost)
 	return nil
 }
 
-func (s *MemoryPostStore) GetByID(id uuid.UUID) (*models.BlogPost, error) {
+func (s *MemoryPostStore) GetByID(ctx context.Context, id uuid.UUID) (*models.BlogPost, error) {
 	for _, v := range s.BlogPosts {
 		if v.ID == id {
 			return v, nil
 		}
 	}
-	return &models.BlogPost{}, nil
+	return &models.BlogPost{}, ErrPostNotFound
 }
 
-func (s *MemoryPostStore) GetByName(name string) (*models.BlogPost, error) {
+func (s *MemoryPostStore) GetByName(ctx context.Context, name string) (*models.BlogPost, error) {
 	for _, v := range s.BlogPosts {
 		if v.Name == name {
 			return v, nil
 		}
 	}
-	return &models.BlogPost{}, nil
+	return &models.BlogPost{}, ErrPostNotFound
 }
 
-func (s *MemoryPostStore) FetchLast10BlogPosts() ([]*models.BlogPost, error) {
+func (s *MemoryPostStore) FetchLast10BlogPosts(ctx context.Context) ([]*models.BlogPost, error) {
 	s.AccessCounter++
 	return s.BlogPosts, nil
 }
 
-func (s *MemoryPostStore) Delete(id uuid.UUID) error {
+func (s *MemoryPostStore) GetByAuthor(ctx context.Context, authorID uuid.UUID) ([]*models.BlogPost, error) {
+	blogPosts := []*models.BlogPost{}
+	for i := len(s.BlogPosts) - 1; i >= 0; i-- {
+		if s.BlogPosts[i].AuthorID == authorID {
+			blogPosts = append(blogPosts, s.BlogPosts[i])
+		}
+	}
+	return blogPosts, nil
+}
+
+func (s *MemoryPostStore) Delete(ctx context.Context, id uuid.UUID) error {
 	for i, v := range s.BlogPosts {
 		if v.ID == id {
 			s.BlogPosts = append(s.BlogPosts[:i], s.BlogPosts[i+1:]...)
@@ -54,12 +64,19 @@ func (s *MemoryPostStore) Delete(id uuid.UUID) error {
 	return nil
 }
 
-func (s *MemoryPostStore) Update(updatedBlogpost *models.BlogPost) error {
+func (s *MemoryPostStore) Update(ctx context.Context, updatedBlogpost *models.BlogPost) error {
 	for _, v := range s.BlogPosts {
 		if v.ID == updatedBlogpost.ID {
 			v.Content = updatedBlogpost.Content
 			v.Title = updatedBlogpost.Title
-			v.UpdatedAt = time.Now().UTC()
+			v.Summary = updatedBlogpost.Summary
+			v.CoverImage = updatedBlogpost.CoverImage
+			v.Description = updatedBlogpost.Description
+			v.TitleHTML = updatedBlogpost.TitleHTML
+			v.ContentHTML = updatedBlogpost.ContentHTML
+			v.Excerpt = updatedBlogpost.Excerpt
+			v.RenderVersion = updatedBlogpost.RenderVersion
+			v.UpdatedAt = updatedBlogpost.UpdatedAt
 		}
 	}
 	return nil
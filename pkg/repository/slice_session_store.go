@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"microblog/pkg/models"
+	"sync"
+	"time"
+)
+
+// MemorySessionStore keeps sessions in memory for tests and local
+// development.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]models.Session
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: map[string]models.Session{}}
+}
+
+func (s *MemorySessionStore) Create(ctx context.Context, session *models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.IDHash] = *session
+	return nil
+}
+
+func (s *MemorySessionStore) Get(ctx context.Context, idHash string) (*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[idHash]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+func (s *MemorySessionStore) Delete(ctx context.Context, idHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, idHash)
+	return nil
+}
+
+func (s *MemorySessionStore) DeleteExpired(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for idHash, session := range s.sessions {
+		if session.Expired(now) {
+			delete(s.sessions, idHash)
+		}
+	}
+	return nil
+}
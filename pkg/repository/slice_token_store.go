@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"microblog/pkg/models"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryAPITokenStore keeps API tokens in memory for tests and local
+// development.
+type MemoryAPITokenStore struct {
+	mu     sync.Mutex
+	tokens []*models.APIToken
+}
+
+func NewMemoryAPITokenStore() *MemoryAPITokenStore {
+	return &MemoryAPITokenStore{}
+}
+
+func (s *MemoryAPITokenStore) Create(ctx context.Context, token *models.APIToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *token
+	c.Scopes = slices.Clone(token.Scopes)
+	s.tokens = append(s.tokens, &c)
+	return nil
+}
+
+func (s *MemoryAPITokenStore) GetByHash(ctx context.Context, hash string) (*models.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.tokens {
+		if t.Hash == hash {
+			c := *t
+			c.Scopes = slices.Clone(t.Scopes)
+			return &c, nil
+		}
+	}
+	return nil, ErrTokenNotFound
+}
+
+func (s *MemoryAPITokenStore) list(match func(*models.APIToken) bool) []*models.APIToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := []*models.APIToken{}
+	for _, t := range s.tokens {
+		if match(t) {
+			c := *t
+			c.Scopes = slices.Clone(t.Scopes)
+			tokens = append(tokens, &c)
+		}
+	}
+	slices.SortStableFunc(tokens, func(a, b *models.APIToken) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return tokens
+}
+
+func (s *MemoryAPITokenStore) List(ctx context.Context) ([]*models.APIToken, error) {
+	return s.list(func(*models.APIToken) bool { return true }), nil
+}
+
+func (s *MemoryAPITokenStore) ListByUser(ctx context.Context, userID uuid.UUID) ([]*models.APIToken, error) {
+	return s.list(func(t *models.APIToken) bool { return t.UserID == userID }), nil
+}
+
+func (s *MemoryAPITokenStore) Delete(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens = slices.DeleteFunc(s.tokens, func(t *models.APIToken) bool { return t.ID == id })
+	return nil
+}
+
+func (s *MemoryAPITokenStore) Touch(ctx context.Context, id uuid.UUID, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.tokens {
+		if t.ID == id {
+			t.LastUsedAt = usedAt
+			return nil
+		}
+	}
+	return ErrTokenNotFound
+}
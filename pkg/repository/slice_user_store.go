@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"microblog/pkg/models"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// MemoryUserStore keeps users in memory for tests and local development.
+// Users are copied in and out, so callers cannot change stored users without
+// calling Update.
+type MemoryUserStore struct {
+	mu    sync.Mutex
+	users []*models.User
+}
+
+func NewMemoryUserStore(users ...*models.User) *MemoryUserStore {
+	s := &MemoryUserStore{}
+	for _, u := range users {
+		s.users = append(s.users, copyUser(u))
+	}
+	return s
+}
+
+func (s *MemoryUserStore) Create(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Handle == user.Handle {
+			return ErrHandleTaken
+		}
+	}
+	s.users = append(s.users, copyUser(user))
+	return nil
+}
+
+func (s *MemoryUserStore) find(match func(*models.User) bool) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if match(u) {
+			return copyUser(u), nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (s *MemoryUserStore) GetByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	return s.find(func(u *models.User) bool { return u.ID == id })
+}
+
+func (s *MemoryUserStore) GetByHandle(ctx context.Context, handle string) (*models.User, error) {
+	return s.find(func(u *models.User) bool { return u.Handle == handle })
+}
+
+func (s *MemoryUserStore) GetByInvite(ctx context.Context, inviteHash string) (*models.User, error) {
+	if inviteHash == "" {
+		return nil, ErrUserNotFound
+	}
+	return s.find(func(u *models.User) bool { return u.InviteHash == inviteHash })
+}
+
+func (s *MemoryUserStore) GetBySubject(ctx context.Context, subject string) (*models.User, error) {
+	if subject == "" {
+		return nil, ErrUserNotFound
+	}
+	return s.find(func(u *models.User) bool { return u.OIDCSubject == subject })
+}
+
+func (s *MemoryUserStore) List(ctx context.Context) ([]*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]*models.User, len(s.users))
+	for i, u := range s.users {
+		users[i] = copyUser(u)
+	}
+	slices.SortFunc(users, func(a, b *models.User) int { return strings.Compare(a.Handle, b.Handle) })
+	return users, nil
+}
+
+func (s *MemoryUserStore) Update(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, u := range s.users {
+		if u.ID == user.ID {
+			s.users[i] = copyUser(user)
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+func (s *MemoryUserStore) Delete(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users = slices.DeleteFunc(s.users, func(u *models.User) bool { return u.ID == id })
+	return nil
+}
+
+func copyUser(u *models.User) *models.User {
+	c := *u
+	c.RecoveryCodes = slices.Clone(u.RecoveryCodes)
+	return &c
+}
@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"microblog/pkg/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrTokenNotFound = errors.New("api token not found")
+
+// APITokenStore keeps the API tokens of users, looked up by the hash of the
+// token.
+type APITokenStore interface {
+	Create(ctx context.Context, token *models.APIToken) error
+	// GetByHash returns ErrTokenNotFound for unknown tokens. Expired tokens
+	// are returned until they are deleted.
+	GetByHash(ctx context.Context, hash string) (*models.APIToken, error)
+	// List returns every token, newest first.
+	List(ctx context.Context) ([]*models.APIToken, error)
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]*models.APIToken, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	// Touch records that the token was used at usedAt.
+	Touch(ctx context.Context, id uuid.UUID, usedAt time.Time) error
+}
@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"microblog/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrHandleTaken  = errors.New("handle is already taken")
+)
+
+// UserStore persists the accounts which can sign in to the admin area.
+// Lookups of a missing user return ErrUserNotFound.
+type UserStore interface {
+	// Create returns ErrHandleTaken when another user has the handle.
+	Create(ctx context.Context, user *models.User) error
+	GetByID(ctx context.Context, id uuid.UUID) (*models.User, error)
+	GetByHandle(ctx context.Context, handle string) (*models.User, error)
+	// GetByInvite finds the user invited with the token hashed to
+	// inviteHash, whether or not the invite has expired.
+	GetByInvite(ctx context.Context, inviteHash string) (*models.User, error)
+	// GetBySubject finds the user who signs in with the OpenID Connect
+	// subject.
+	GetBySubject(ctx context.Context, subject string) (*models.User, error)
+	// List returns every user ordered by handle.
+	List(ctx context.Context) ([]*models.User, error)
+	Update(ctx context.Context, user *models.User) error
+	// Delete removes the user. Their posts are kept without an author.
+	Delete(ctx context.Context, id uuid.UUID) error
+}
@@ -0,0 +1,132 @@
+package security
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is the security headers sent with a group of routes.
+type Policy struct {
+	// CSP is the Content-Security-Policy. The nonce of each request is added
+	// to its script-src and style-src, along with frame-ancestors and the
+	// report URI. No policy is sent when it is empty.
+	CSP string
+	// FrameAncestors are the sources allowed to frame the pages, such as
+	// 'none' or 'self'. Browsers without CSP get the matching
+	// X-Frame-Options.
+	FrameAncestors    string
+	ReferrerPolicy    string
+	PermissionsPolicy string
+	// HSTS is the max-age of Strict-Transport-Security, which is not sent
+	// when it is zero.
+	HSTS time.Duration
+	// ReportOnly sends the CSP to be reported on instead of enforced.
+	ReportOnly bool
+	// ReportURI is where browsers send violations of the CSP.
+	ReportURI string
+}
+
+type nonceKey struct{}
+
+// NewNonce returns a random nonce for the inline scripts and styles of a
+// response. It is base64url, which templates write out as is.
+func NewNonce() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func WithNonce(ctx context.Context, nonce string) context.Context {
+	return context.WithValue(ctx, nonceKey{}, nonce)
+}
+
+// Nonce returns the nonce of the request ctx belongs to, or "" outside one.
+func Nonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(nonceKey{}).(string)
+	return nonce
+}
+
+// Set sets the headers of p on w, allowing inline scripts and styles which
+// carry nonce.
+func (p Policy) Set(w http.ResponseWriter, nonce string) {
+	h := w.Header()
+	h.Set("X-Content-Type-Options", "nosniff")
+	if p.HSTS > 0 {
+		h.Set("Strict-Transport-Security", "max-age="+strconv.FormatInt(int64(p.HSTS.Seconds()), 10))
+	}
+	if p.ReferrerPolicy != "" {
+		h.Set("Referrer-Policy", p.ReferrerPolicy)
+	}
+	if p.PermissionsPolicy != "" {
+		h.Set("Permissions-Policy", p.PermissionsPolicy)
+	}
+	switch p.FrameAncestors {
+	case "'none'":
+		h.Set("X-Frame-Options", "DENY")
+	case "'self'":
+		h.Set("X-Frame-Options", "SAMEORIGIN")
+	}
+	if csp := p.csp(nonce); csp != "" {
+		name := "Content-Security-Policy"
+		if p.ReportOnly {
+			name = "Content-Security-Policy-Report-Only"
+		}
+		h.Set(name, csp)
+	}
+}
+
+func (p Policy) csp(nonce string) string {
+	if p.CSP == "" {
+		return ""
+	}
+	var directives []string
+	for _, directive := range strings.Split(p.CSP, ";") {
+		directive = strings.TrimSpace(directive)
+		if directive == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(directive, " ")
+		if nonce != "" && (name == "script-src" || name == "style-src") {
+			directive += " 'nonce-" + nonce + "'"
+		}
+		directives = append(directives, directive)
+	}
+	if p.FrameAncestors != "" {
+		directives = append(directives, "frame-ancestors "+p.FrameAncestors)
+	}
+	if p.ReportURI != "" {
+		directives = append(directives, "report-uri "+p.ReportURI)
+	}
+	return strings.Join(directives, "; ")
+}
+
+// Allow adds sources to directive of the CSP. A missing fetch directive
+// starts from the sources of default-src, which it would fall back to, and
+// other missing directives allow everything already.
+func (p *Policy) Allow(directive string, sources ...string) {
+	directives := strings.Split(p.CSP, ";")
+	fallback := -1
+	for i, d := range directives {
+		name, _, _ := strings.Cut(strings.TrimSpace(d), " ")
+		switch name {
+		case directive:
+			directives[i] = strings.TrimRight(d, " ") + " " + strings.Join(sources, " ")
+			p.CSP = strings.Join(directives, ";")
+			return
+		case "default-src":
+			fallback = i
+		}
+	}
+	if fallback < 0 || !strings.HasSuffix(directive, "-src") {
+		return
+	}
+	_, defaults, _ := strings.Cut(strings.TrimSpace(directives[fallback]), " ")
+	p.CSP = strings.TrimSuffix(strings.TrimSpace(p.CSP), ";") + "; " + strings.Join(append([]string{directive, defaults}, sources...), " ")
+}
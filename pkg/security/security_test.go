@@ -0,0 +1,67 @@
+package security_test
+
+import (
+	"context"
+	"microblog/pkg/security"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSet(t *testing.T) {
+	policy := security.Policy{
+		CSP:               "default-src 'self'; script-src 'self';style-src 'self' https://cdn.example.com; img-src 'self' data:;",
+		FrameAncestors:    "'none'",
+		ReferrerPolicy:    "same-origin",
+		PermissionsPolicy: "camera=()",
+		HSTS:              365 * 24 * time.Hour,
+		ReportURI:         "/csp-report",
+	}
+
+	w := httptest.NewRecorder()
+	policy.Set(w, "abc123")
+	assert.Equal(t, "default-src 'self'; script-src 'self' 'nonce-abc123'; style-src 'self' https://cdn.example.com 'nonce-abc123'; img-src 'self' data:; frame-ancestors 'none'; report-uri /csp-report",
+		w.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "max-age=31536000", w.Header().Get("Strict-Transport-Security"))
+	assert.Equal(t, "same-origin", w.Header().Get("Referrer-Policy"))
+	assert.Equal(t, "camera=()", w.Header().Get("Permissions-Policy"))
+	assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+
+	policy.ReportOnly = true
+	policy.FrameAncestors = "'self'"
+	policy.HSTS = 0
+	w = httptest.NewRecorder()
+	policy.Set(w, "")
+	assert.Empty(t, w.Header().Get("Content-Security-Policy"))
+	assert.NotContains(t, w.Header().Get("Content-Security-Policy-Report-Only"), "nonce")
+	assert.Equal(t, "SAMEORIGIN", w.Header().Get("X-Frame-Options"))
+	assert.Empty(t, w.Header().Get("Strict-Transport-Security"))
+}
+
+func TestAllow(t *testing.T) {
+	policy := security.Policy{CSP: "default-src 'self'; form-action 'self'"}
+	policy.Allow("form-action", "https://id.example.com")
+	policy.Allow("frame-src", "https://www.youtube.com")
+	policy.Allow("base-uri", "https://example.com")
+	assert.Equal(t, "default-src 'self'; form-action 'self' https://id.example.com; frame-src 'self' https://www.youtube.com", policy.CSP)
+
+	policy = security.Policy{CSP: "script-src 'self'"}
+	policy.Allow("frame-src", "https://www.youtube.com")
+	assert.Equal(t, "script-src 'self'", policy.CSP, "frames are allowed from anywhere without default-src")
+}
+
+func TestNonce(t *testing.T) {
+	a, err := security.NewNonce()
+	require.NoError(t, err)
+	b, err := security.NewNonce()
+	require.NoError(t, err)
+	assert.Len(t, a, 24)
+	assert.NotEqual(t, a, b)
+
+	assert.Empty(t, security.Nonce(context.Background()))
+	assert.Equal(t, a, security.Nonce(security.WithNonce(context.Background(), a)))
+}
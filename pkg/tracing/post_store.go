@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"context"
+	"microblog/pkg/models"
+	"microblog/pkg/repository"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// postStore starts a span for every operation of a PostStore.
+type postStore struct {
+	repository.PostStore
+}
+
+// InstrumentPostStore wraps store so its operations are traced. Readiness
+// checks are passed through untraced when store has them.
+func InstrumentPostStore(store repository.PostStore) repository.PostStore {
+	s := &postStore{PostStore: store}
+	if checker, ok := store.(repository.Checker); ok {
+		return &checkedPostStore{postStore: s, Checker: checker}
+	}
+	return s
+}
+
+type checkedPostStore struct {
+	*postStore
+	repository.Checker
+}
+
+func startStore(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("db.operation.name", operation))
+	return Start(ctx, "PostStore."+operation, attrs...)
+}
+
+// endStore takes a pointer to the named error result of the caller, so it is
+// read once the operation has returned.
+func endStore(span trace.Span, err *error) {
+	End(span, *err)
+}
+
+func (s *postStore) Create(ctx context.Context, blogPost *models.BlogPost) (err error) {
+	ctx, span := startStore(ctx, "Create", attribute.String("post.id", blogPost.ID.String()))
+	defer endStore(span, &err)
+	return s.PostStore.Create(ctx, blogPost)
+}
+
+func (s *postStore) GetAll(ctx context.Context) (blogPosts []*models.BlogPost, err error) {
+	ctx, span := startStore(ctx, "GetAll")
+	defer endStore(span, &err)
+	return s.PostStore.GetAll(ctx)
+}
+
+func (s *postStore) GetByID(ctx context.Context, id uuid.UUID) (blogPost *models.BlogPost, err error) {
+	ctx, span := startStore(ctx, "GetByID", attribute.String("post.id", id.String()))
+	defer endStore(span, &err)
+	return s.PostStore.GetByID(ctx, id)
+}
+
+func (s *postStore) GetByName(ctx context.Context, name string) (blogPost *models.BlogPost, err error) {
+	ctx, span := startStore(ctx, "GetByName", attribute.String("post.name", name))
+	defer endStore(span, &err)
+	return s.PostStore.GetByName(ctx, name)
+}
+
+func (s *postStore) FetchLast10BlogPosts(ctx context.Context) (blogPosts []*models.BlogPost, err error) {
+	ctx, span := startStore(ctx, "FetchLast10BlogPosts")
+	defer endStore(span, &err)
+	return s.PostStore.FetchLast10BlogPosts(ctx)
+}
+
+func (s *postStore) GetByAuthor(ctx context.Context, authorID uuid.UUID) (blogPosts []*models.BlogPost, err error) {
+	ctx, span := startStore(ctx, "GetByAuthor", attribute.String("author.id", authorID.String()))
+	defer endStore(span, &err)
+	return s.PostStore.GetByAuthor(ctx, authorID)
+}
+
+func (s *postStore) Delete(ctx context.Context, id uuid.UUID) (err error) {
+	ctx, span := startStore(ctx, "Delete", attribute.String("post.id", id.String()))
+	defer endStore(span, &err)
+	return s.PostStore.Delete(ctx, id)
+}
+
+func (s *postStore) Update(ctx context.Context, blogPost *models.BlogPost) (err error) {
+	ctx, span := startStore(ctx, "Update", attribute.String("post.id", blogPost.ID.String()))
+	defer endStore(span, &err)
+	return s.PostStore.Update(ctx, blogPost)
+}
@@ -0,0 +1,159 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"microblog/pkg/logging"
+	"microblog/pkg/models"
+	"microblog/pkg/render"
+	"microblog/pkg/repository"
+	"microblog/pkg/tracing"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// record installs a global tracer provider which keeps finished spans in
+// memory. Tests using it must not run in parallel.
+func record(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	_, err := tracing.Setup(context.Background(), tracing.Options{Exporter: "none"})
+	require.NoError(t, err)
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return exporter
+}
+
+func span(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, s := range spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	require.Failf(t, "span not recorded", "no span named %q in %d spans", name, len(spans))
+	return tracetest.SpanStub{}
+}
+
+func attr(s tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range s.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestMiddleware(t *testing.T) {
+	exporter := record(t)
+
+	store := tracing.InstrumentPostStore(&repository.MemoryPostStore{
+		BlogPosts: []*models.BlogPost{{Name: "hello", Title: "Hello", Content: "some **content**"}},
+	})
+	renderer := render.New(render.Policy{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /post/{name}", func(w http.ResponseWriter, r *http.Request) {
+		post, err := store.GetByName(r.Context(), r.PathValue("name"))
+		require.NoError(t, err)
+		renderer.Prepare(r.Context(), post)
+	})
+	handler := logging.Middleware(slog.New(slog.DiscardHandler), tracing.Middleware(mux))
+
+	req := httptest.NewRequest(http.MethodGet, "/post/hello", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set(logging.RequestIDHeader, "req-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	server := span(t, spans, "GET /post/{name}")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID().String(), "the caller's trace is continued")
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+	assert.Equal(t, "GET /post/{name}", attr(server, "http.route").AsString())
+	assert.Equal(t, "req-1", attr(server, "request.id").AsString())
+
+	get := span(t, spans, "PostStore.GetByName")
+	assert.Equal(t, server.SpanContext.SpanID(), get.Parent.SpanID())
+	assert.Equal(t, "hello", attr(get, "post.name").AsString())
+
+	prepare := span(t, spans, "render.Prepare")
+	assert.Equal(t, server.SpanContext.SpanID(), prepare.Parent.SpanID())
+	for _, stage := range []string{"render.content", "render.title", "render.excerpt"} {
+		assert.Equal(t, prepare.SpanContext.SpanID(), span(t, spans, stage).Parent.SpanID(), stage)
+	}
+}
+
+func TestMiddlewareUnmatched(t *testing.T) {
+	exporter := record(t)
+
+	handler := tracing.Middleware(http.NewServeMux())
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/nowhere", nil))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "POST", spans[0].Name, "unrouted paths do not become span names")
+}
+
+type failingStore struct {
+	repository.MemoryPostStore
+}
+
+func (failingStore) Delete(context.Context, uuid.UUID) error {
+	return errors.New("connection reset")
+}
+
+func (failingStore) Ping(context.Context) error        { return nil }
+func (failingStore) CheckSchema(context.Context) error { return nil }
+
+func TestInstrumentPostStore(t *testing.T) {
+	exporter := record(t)
+
+	store := tracing.InstrumentPostStore(&failingStore{})
+	_, ok := store.(repository.Checker)
+	assert.True(t, ok, "readiness checks are passed through")
+
+	id := uuid.New()
+	assert.EqualError(t, store.Delete(context.Background(), id), "connection reset")
+
+	deleted := span(t, exporter.GetSpans(), "PostStore.Delete")
+	assert.Equal(t, codes.Error, deleted.Status.Code)
+	assert.Equal(t, "connection reset", deleted.Status.Description)
+	assert.Equal(t, id.String(), attr(deleted, "post.id").AsString())
+	assert.Equal(t, "Delete", attr(deleted, "db.operation.name").AsString())
+
+	_, ok = tracing.InstrumentPostStore(&repository.MemoryPostStore{}).(repository.Checker)
+	assert.False(t, ok)
+}
+
+func TestSetup(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	var buf bytes.Buffer
+	shutdown, err := tracing.Setup(context.Background(), tracing.Options{Exporter: "stdout", SampleRatio: 1, Stdout: &buf})
+	require.NoError(t, err)
+
+	_, s := tracing.Start(context.Background(), "work")
+	s.End()
+	require.NoError(t, shutdown(context.Background()))
+	assert.Contains(t, buf.String(), `"Name":"work"`)
+	assert.Contains(t, buf.String(), `"Value":"microblog"`, "spans carry the service name")
+
+	_, err = tracing.Setup(context.Background(), tracing.Options{Exporter: "jaeger"})
+	assert.EqualError(t, err, `unknown trace exporter "jaeger", use none, stdout or otlp`)
+}
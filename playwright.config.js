@@ -8,10 +8,6 @@ module.exports = defineConfig({
   },
   use: {
     baseURL: "http://127.0.0.1:18080",
-    httpCredentials: {
-      username: "foo",
-      password: "foo",
-    },
     trace: "on-first-retry",
   },
   webServer: {
@@ -8,4 +8,122 @@ CREATE TABLE IF NOT EXISTS blog (
   created_at TIMESTAMPTZ,
   updated_at TIMESTAMPTZ,
   PRIMARY KEY (blog_id)
-);
\ No newline at end of file
+);
+
+-- Rendered HTML, only populated when rendered output is persisted
+ALTER TABLE blog ADD COLUMN IF NOT EXISTS blog_title_html TEXT;
+ALTER TABLE blog ADD COLUMN IF NOT EXISTS blog_post_html TEXT;
+ALTER TABLE blog ADD COLUMN IF NOT EXISTS blog_excerpt_html TEXT;
+
+-- Optional summary shown on listings instead of an automatic excerpt
+ALTER TABLE blog ADD COLUMN IF NOT EXISTS blog_summary TEXT;
+
+-- Optional cover image URL and description used for link previews
+ALTER TABLE blog ADD COLUMN IF NOT EXISTS blog_cover_image TEXT;
+ALTER TABLE blog ADD COLUMN IF NOT EXISTS blog_description TEXT;
+
+-- Uploaded media, addressed by the SHA-256 hash of the content which is kept
+-- in a large object
+CREATE TABLE IF NOT EXISTS media (
+  media_hash character(64) NOT NULL,
+  media_filename TEXT NOT NULL,
+  media_content_type character varying(255) NOT NULL,
+  media_size BIGINT NOT NULL,
+  media_oid oid NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (media_hash)
+);
+
+-- Resized variants of uploaded images reference the original's hash
+ALTER TABLE media ADD COLUMN IF NOT EXISTS media_original character(64);
+ALTER TABLE media ADD COLUMN IF NOT EXISTS media_width INT;
+ALTER TABLE media ADD COLUMN IF NOT EXISTS media_height INT;
+CREATE INDEX IF NOT EXISTS media_original_idx ON media (media_original);
+
+-- Accounts which can sign in to the admin area. Invited users have no
+-- password until they accept the invite.
+CREATE TABLE IF NOT EXISTS users (
+  user_id uuid NOT NULL,
+  user_handle character varying(64) NOT NULL,
+  user_name TEXT NOT NULL,
+  user_password_hash TEXT NOT NULL,
+  user_role character varying(16) NOT NULL,
+  user_disabled BOOLEAN NOT NULL DEFAULT false,
+  user_invite_hash character(64),
+  user_invite_expires TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (user_id),
+  UNIQUE (user_handle)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS users_invite_idx ON users (user_invite_hash);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS user_totp_secret TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS user_totp_last_step BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS user_recovery_codes TEXT[] NOT NULL DEFAULT '{}';
+-- Users who sign in with single sign-on have no password
+ALTER TABLE users ADD COLUMN IF NOT EXISTS user_oidc_subject TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS users_oidc_subject_idx ON users (user_oidc_subject);
+
+-- Posts from before there were users have no author
+ALTER TABLE blog ADD COLUMN IF NOT EXISTS blog_author_id uuid REFERENCES users (user_id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS blog_author_idx ON blog (blog_author_id);
+
+-- Version of the renderer which produced the rendered HTML, which is
+-- rendered again when it is stale
+ALTER TABLE blog ADD COLUMN IF NOT EXISTS blog_render_version TEXT;
+
+-- Signed in browsers, keyed by the SHA-256 hash of the ID in the cookie
+CREATE TABLE IF NOT EXISTS sessions (
+  session_id_hash character(64) NOT NULL,
+  user_id uuid NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+  session_csrf_token TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (session_id_hash)
+);
+CREATE INDEX IF NOT EXISTS sessions_expires_idx ON sessions (expires_at);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS session_pending BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS session_attempts INTEGER NOT NULL DEFAULT 0;
+
+-- API tokens of users, keyed by the SHA-256 hash of the token
+CREATE TABLE IF NOT EXISTS api_tokens (
+  token_id uuid NOT NULL,
+  user_id uuid NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+  token_name TEXT NOT NULL,
+  token_hash character(64) NOT NULL,
+  token_scopes TEXT[] NOT NULL,
+  expires_at TIMESTAMPTZ,
+  last_used_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (token_id),
+  UNIQUE (token_hash)
+);
+CREATE INDEX IF NOT EXISTS api_tokens_user_idx ON api_tokens (user_id);
+
+-- Administrative actions, which can only be appended to. The actor's handle
+-- is copied so entries outlive the user.
+CREATE TABLE IF NOT EXISTS audit_log (
+  audit_id BIGSERIAL NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL,
+  actor_id uuid,
+  actor_handle TEXT NOT NULL,
+  audit_via TEXT NOT NULL,
+  audit_action character varying(32) NOT NULL,
+  audit_target TEXT NOT NULL,
+  audit_ip TEXT NOT NULL,
+  request_id TEXT NOT NULL,
+  audit_before TEXT NOT NULL,
+  audit_after TEXT NOT NULL,
+  PRIMARY KEY (audit_id)
+);
+CREATE INDEX IF NOT EXISTS audit_log_created_idx ON audit_log (created_at);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor_handle);
+CREATE INDEX IF NOT EXISTS audit_log_target_idx ON audit_log (audit_target);
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+  FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_append_only();
@@ -4,6 +4,17 @@ function slugify(title) {
   return title.replaceAll(" ", "-").toLowerCase().replace(/[^a-z0-9\s]+/g, "");
 }
 
+// fillContent sets the post content, which SimpleMDE hides behind its editor.
+async function fillContent(page, value) {
+  await page.evaluate((value) => {
+    const textarea = document.getElementById("content");
+    textarea.value = value;
+    if (window.simplemde) {
+      window.simplemde.value(value);
+    }
+  }, value);
+}
+
 test("create, render, update, and delete a blog post", async ({ page, baseURL }) => {
   const title = `Playwright happy path ${Date.now()}`;
   const content = "Initial content from Playwright.";
@@ -12,8 +23,14 @@ test("create, render, update, and delete a blog post", async ({ page, baseURL })
   const slug = slugify(title);
 
   await page.goto("/admin/post/new");
+  await expect(page).toHaveURL(/\/login/);
+  await page.getByLabel("Handle:").fill("foo");
+  await page.getByLabel("Password:").fill("foo");
+  await page.getByRole("button", { name: "Sign in" }).click();
+  await expect(page).toHaveURL(/\/admin\/post\/new$/);
+
   await page.getByLabel("Title:").fill(title);
-  await page.getByLabel("Content:").fill(content);
+  await fillContent(page, content);
   const createResponsePromise = page.waitForResponse((response) =>
     response.url().endsWith("/api/post/new") && response.request().method() === "POST"
   );
@@ -35,13 +52,7 @@ test("create, render, update, and delete a blog post", async ({ page, baseURL })
 
   await page.goto(`/admin/post/edit/${createdPost.Name}`);
   await page.getByLabel("Title:").fill(updatedTitle);
-  await page.evaluate((value) => {
-    const textarea = document.getElementById("content");
-    textarea.value = value;
-    if (window.simplemde) {
-      window.simplemde.value(value);
-    }
-  }, updatedContent);
+  await fillContent(page, updatedContent);
   await page.getByRole("button", { name: "Submit" }).click();
 
   await expect(page.locator("body")).toContainText("Post updated successfully!");